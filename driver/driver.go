@@ -124,6 +124,10 @@ type FlagSet interface {
 // the specified duration and timeout. It returns the fetched
 // profile and a string indicating a URL from where the profile
 // was fetched, which may be different than src.
+//
+// Profiles are repaired by -repair after they are fetched, so they
+// should be parsed with profile.ParseDataUnchecked for malformed ones
+// to reach it.
 type Fetcher interface {
 	Fetch(src string, duration, timeout time.Duration) (*profile.Profile, string, error)
 }
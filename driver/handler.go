@@ -6,7 +6,14 @@ import (
 	internaldriver "github.com/lemonlinger/pprof/internal/driver"
 )
 
-func Handler(prefix, path string) http.Handler {
+// Handler returns an http.Handler serving the web interface for
+// profiles of the running program under path. Besides cpu and heap
+// profiles, the profiles of the given recorders can be selected, using
+// the recorder names as profile types.
+func Handler(prefix, path string, recorders ...*Recorder) http.Handler {
 	h := internaldriver.NewWebHandler(prefix, path)
+	for _, r := range recorders {
+		h.AddProfileType(r.Name(), r.Profile)
+	}
 	return h
 }
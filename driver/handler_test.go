@@ -0,0 +1,52 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lemonlinger/pprof/profile"
+)
+
+func TestHandlerRecorder(t *testing.T) {
+	r := NewRecorder("io", []*profile.ValueType{{Type: "bytes", Unit: "bytes"}, {Type: "events", Unit: "count"}}, 1)
+	for i := 0; i < 10; i++ {
+		sendBytes(r, 10)
+	}
+	h := Handler("", "/ui/", r)
+
+	get := func(path string) (int, string) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code, w.Body.String()
+	}
+
+	// The recorder is offered as a type of profile to create.
+	if _, body := get("/ui/top?si=bytes"); !strings.Contains(body, `<option value="io">io</option>`) {
+		t.Errorf("profile types do not include io:\n%s", body)
+	}
+	if code, body := get("/ui/genprof?pt=io"); code != http.StatusTemporaryRedirect {
+		t.Fatalf("genprof: got status %d:\n%s", code, body)
+	}
+	_, body := get("/ui/top?si=bytes")
+	for _, want := range []string{"-io</option>", "sendBytes", `"Flat":100`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("top view of the io profile does not contain %q:\n%s", want, body)
+		}
+	}
+}
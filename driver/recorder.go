@@ -0,0 +1,162 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lemonlinger/pprof/profile"
+)
+
+const (
+	// recorderMaxDepth is the maximum number of frames of the call
+	// stacks captured by a Recorder.
+	recorderMaxDepth = 64
+	// recorderShards is the number of independently locked maps used
+	// by a Recorder to reduce contention.
+	recorderShards = 16
+)
+
+// Recorder attributes application-defined costs, such as bytes sent or
+// rows read, to the call stacks where they are incurred. User code
+// calls Record with the values of an event, and the aggregated values
+// are available as a profile through Profile.
+//
+// A Recorder is safe for concurrent use.
+type Recorder struct {
+	name        string
+	sampleTypes []*profile.ValueType
+	rate        uint64
+	start       time.Time
+
+	events uint64 // Number of calls to Record, used for sampling.
+	shards [recorderShards]recorderShard
+}
+
+type recorderShard struct {
+	mu     sync.Mutex
+	stacks map[recorderStack][]int64
+}
+
+type recorderStack [recorderMaxDepth]uintptr
+
+// NewRecorder returns a Recorder named name whose events have values of
+// the given sample types. If rate is greater than one, only one in rate
+// events is recorded, and its values are scaled up by rate to estimate
+// the values of all events.
+func NewRecorder(name string, sampleTypes []*profile.ValueType, rate int) *Recorder {
+	if rate < 1 {
+		rate = 1
+	}
+	r := &Recorder{
+		name:        name,
+		sampleTypes: sampleTypes,
+		rate:        uint64(rate),
+		start:       time.Now(),
+	}
+	for i := range r.shards {
+		r.shards[i].stacks = make(map[recorderStack][]int64)
+	}
+	return r
+}
+
+// Name returns the name of the recorder, which is used as its profile
+// type in Handler.
+func (r *Recorder) Name() string {
+	return r.name
+}
+
+// Record records an event with the given values, one for each sample
+// type of the recorder, at the call stack of its caller.
+func (r *Recorder) Record(values ...int64) {
+	if len(values) != len(r.sampleTypes) {
+		panic(fmt.Sprintf("recorder %s: event has %d values vs. %d types", r.name, len(values), len(r.sampleTypes)))
+	}
+	if r.rate > 1 && atomic.AddUint64(&r.events, 1)%r.rate != 0 {
+		return
+	}
+
+	var stack recorderStack
+	// Skip runtime.Callers and Record.
+	n := runtime.Callers(2, stack[:])
+	var h uintptr
+	for _, pc := range stack[:n] {
+		h = h*31 + pc
+	}
+
+	s := &r.shards[h%recorderShards]
+	s.mu.Lock()
+	acc := s.stacks[stack]
+	if acc == nil {
+		acc = make([]int64, len(values))
+		s.stacks[stack] = acc
+	}
+	for i, v := range values {
+		acc[i] += v * int64(r.rate)
+	}
+	s.mu.Unlock()
+}
+
+// Profile returns a profile of the values recorded so far.
+func (r *Recorder) Profile() (*profile.Profile, error) {
+	b := profile.NewBuilder(r.sampleTypes)
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mu.Lock()
+		for stack, values := range s.stacks {
+			if err := b.Add(profile.Frames(frames(stack)...), values, nil); err != nil {
+				s.mu.Unlock()
+				return nil, err
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	p := b.Profile()
+	if len(r.sampleTypes) > 0 {
+		p.PeriodType = &profile.ValueType{Type: r.sampleTypes[0].Type, Unit: r.sampleTypes[0].Unit}
+		p.Period = int64(r.rate)
+	}
+	p.TimeNanos = r.start.UnixNano()
+	p.DurationNanos = time.Since(r.start).Nanoseconds()
+	return p, nil
+}
+
+// frames returns the frames of a captured call stack, leaf first.
+func frames(stack recorderStack) []profile.Frame {
+	n := 0
+	for n < len(stack) && stack[n] != 0 {
+		n++
+	}
+	var fs []profile.Frame
+	it := runtime.CallersFrames(stack[:n])
+	for {
+		f, more := it.Next()
+		if f.Function != "" {
+			fs = append(fs, profile.Frame{
+				Function: f.Function,
+				File:     f.File,
+				Line:     int64(f.Line),
+			})
+		}
+		if !more {
+			return fs
+		}
+	}
+}
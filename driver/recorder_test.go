@@ -0,0 +1,86 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lemonlinger/pprof/profile"
+)
+
+func sendBytes(r *Recorder, n int64) {
+	r.Record(n, 1)
+}
+
+func readRows(r *Recorder, n int64) {
+	r.Record(n, 1)
+}
+
+func TestRecorder(t *testing.T) {
+	for _, tc := range []struct {
+		rate, goroutines int
+	}{
+		{1, 8},
+		// Sampling is only exact with sequential events.
+		{4, 1},
+	} {
+		rate := tc.rate
+		r := NewRecorder("io", []*profile.ValueType{{Type: "bytes", Unit: "bytes"}, {Type: "events", Unit: "count"}}, rate)
+		var wg sync.WaitGroup
+		for i := 0; i < tc.goroutines; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 800/tc.goroutines; j++ {
+					sendBytes(r, 10)
+				}
+				for j := 0; j < 800/tc.goroutines; j++ {
+					readRows(r, 3)
+				}
+			}()
+		}
+		wg.Wait()
+
+		p, err := r.Profile()
+		if err != nil {
+			t.Fatalf("rate %d: Profile: %v", rate, err)
+		}
+		if err := p.CheckValid(); err != nil {
+			t.Fatalf("rate %d: CheckValid: %v", rate, err)
+		}
+		if p.Period != int64(rate) {
+			t.Errorf("rate %d: got period %d", rate, p.Period)
+		}
+
+		totals := make(map[string][]int64)
+		for _, s := range p.Sample {
+			leaf := s.Location[0].Line[0].Function.Name
+			leaf = leaf[strings.LastIndex(leaf, ".")+1:]
+			if totals[leaf] == nil {
+				totals[leaf] = make([]int64, 2)
+			}
+			totals[leaf][0] += s.Value[0]
+			totals[leaf][1] += s.Value[1]
+		}
+		if got := totals["sendBytes"]; len(got) != 2 || got[0] != 8000 || got[1] != 800 {
+			t.Errorf("rate %d: got sendBytes totals %v, want [8000 800]", rate, got)
+		}
+		if got := totals["readRows"]; len(got) != 2 || got[0] != 2400 || got[1] != 800 {
+			t.Errorf("rate %d: got readRows totals %v, want [2400 800]", rate, got)
+		}
+	}
+}
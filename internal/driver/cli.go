@@ -17,11 +17,13 @@ package driver
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strings"
 
 	"github.com/lemonlinger/pprof/internal/binutils"
 	"github.com/lemonlinger/pprof/internal/plugin"
+	"github.com/lemonlinger/pprof/profile"
 )
 
 type source struct {
@@ -38,6 +40,16 @@ type source struct {
 	HTTPHostport       string
 	HTTPDisableBrowser bool
 	Comment            string
+	Repair             bool
+	MergeMode          string
+
+	// LabelSources is the key of the label identifying the source of
+	// each sample, if set, and LabelSourcesTemplate its value.
+	LabelSources         string
+	LabelSourcesTemplate string
+
+	// Redact holds the rules to redact the profile with, if any.
+	Redact *profile.RedactRules
 }
 
 // parseFlags parses the command lines through the specified flags package
@@ -53,6 +65,10 @@ func parseFlags(o *plugin.Options) (*source, []string, error) {
 	flagBuildID := flag.String("buildid", "", "Override build id for first mapping")
 	flagTimeout := flag.Int("timeout", -1, "Timeout in seconds for fetching a profile")
 	flagAddComment := flag.String("add_comment", "", "Annotation string to record in the profile")
+	flagRepair := flag.Bool("repair", false, "Repair malformed profiles instead of rejecting them")
+	flagMergeMode := flag.String("merge_mode", "strict", "How to merge profiles with different sample types: strict, union or intersection")
+	flagLabelSources := flag.String("label_sources", "", "Label samples with their source, as key[=template]")
+	flagRedact := flag.String("redact", "", "File with rules to redact sensitive information from the profile")
 	// CPU profile options
 	flagSeconds := flag.Int("seconds", -1, "Length of time for dynamic profiles")
 	// Heap profile options
@@ -124,6 +140,31 @@ func parseFlags(o *plugin.Options) (*source, []string, error) {
 		return nil, nil, errors.New("-no_browser only makes sense with -http")
 	}
 
+	switch *flagMergeMode {
+	case "strict", "union", "intersection":
+	default:
+		return nil, nil, fmt.Errorf("unknown -merge_mode %q, must be strict, union or intersection", *flagMergeMode)
+	}
+
+	labelKey, labelTemplate := *flagLabelSources, "{source}"
+	if i := strings.Index(labelKey, "="); i >= 0 {
+		labelKey, labelTemplate = labelKey[:i], labelKey[i+1:]
+	}
+	if labelKey == "" && *flagLabelSources != "" {
+		return nil, nil, fmt.Errorf("missing label key in -label_sources %q", *flagLabelSources)
+	}
+
+	var redact *profile.RedactRules
+	if *flagRedact != "" {
+		data, err := ioutil.ReadFile(*flagRedact)
+		if err != nil {
+			return nil, nil, err
+		}
+		if redact, err = profile.ParseRedactRules(data); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", *flagRedact, err)
+		}
+	}
+
 	si := pprofVariables["sample_index"].value
 	si = sampleIndex(flagTotalDelay, si, "delay", "-total_delay", o.UI)
 	si = sampleIndex(flagMeanDelay, si, "delay", "-mean_delay", o.UI)
@@ -148,6 +189,13 @@ func parseFlags(o *plugin.Options) (*source, []string, error) {
 		HTTPHostport:       *flagHTTP,
 		HTTPDisableBrowser: *flagNoBrowser,
 		Comment:            *flagAddComment,
+		Repair:             *flagRepair,
+		MergeMode:          *flagMergeMode,
+
+		LabelSources:         labelKey,
+		LabelSourcesTemplate: labelTemplate,
+
+		Redact: redact,
 	}
 
 	if err := source.addBaseProfiles(*flagBase, *flagDiffBase); err != nil {
@@ -166,6 +214,14 @@ func parseFlags(o *plugin.Options) (*source, []string, error) {
 	return source, cmd, nil
 }
 
+// labelDiffSources reports whether the samples of each profile should
+// be labeled with the profile they come from, so that the diff report
+// can test the significance of the differences between several base
+// and source profiles, given with either -diff_base or -base.
+func (source *source) labelDiffSources() bool {
+	return len(source.Sources) > 1 && len(source.Base) > 1
+}
+
 // addBaseProfiles adds the list of base profiles or diff base profiles to
 // the source. This function will return an error if both base and diff base
 // profiles are specified.
@@ -319,10 +375,21 @@ var usageMsgSrc = "\n\n" +
 	"    -buildid              Override build id for main binary\n" +
 	"    -add_comment          Free-form annotation to add to the profile\n" +
 	"                          Displayed on some reports or with pprof -comments\n" +
+	"    -repair               Repair malformed profiles instead of rejecting them\n" +
+	"    -merge_mode=          How to merge profiles with different sample types\n" +
+	"      strict                Require identical sample types (default)\n" +
+	"      union                 Keep all sample types, filling missing values with zero\n" +
+	"      intersection          Keep only the sample types common to all profiles\n" +
+	"    -label_sources=key    Label each sample with the source it comes from\n" +
+	"    -label_sources=key=template\n" +
+	"                          Label each sample with a template, which can refer to\n" +
+	"                          {source}, {host}, {file} and {index} of its source\n" +
+	"    -redact=file          Redact function names, paths and labels as set in file\n" +
 	"    -diff_base source     Source of base profile for comparison\n" +
 	"    -base source          Source of base profile for profile subtraction\n" +
 	"    profile.pb.gz         Profile in compressed protobuf format\n" +
 	"    legacy_profile        Profile in legacy pprof format\n" +
+	"    otlp_profile          OpenTelemetry ProfilesData in protobuf format\n" +
 	"    http://host/profile   URL for profile handler to retrieve\n" +
 	"    -symbolize=           Controls source of symbol information\n" +
 	"      none                  Do not attempt symbolization\n" +
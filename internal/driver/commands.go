@@ -85,20 +85,30 @@ var interactiveMode = false
 // pprofCommands are the report generation commands recognized by pprof.
 var pprofCommands = commands{
 	// Commands that require no post-processing.
-	"comments": {report.Comments, nil, nil, false, "Output all profile comments", ""},
-	"disasm":   {report.Dis, nil, nil, true, "Output assembly listings annotated with samples", listHelp("disasm", true)},
-	"dot":      {report.Dot, nil, nil, false, "Outputs a graph in DOT format", reportHelp("dot", false, true)},
-	"list":     {report.List, nil, nil, true, "Output annotated source for functions matching regexp", listHelp("list", false)},
-	"peek":     {report.Tree, nil, nil, true, "Output callers/callees of functions matching regexp", "peek func_regex\nDisplay callers and callees of functions matching func_regex."},
-	"raw":      {report.Raw, nil, nil, false, "Outputs a text representation of the raw profile", ""},
-	"tags":     {report.Tags, nil, nil, false, "Outputs all tags in the profile", "tags [tag_regex]* [-ignore_regex]* [>file]\nList tags with key:value matching tag_regex and exclude ignore_regex."},
-	"text":     {report.Text, nil, nil, false, "Outputs top entries in text form", reportHelp("text", true, true)},
-	"top":      {report.Text, nil, nil, false, "Outputs top entries in text form", reportHelp("top", true, true)},
-	"traces":   {report.Traces, nil, nil, false, "Outputs all profile samples in text form", ""},
-	"tree":     {report.Tree, nil, nil, false, "Outputs a text rendering of call graph", reportHelp("tree", true, true)},
+	"comments":   {report.Comments, nil, nil, false, "Output all profile comments", ""},
+	"diff":       {report.Diff, nil, nil, false, "Outputs the largest differences against the base profile", reportHelp("diff", true, true) + "\nRequires a base profile specified with -diff_base, or several with -base."},
+	"disasm":     {report.Dis, nil, nil, true, "Output assembly listings annotated with samples", listHelp("disasm", true)},
+	"dominators": {report.Dominators, nil, nil, false, "Outputs the immediate dominator of each node and the weight it dominates", reportHelp("dominators", false, true) + "\nA node dominates another if every call path to the other node goes\nthrough it, so the weight it dominates would go away without it."},
+	"dot":        {report.Dot, nil, nil, false, "Outputs a graph in DOT format", reportHelp("dot", false, true)},
+	"hotpath":    {report.HotPath, nil, nil, false, "Outputs the path following the heaviest calls", "hotpath [focus_regex]* [-ignore_regex]*\nFollow the heaviest call from each node, starting at the heaviest root\nof the call graph, and list the nodes on the way."},
+	"list":       {report.List, nil, nil, true, "Output annotated source for functions matching regexp", listHelp("list", false)},
+	"mermaid":    {report.Mermaid, nil, nil, false, "Outputs a graph as a Mermaid flowchart", reportHelp("mermaid", false, true)},
+	"ownership":  {report.Owners, nil, nil, false, "Outputs the value of the samples of each owner", "ownership -owners=file [-owners_policy=leaf|first]\nAttribute samples to owners, as described by an owners file, and list\nthe value of the samples of each owner. Samples are also labeled with\ntheir owners, which can be used with -tagfocus=owner=name.\nThe report is not named owners, which is the option naming the owners file."},
+	"paths":      {report.Paths, nil, nil, true, "Outputs the call paths between functions matching two regexps", "paths from_regex to_regex [-nodecount=n]\nList the call paths from functions matching from_regex to functions\nmatching to_regex, heaviest first. As a command line option, the two\nregexps are given separated by a space, as in -paths='handle Write'."},
+	"peek":       {report.Tree, nil, nil, true, "Output callers/callees of functions matching regexp", "peek func_regex\nDisplay callers and callees of functions matching func_regex."},
+	"raw":        {report.Raw, nil, nil, false, "Outputs a text representation of the raw profile", "raw [-format=text|json] [>f]\nOutput the full profile as text or as JSON."},
+	"tags":       {report.Tags, nil, nil, false, "Outputs all tags in the profile", "tags [tag_regex]* [-ignore_regex]* [>file]\nList tags with key:value matching tag_regex and exclude ignore_regex."},
+	"text":       {report.Text, nil, nil, false, "Outputs top entries in text form", reportHelp("text", true, true)},
+	"top":        {report.Text, nil, nil, false, "Outputs top entries in text form", reportHelp("top", true, true)},
+	"timeline":   {report.Timeline, nil, nil, false, "Outputs sample values over time", "timeline [-time_range=start,end] [-nodecount=n]\nBucket sample values by the timestamps of their events, into nodecount\nbuckets. Requires a profile with sample timestamps."},
+	"traces":     {report.Traces, nil, nil, false, "Outputs all profile samples in text form", ""},
+	"tree":       {report.Tree, nil, nil, false, "Outputs a text rendering of call graph", reportHelp("tree", true, true)},
+	"vet":        {report.Lint, nil, nil, false, "Reports quality problems of the profile", "vet [-vet_fail]\nList problems that can lead to wrong conclusions, such as unsymbolized\nmappings or truncated stacks, with the fraction of the samples affected.\nWith -vet_fail, pprof exits with an error if any problem is found."},
 
 	// Save binary formats to a file
 	"callgrind": {report.Callgrind, nil, awayFromTTY("callgraph.out"), false, "Outputs a graph in callgrind format", reportHelp("callgrind", false, true)},
+	"gexf":      {report.GEXF, nil, awayFromTTY("gexf"), false, "Outputs a graph in GEXF format", reportHelp("gexf", false, true)},
+	"graphml":   {report.GraphML, nil, awayFromTTY("graphml"), false, "Outputs a graph in GraphML format", reportHelp("graphml", false, true)},
 	"proto":     {report.Proto, nil, awayFromTTY("pb.gz"), false, "Outputs the profile in compressed protobuf format", ""},
 	"topproto":  {report.TopProto, nil, awayFromTTY("pb.gz"), false, "Outputs top entries in compressed protobuf format", ""},
 
@@ -139,6 +149,11 @@ var pprofVariables = variables{
 	"call_tree": &variable{boolKind, "f", "", helpText(
 		"Create a context-sensitive call tree",
 		"Treat locations reached through different paths as separate.")},
+	"fold_recursion": &variable{boolKind, "f", "", helpText(
+		"Collapse recursive calls in each sample",
+		"Drop the frames between repeated calls of a location in each stack,",
+		"for direct and mutual recursion. Nodes report the maximum and average",
+		"recursion depth.")},
 
 	// Display options.
 	"relative_percentages": &variable{boolKind, "f", "", helpText(
@@ -154,6 +169,23 @@ var pprofVariables = variables{
 	"compact_labels": &variable{boolKind, "f", "", "Show minimal headers"},
 	"source_path":    &variable{stringKind, "", "", "Search path for source files"},
 	"trim_path":      &variable{stringKind, "", "", "Path to trim from source paths before search"},
+	"format": &variable{stringKind, "text", "", helpText(
+		"Encoding of the raw report",
+		"Use text for a human-readable dump or json for a document",
+		"that can be transformed and loaded back into pprof.")},
+	"cluster": &variable{stringKind, "", "", helpText(
+		"Group the nodes of graphs by package, file or object",
+		"Nodes with the same package, source file or object file are drawn",
+		"in a box labeled with their total flat and cum values. Groups of",
+		"fewer than three nodes are not drawn.")},
+	"vet_fail": &variable{boolKind, "f", "", helpText(
+		"Make the vet report fail if it finds problems",
+		"pprof then exits with a non-zero status, for use in scripts.")},
+	"max_size": &variable{intKind, "0", "", helpText(
+		"Maximum size in bytes of the proto output",
+		"If positive, the profile is trimmed to fit by dropping the samples",
+		"and labels with the lowest values and truncating deep stacks.",
+		"A comment in the profile records how much was dropped.")},
 
 	// Filtering options
 	"nodecount": &variable{intKind, "-1", "", helpText(
@@ -206,6 +238,20 @@ var pprofVariables = variables{
 	"taghide": &variable{stringKind, "", "", helpText(
 		"Skip tags matching this regexp",
 		"Discard tags that match this regexp")},
+	"owners": &variable{stringKind, "", "", helpText(
+		"File mapping code to owners",
+		"Each line has a pattern followed by owners, later lines taking",
+		"precedence. Patterns are func:<regexp>, pkg:<package> or file globs.",
+		"Samples are labeled with their owners in the owner tag.")},
+	"owners_policy": &variable{stringKind, "leaf", "", helpText(
+		"Frame that decides the owners of a sample",
+		"leaf: the owners of the leaf frame.",
+		"first: the owners of the first frame from the leaf with owners.")},
+	"time_range": &variable{stringKind, "", "", helpText(
+		"Restricts to samples with events in a time window",
+		"Use start,end syntax, where either bound may be omitted. Bounds are",
+		"durations relative to the profile start time, eg 10s,1m30s, RFC3339",
+		"times or nanoseconds since the Unix epoch. Requires sample timestamps.")},
 	// Heap profile options
 	"divide_by": &variable{floatKind, "1", "", helpText(
 		"Ratio to divide all samples before visualization",
@@ -220,6 +266,11 @@ var pprofVariables = variables{
 		"Use sample_index=i to select the ith value (starting at 0).")},
 	"normalize": &variable{boolKind, "f", "", helpText(
 		"Scales profile based on the base profile.")},
+	"diff_normalize": &variable{stringKind, "", "", helpText(
+		"Scale the base profiles of the diff report: total or duration.",
+		"With total, each base profile is scaled to the mean total of the",
+		"target profiles. With duration, it is scaled by the ratio of their",
+		"durations, comparing rates rather than values.")},
 
 	// Data sorting criteria
 	"flat": &variable{boolKind, "t", "cumulative", helpText("Sort entries based on own weight")},
@@ -233,6 +284,12 @@ var pprofVariables = variables{
 		"Aggregate at the function level.",
 		"Takes into account the filename where the function was defined.")},
 	"files": &variable{boolKind, "f", "granularity", "Aggregate at the file level."},
+	"packages": &variable{boolKind, "f", "granularity", helpText(
+		"Aggregate at the package level.",
+		"Packages are derived from Go, C++ and Java function names.")},
+	"modules": &variable{boolKind, "f", "granularity", helpText(
+		"Aggregate at the module level.",
+		"Go modules, C++ top-level namespaces or Java package prefixes.")},
 	"lines": &variable{boolKind, "f", "granularity", "Aggregate at the source code line level."},
 	"addresses": &variable{boolKind, "f", "granularity", helpText(
 		"Aggregate at the address level.",
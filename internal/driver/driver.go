@@ -25,6 +25,7 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/lemonlinger/pprof/internal/graph"
 	"github.com/lemonlinger/pprof/internal/plugin"
 	"github.com/lemonlinger/pprof/internal/report"
 	"github.com/lemonlinger/pprof/profile"
@@ -62,15 +63,34 @@ func PProf(eo *plugin.Options) error {
 func generateRawReport(p *profile.Profile, cmd []string, vars variables, o *plugin.Options) (*command, *report.Report, error) {
 	p = p.Copy() // Prevent modification to the incoming profile.
 
-	// Identify units of numeric tags in profile.
-	numLabelUnits := identifyNumLabelUnits(p, o.UI)
-
 	// Get report output format
 	c := pprofCommands[cmd[0]]
 	if c == nil {
 		panic("unexpected nil command")
 	}
 
+	// Only the diff report uses the labels identifying the profiles it
+	// compares, and records them before any filtering, which may leave
+	// some of them without samples.
+	var diffSources []report.DiffSource
+	if c.format == report.Diff {
+		diffSources = report.DiffSources(p)
+	} else {
+		var err error
+		if p, err = report.RemoveDiffSources(p); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Label samples with their owners before any filtering, so that
+	// they are attributed with full stacks.
+	if err := applyOwners(p, vars); err != nil {
+		return nil, nil, err
+	}
+
+	// Identify units of numeric tags in profile.
+	numLabelUnits := identifyNumLabelUnits(p, o.UI)
+
 	vars = applyCommandOverrides(cmd[0], c.format, vars)
 
 	// Delay focus after configuring report to get percentages on all samples.
@@ -85,7 +105,13 @@ func generateRawReport(p *profile.Profile, cmd []string, vars variables, o *plug
 		return nil, nil, err
 	}
 	ropt.OutputFormat = c.format
-	if len(cmd) == 2 {
+	ropt.DiffSources = diffSources
+	switch {
+	case c.format == report.Paths:
+		if ropt.PathFrom, ropt.PathTo, err = pathEnds(cmd[1:]); err != nil {
+			return nil, nil, err
+		}
+	case len(cmd) == 2:
 		s, err := regexp.Compile(cmd[1])
 		if err != nil {
 			return nil, nil, fmt.Errorf("parsing argument regexp %s: %v", cmd[1], err)
@@ -179,10 +205,15 @@ func applyCommandOverrides(cmd string, outputFormat int, v variables) variables
 		v.set("lines", "t")
 		// Do not force 'noinlines' to be false so that specifying
 		// "-list foo -noinlines" is supported and works as expected.
-	case "text", "top", "topproto":
+	case "text", "top", "topproto", "diff":
 		if v["nodecount"].intValue() == -1 {
 			v.set("nodecount", "0")
 		}
+	case "timeline":
+		// Nodecount is the number of buckets.
+		if v["nodecount"].intValue() == -1 {
+			v.set("nodecount", "40")
+		}
 	default:
 		if v["nodecount"].intValue() == -1 {
 			v.set("nodecount", "80")
@@ -190,7 +221,7 @@ func applyCommandOverrides(cmd string, outputFormat int, v variables) variables
 	}
 
 	switch outputFormat {
-	case report.Proto, report.Raw, report.Callgrind:
+	case report.Proto, report.Raw, report.Callgrind, report.Lint:
 		trim = false
 		v.set("addresses", "t")
 		v.set("noinlines", "f")
@@ -204,6 +235,23 @@ func applyCommandOverrides(cmd string, outputFormat int, v variables) variables
 	return v
 }
 
+// pathEnds parses the arguments of the paths command: the regexps
+// matching the callers and the callees at both ends of the paths, given
+// as two arguments or as a single one with both separated by spaces.
+func pathEnds(args []string) (from, to *regexp.Regexp, err error) {
+	ends := strings.Fields(strings.Join(args, " "))
+	if len(ends) != 2 {
+		return nil, nil, fmt.Errorf("paths requires two regexps, got %q", strings.Join(args, " "))
+	}
+	if from, err = regexp.Compile(ends[0]); err != nil {
+		return nil, nil, fmt.Errorf("parsing argument regexp %s: %v", ends[0], err)
+	}
+	if to, err = regexp.Compile(ends[1]); err != nil {
+		return nil, nil, fmt.Errorf("parsing argument regexp %s: %v", ends[1], err)
+	}
+	return from, to, nil
+}
+
 func aggregate(prof *profile.Profile, v variables) error {
 	var function, filename, linenumber, address bool
 	inlines := !v["noinlines"].boolValue()
@@ -222,8 +270,16 @@ func aggregate(prof *profile.Profile, v variables) error {
 		linenumber = true
 	case v["files"].boolValue():
 		filename = true
+	case v["packages"].boolValue():
+		renameFunctions(prof, graph.PackageName)
+		function = true
+	case v["modules"].boolValue():
+		renameFunctions(prof, graph.ModuleName)
+		function = true
 	case v["functions"].boolValue():
 		function = true
+		// Keep the files of the functions to group them by file.
+		filename = v["cluster"].value == "file"
 	case v["filefunctions"].boolValue():
 		function = true
 		filename = true
@@ -233,6 +289,20 @@ func aggregate(prof *profile.Profile, v variables) error {
 	return prof.Aggregate(inlines, function, filename, linenumber, address)
 }
 
+// renameFunctions replaces the names of the functions of a profile
+// with the package or module computed by name, so that they are
+// aggregated by it. Functions without one keep their names.
+func renameFunctions(prof *profile.Profile, name func(string) string) {
+	for _, f := range prof.Function {
+		if n := name(f.Name); n != "" {
+			f.Name = n
+		}
+		if n := name(f.SystemName); n != "" {
+			f.SystemName = n
+		}
+	}
+}
+
 func reportOptions(p *profile.Profile, numLabelUnits map[string]string, vars variables) (*report.Options, error) {
 	si, mean := vars["sample_index"].value, vars["mean"].boolValue()
 	value, meanDiv, sample, err := sampleFormat(p, si, mean)
@@ -249,8 +319,25 @@ func reportOptions(p *profile.Profile, numLabelUnits map[string]string, vars var
 		return nil, fmt.Errorf("zero divisor specified")
 	}
 
+	var diffNormalize profile.DiffNormalization
+	switch n := vars["diff_normalize"].value; n {
+	case "":
+	case "total":
+		diffNormalize = profile.DiffNormalizeTotal
+	case "duration":
+		diffNormalize = profile.DiffNormalizeDuration
+	default:
+		return nil, fmt.Errorf("unknown diff normalization %q, must be total or duration", n)
+	}
+
+	switch c := vars["cluster"].value; c {
+	case "", "package", "file", "object":
+	default:
+		return nil, fmt.Errorf("unknown cluster %q, must be package, file or object", c)
+	}
+
 	var filters []string
-	for _, k := range []string{"focus", "ignore", "hide", "show", "show_from", "tagfocus", "tagignore", "tagshow", "taghide"} {
+	for _, k := range []string{"focus", "ignore", "hide", "show", "show_from", "tagfocus", "tagignore", "tagshow", "taghide", "time_range"} {
 		v := vars[k].value
 		if v != "" {
 			filters = append(filters, k+"="+v)
@@ -258,9 +345,10 @@ func reportOptions(p *profile.Profile, numLabelUnits map[string]string, vars var
 	}
 
 	ropt := &report.Options{
-		CumSort:      vars["cum"].boolValue(),
-		CallTree:     vars["call_tree"].boolValue(),
-		DropNegative: vars["drop_negative"].boolValue(),
+		CumSort:       vars["cum"].boolValue(),
+		CallTree:      vars["call_tree"].boolValue(),
+		FoldRecursion: vars["fold_recursion"].boolValue(),
+		DropNegative:  vars["drop_negative"].boolValue(),
 
 		CompactLabels: vars["compact_labels"].boolValue(),
 		Ratio:         1 / vars["divide_by"].floatValue(),
@@ -281,6 +369,13 @@ func reportOptions(p *profile.Profile, numLabelUnits map[string]string, vars var
 
 		SourcePath: vars["source_path"].stringValue(),
 		TrimPath:   vars["trim_path"].stringValue(),
+
+		Cluster:   vars["cluster"].value,
+		RawFormat: vars["format"].stringValue(),
+		MaxSize:   vars["max_size"].intValue(),
+		LintFail:  vars["vet_fail"].boolValue(),
+
+		DiffNormalize: diffNormalize,
 	}
 
 	if len(p.Mapping) > 0 && p.Mapping[0].File != "" {
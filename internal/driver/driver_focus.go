@@ -16,9 +16,11 @@ package driver
 
 import (
 	"fmt"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/lemonlinger/pprof/internal/measurement"
 	"github.com/lemonlinger/pprof/internal/plugin"
@@ -37,6 +39,7 @@ func applyFocus(prof *profile.Profile, numLabelUnits map[string]string, v variab
 	tagfocus, err := compileTagFilter("tagfocus", v["tagfocus"].value, numLabelUnits, ui, err)
 	tagignore, err := compileTagFilter("tagignore", v["tagignore"].value, numLabelUnits, ui, err)
 	prunefrom, err := compileRegexOption("prune_from", v["prune_from"].value, err)
+	start, end, err := parseTimeRange(v["time_range"].value, prof, err)
 	if err != nil {
 		return err
 	}
@@ -54,6 +57,10 @@ func applyFocus(prof *profile.Profile, numLabelUnits map[string]string, v variab
 	warnNoMatches(tagfocus == nil || tfm, "TagFocus", ui)
 	warnNoMatches(tagignore == nil || tim, "TagIgnore", ui)
 
+	if v["time_range"].value != "" {
+		warnNoMatches(prof.FilterSamplesByTime(start, end), "TimeRange", ui)
+	}
+
 	tagshow, err := compileRegexOption("tagshow", v["tagshow"].value, err)
 	taghide, err := compileRegexOption("taghide", v["taghide"].value, err)
 	tns, tnh := prof.FilterTagsByName(tagshow, taghide)
@@ -217,3 +224,55 @@ func warnNoMatches(match bool, option string, ui plugin.UI) {
 		ui.PrintErr(option + " expression matched no samples")
 	}
 }
+
+// parseTimeRange parses the time window selected by the time_range
+// option, as "start,end" where either bound may be omitted. A bound is
+// a duration such as 10s, relative to the start time of the profile, an
+// RFC3339 time, or a plain number of nanoseconds since the Unix epoch.
+// It returns the window in nanoseconds since the Unix epoch.
+func parseTimeRange(value string, p *profile.Profile, err error) (start, end int64, _ error) {
+	if value == "" || err != nil {
+		return 0, 0, err
+	}
+	bounds := strings.Split(value, ",")
+	if len(bounds) != 2 {
+		return 0, 0, fmt.Errorf("parsing time_range %q: want start,end", value)
+	}
+	base := p.TimeNanos
+	if base == 0 {
+		// Use the earliest event of the profile instead.
+		for _, s := range p.Sample {
+			for _, t := range s.Timestamps() {
+				if base == 0 || t < base {
+					base = t
+				}
+			}
+		}
+	}
+	start, end = math.MinInt64, math.MaxInt64
+	for i, b := range bounds {
+		b = strings.TrimSpace(b)
+		if b == "" {
+			continue
+		}
+		var t int64
+		if n, err := strconv.ParseInt(b, 10, 64); err == nil {
+			t = n
+		} else if d, err := time.ParseDuration(b); err == nil {
+			t = base + int64(d)
+		} else if tm, err := time.Parse(time.RFC3339Nano, b); err == nil {
+			t = tm.UnixNano()
+		} else {
+			return 0, 0, fmt.Errorf("parsing time_range bound %q: want a duration, an RFC3339 time or nanoseconds since the epoch", b)
+		}
+		if i == 0 {
+			start = t
+		} else {
+			end = t
+		}
+	}
+	if start >= end {
+		return 0, 0, fmt.Errorf("parsing time_range %q: empty window", value)
+	}
+	return start, end, nil
+}
@@ -19,12 +19,14 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net"
 	_ "net/http/pprof"
 	"os"
 	"reflect"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
@@ -32,6 +34,7 @@ import (
 
 	"github.com/lemonlinger/pprof/internal/plugin"
 	"github.com/lemonlinger/pprof/internal/proftest"
+	"github.com/lemonlinger/pprof/internal/report"
 	"github.com/lemonlinger/pprof/internal/symbolz"
 	"github.com/lemonlinger/pprof/profile"
 )
@@ -79,6 +82,10 @@ func TestParse(t *testing.T) {
 		{"dot,unit=minimum", "heap_sizetags"},
 		{"dot,addresses,flat,ignore=[X3]002,focus=[X1]000", "contention"},
 		{"dot,files,cum", "contention"},
+		{"graphml,addresses,flat", "cpu"},
+		{"gexf,functions,flat", "cpu"},
+		{"mermaid,functions,flat", "cpu"},
+		{"dot,functions,flat,cluster=file", "cpu"},
 		{"comments,add_comment=some-comment", "cpu"},
 		{"comments", "heap"},
 		{"tags", "cpu"},
@@ -218,6 +225,78 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestDiffBaseReports(t *testing.T) {
+	testcase := []struct {
+		flags          string
+		sources, bases []string
+		solution       string
+	}{
+		{"traces", []string{"cpu"}, []string{"cpu"}, "pprof.cpu.diff_base.traces"},
+		{"raw", []string{"cpu"}, []string{"cpu"}, "pprof.cpu.diff_base.raw"},
+		{"traces", []string{"cpu", "cpu"}, []string{"cpu", "cpu"}, "pprof.cpu.diff_sources.traces"},
+		{"tags", []string{"cpu", "cpu"}, []string{"cpu", "cpu"}, "pprof.cpu.diff_sources.tags"},
+	}
+
+	baseVars := pprofVariables
+	defer func() { pprofVariables = baseVars }()
+	for _, tc := range testcase {
+		t.Run(tc.solution, func(t *testing.T) {
+			pprofVariables = baseVars.makeCopy()
+
+			outputTempFile, err := ioutil.TempFile("", "profile_output")
+			if err != nil {
+				t.Fatalf("cannot create tempfile: %v", err)
+			}
+			defer os.Remove(outputTempFile.Name())
+			defer outputTempFile.Close()
+
+			f := baseFlags()
+			f.args = tc.sources
+			f.stringLists = map[string][]string{"diff_base": tc.bases}
+			f.strings["output"] = outputTempFile.Name()
+			delete(f.bools, "proto")
+			addFlags(&f, strings.Split(tc.flags, ","))
+
+			o := setDefaults(&plugin.Options{Flagset: f})
+			o.Fetch = testFetcher{}
+			o.Sym = testSymbolizer{}
+			o.UI = &proftest.TestUI{T: t, AllowRx: "Generating report in"}
+			if err := PProf(o); err != nil {
+				t.Fatalf("%s: %v", tc.flags, err)
+			}
+			b, err := ioutil.ReadFile(outputTempFile.Name())
+			if err != nil {
+				t.Fatalf("Failed to read profile %s: %v", outputTempFile.Name(), err)
+			}
+			// The labels identifying the profiles compared by the diff
+			// report are internal to it.
+			for _, label := range []string{report.DiffSourceLabel, report.DiffDurationLabel} {
+				if bytes.Contains(b, []byte(label)) {
+					t.Errorf("%s report shows %s:\n%s", tc.flags, label, b)
+				}
+			}
+
+			solution := "testdata/" + tc.solution
+			sbuf, err := ioutil.ReadFile(solution)
+			if err != nil {
+				t.Fatalf("reading solution file %s: %v", solution, err)
+			}
+			if string(b) != string(sbuf) {
+				d, err := proftest.Diff(sbuf, b)
+				if err != nil {
+					t.Fatalf("diff %s %v", solution, err)
+				}
+				t.Errorf("%s\n%s\n", solution, d)
+				if *updateFlag {
+					if err := ioutil.WriteFile(solution, b, 0644); err != nil {
+						t.Errorf("failed to update the solution file %q: %v", solution, err)
+					}
+				}
+			}
+		})
+	}
+}
+
 // removeScripts removes <script > .. </script> pairs from its input
 func removeScripts(in []byte) []byte {
 	beginMarker := []byte("<script")
@@ -262,7 +341,8 @@ func solutionFilename(source string, f *testFlags) string {
 	name = addString(name, f, []string{"relative_percentages"})
 	name = addString(name, f, []string{"seconds"})
 	name = addString(name, f, []string{"call_tree"})
-	name = addString(name, f, []string{"text", "tree", "callgrind", "dot", "svg", "tags", "dot", "traces", "disasm", "peek", "weblist", "topproto", "comments"})
+	name = addString(name, f, []string{"cluster"})
+	name = addString(name, f, []string{"text", "tree", "callgrind", "dot", "svg", "tags", "dot", "traces", "disasm", "peek", "weblist", "topproto", "comments", "graphml", "gexf", "mermaid"})
 	if f.strings["focus"] != "" || f.strings["tagfocus"] != "" {
 		name = append(name, "focus")
 	}
@@ -1494,6 +1574,175 @@ func (testSymbolzMergeFetcher) Fetch(s string, d, t time.Duration) (*profile.Pro
 	return p, s, nil
 }
 
+func TestParseTimeRange(t *testing.T) {
+	p := &profile.Profile{TimeNanos: 1e9}
+	for _, tc := range []struct {
+		value      string
+		start, end int64
+		wantErr    bool
+	}{
+		{value: "10s,20s", start: 11e9, end: 21e9},
+		{value: "500ms,", start: 15e8, end: math.MaxInt64},
+		{value: ",1500000000", start: math.MinInt64, end: 15e8},
+		{value: "1970-01-01T00:00:02Z,1m", start: 2e9, end: 61e9},
+		{value: "20s,10s", wantErr: true},
+		{value: "10s", wantErr: true},
+		{value: "soon,", wantErr: true},
+	} {
+		start, end, err := parseTimeRange(tc.value, p, nil)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseTimeRange(%q): got error %v, want error %v", tc.value, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && (start != tc.start || end != tc.end) {
+			t.Errorf("parseTimeRange(%q) = %d, %d, want %d, %d", tc.value, start, end, tc.start, tc.end)
+		}
+	}
+}
+
+// stackProfile returns a profile with a sample of value 1 for each of
+// stacks, which list their frames leaf first.
+func stackProfile(t *testing.T, stacks ...[]profile.Frame) *profile.Profile {
+	t.Helper()
+	b := profile.NewBuilder([]*profile.ValueType{{Type: "samples", Unit: "count"}})
+	for _, stack := range stacks {
+		if err := b.Add(profile.Frames(stack...), []int64{1}, nil); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	return b.Profile()
+}
+
+// fileFrames returns the frames of the functions with the given names,
+// all in file.
+func fileFrames(file string, names ...string) []profile.Frame {
+	var frames []profile.Frame
+	for _, f := range names {
+		frames = append(frames, profile.Frame{Function: f, File: file, Line: 10})
+	}
+	return frames
+}
+
+func TestAggregatePackages(t *testing.T) {
+	prof := stackProfile(t,
+		fileFrames("file.go", "github.com/user/repo/store.(*DB).Get", "github.com/user/repo/server.(*Server).handle.func1", "main.main"),
+		fileFrames("file.go", "github.com/user/repo/store.(*DB).Put", "github.com/user/repo/server.(*Server).handle", "main.main"),
+		fileFrames("file.go", "runtime.mallocgc", "encoding/json.Marshal", "main.main"),
+	)
+
+	for _, tc := range []struct {
+		granularity string
+		want        []string
+	}{
+		{"packages", []string{"encoding/json", "github.com/user/repo/server", "github.com/user/repo/store", "main", "runtime"}},
+		{"modules", []string{"github.com/user/repo", "main", "std"}},
+	} {
+		p := prof.Copy()
+		vars := pprofVariables.makeCopy()
+		vars.set(tc.granularity, "t")
+		if err := aggregate(p, vars); err != nil {
+			t.Fatalf("aggregate with %s: %v", tc.granularity, err)
+		}
+		names := make(map[string]bool)
+		for _, f := range p.Function {
+			names[f.Name] = true
+			if f.Filename != "" {
+				t.Errorf("%s: function %s has file name %q", tc.granularity, f.Name, f.Filename)
+			}
+		}
+		var got []string
+		for n := range names {
+			got = append(got, n)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got functions %v, want %v", tc.granularity, got, tc.want)
+		}
+	}
+}
+
+func TestApplyOwners(t *testing.T) {
+	prof := stackProfile(t,
+		[]profile.Frame{{Function: "strings.Index", File: "/go/src/strings/strings.go"}, {Function: "github.com/user/repo/store.(*DB).Get", File: "/src/repo/store/db.go"}},
+		[]profile.Frame{{Function: "github.com/user/repo/server.handle", File: "/src/repo/server/handle.go"}},
+		[]profile.Frame{{Function: "runtime.mallocgc", File: "/go/src/runtime/malloc.go"}, {Function: "github.com/user/repo/server.handle", File: "/src/repo/server/handle.go"}},
+		[]profile.Frame{{Function: "main.main", File: "/src/repo/main.go"}},
+	)
+	// Owners already recorded in the profile are kept if no rule matches.
+	prof.Sample[3].Label = map[string][]string{report.OwnerLabel: {"@release"}}
+	f, err := ioutil.TempFile("", "owners")
+	if err != nil {
+		t.Fatalf("TempFile: %v", err)
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprint(f, `# Owners of the test profile.
+pkg:github.com/user/repo @storage
+/src/repo/server/ @frontend @oncall
+func:^runtime\. @runtime
+`)
+	f.Close()
+
+	for _, tc := range []struct {
+		policy string
+		want   [][]string
+	}{
+		{"leaf", [][]string{nil, {"@frontend", "@oncall"}, {"@runtime"}, {"@release"}}},
+		{"first", [][]string{{"@storage"}, {"@frontend", "@oncall"}, {"@runtime"}, {"@release"}}},
+	} {
+		p := prof.Copy()
+		vars := pprofVariables.makeCopy()
+		vars.set("owners", f.Name())
+		vars.set("owners_policy", tc.policy)
+		if err := applyOwners(p, vars); err != nil {
+			t.Fatalf("applyOwners with %s: %v", tc.policy, err)
+		}
+		var got [][]string
+		for _, s := range p.Sample {
+			got = append(got, s.Label[report.OwnerLabel])
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got owners %v, want %v", tc.policy, got, tc.want)
+		}
+	}
+}
+
+func TestParseOwnersErrors(t *testing.T) {
+	for _, data := range []string{
+		"pkg:github.com/user/repo",
+		"pkg: @storage",
+		"func: @runtime",
+		"file: @frontend",
+		"func:( @runtime",
+	} {
+		if _, err := parseOwners([]byte(data)); err == nil {
+			t.Errorf("parseOwners(%q): got no error", data)
+		}
+	}
+}
+
+func TestPathEnds(t *testing.T) {
+	for _, tc := range []struct {
+		args     []string
+		from, to string
+		wantErr  bool
+	}{
+		{args: []string{"handle", "syscall.Write"}, from: "handle", to: "syscall.Write"},
+		{args: []string{"handle  syscall.Write"}, from: "handle", to: "syscall.Write"},
+		{args: []string{"handle"}, wantErr: true},
+		{args: []string{"a b c"}, wantErr: true},
+		{args: []string{"(", "b"}, wantErr: true},
+	} {
+		from, to, err := pathEnds(tc.args)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("pathEnds(%q): got error %v, want error %v", tc.args, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && (from.String() != tc.from || to.String() != tc.to) {
+			t.Errorf("pathEnds(%q) = %v, %v, want %s, %s", tc.args, from, to, tc.from, tc.to)
+		}
+	}
+}
+
 func TestSymbolzAfterMerge(t *testing.T) {
 	baseVars := pprofVariables
 	pprofVariables = baseVars.makeCopy()
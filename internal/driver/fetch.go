@@ -23,6 +23,7 @@ import (
 	"net/url"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"runtime"
 	"strconv"
@@ -32,6 +33,7 @@ import (
 
 	"github.com/lemonlinger/pprof/internal/measurement"
 	"github.com/lemonlinger/pprof/internal/plugin"
+	"github.com/lemonlinger/pprof/internal/report"
 	"github.com/lemonlinger/pprof/profile"
 )
 
@@ -41,17 +43,22 @@ import (
 // fetch any profiles.
 func fetchProfiles(s *source, o *plugin.Options) (*profile.Profile, error) {
 	sources := make([]profileSource, 0, len(s.Sources))
-	for _, src := range s.Sources {
+	for i, src := range s.Sources {
 		sources = append(sources, profileSource{
 			addr:   src,
+			index:  i,
 			source: s,
 		})
 	}
 
+	// Bases are numbered after the sources, so that labels built from
+	// the index tell them apart.
 	bases := make([]profileSource, 0, len(s.Base))
-	for _, src := range s.Base {
+	for i, src := range s.Base {
 		bases = append(bases, profileSource{
 			addr:   src,
+			index:  len(s.Sources) + i,
+			base:   true,
 			source: s,
 		})
 	}
@@ -72,7 +79,7 @@ func fetchProfiles(s *source, o *plugin.Options) (*profile.Profile, error) {
 			}
 		}
 		pbase.Scale(-1)
-		p, m, err = combineProfiles([]*profile.Profile{p, pbase}, []plugin.MappingSources{m, mbase})
+		p, m, err = combineProfiles([]*profile.Profile{p, pbase}, []plugin.MappingSources{m, mbase}, s.MergeMode)
 		if err != nil {
 			return nil, err
 		}
@@ -89,6 +96,12 @@ func fetchProfiles(s *source, o *plugin.Options) (*profile.Profile, error) {
 		p.Comments = append(p.Comments, s.Comment)
 	}
 
+	if s.Redact != nil {
+		if err := p.Redact(s.Redact); err != nil {
+			return nil, err
+		}
+	}
+
 	// Save a copy of the merged profile if there is at least one remote source.
 	if save {
 		dir, err := setTmpDir(o.UI)
@@ -173,6 +186,11 @@ func chunkedGrab(sources []profileSource, fetch plugin.Fetcher, obj plugin.ObjTo
 	var msrc plugin.MappingSources
 	var save bool
 	var count int
+	var mergeMode string
+	if len(sources) > 0 {
+		// concurrentGrab clears the sources it has fetched.
+		mergeMode = sources[0].source.MergeMode
+	}
 
 	for start := 0; start < len(sources); start += chunkSize {
 		end := start + chunkSize
@@ -188,7 +206,7 @@ func chunkedGrab(sources []profileSource, fetch plugin.Fetcher, obj plugin.ObjTo
 		case p == nil:
 			p, msrc, save, count = chunkP, chunkMsrc, chunkSave, chunkCount
 		default:
-			p, msrc, chunkErr = combineProfiles([]*profile.Profile{p, chunkP}, []plugin.MappingSources{msrc, chunkMsrc})
+			p, msrc, chunkErr = combineProfiles([]*profile.Profile{p, chunkP}, []plugin.MappingSources{msrc, chunkMsrc}, mergeMode)
 			if chunkErr != nil {
 				return nil, nil, false, 0, chunkErr
 			}
@@ -214,6 +232,10 @@ func concurrentGrab(sources []profileSource, fetch plugin.Fetcher, obj plugin.Ob
 	}
 	wg.Wait()
 
+	var mergeMode string
+	if len(sources) > 0 {
+		mergeMode = sources[0].source.MergeMode
+	}
 	var save bool
 	profiles := make([]*profile.Profile, 0, len(sources))
 	msrcs := make([]plugin.MappingSources, 0, len(sources))
@@ -224,6 +246,15 @@ func concurrentGrab(sources []profileSource, fetch plugin.Fetcher, obj plugin.Ob
 			continue
 		}
 		save = save || s.remote
+		if s.source.labelDiffSources() {
+			s.p.SetLabel(report.DiffSourceLabel, []string{report.DiffSourceValue(s.base, s.index)})
+		}
+		if s.source.DiffBase || s.source.labelDiffSources() {
+			setDiffDuration(s.p)
+		}
+		if key := s.source.LabelSources; key != "" {
+			s.p.SetLabel(key, []string{sourceLabel(s.source.LabelSourcesTemplate, s.addr, s.index)})
+		}
 		profiles = append(profiles, s.p)
 		msrcs = append(msrcs, s.msrc)
 		*s = profileSource{}
@@ -233,15 +264,33 @@ func concurrentGrab(sources []profileSource, fetch plugin.Fetcher, obj plugin.Ob
 		return nil, nil, false, 0, nil
 	}
 
-	p, msrc, err := combineProfiles(profiles, msrcs)
+	p, msrc, err := combineProfiles(profiles, msrcs, mergeMode)
 	if err != nil {
 		return nil, nil, false, 0, err
 	}
 	return p, msrc, save, len(profiles), nil
 }
 
-func combineProfiles(profiles []*profile.Profile, msrcs []plugin.MappingSources) (*profile.Profile, plugin.MappingSources, error) {
+// setDiffDuration records the duration of p in its samples, for the
+// diff report to compare the rates of profiles of different durations.
+func setDiffDuration(p *profile.Profile) {
+	for _, s := range p.Sample {
+		if s.NumLabel == nil {
+			s.NumLabel = make(map[string][]int64)
+		}
+		if s.NumUnit == nil {
+			s.NumUnit = make(map[string][]string)
+		}
+		s.NumLabel[report.DiffDurationLabel] = []int64{p.DurationNanos}
+		s.NumUnit[report.DiffDurationLabel] = []string{"nanoseconds"}
+	}
+}
+
+func combineProfiles(profiles []*profile.Profile, msrcs []plugin.MappingSources, mergeMode string) (*profile.Profile, plugin.MappingSources, error) {
 	// Merge profiles.
+	if err := alignSampleTypes(profiles, mergeMode); err != nil {
+		return nil, nil, err
+	}
 	if err := measurement.ScaleProfiles(profiles); err != nil {
 		return nil, nil, err
 	}
@@ -261,8 +310,104 @@ func combineProfiles(profiles []*profile.Profile, msrcs []plugin.MappingSources)
 	return p, msrc, nil
 }
 
+// sourceLabel returns the label value for the samples of the profile
+// fetched from addr, the index-th of the sources followed by the bases,
+// as given by template. The template can refer to the source as given with
+// {source}, to the host of a URL with {host}, to the base name of the
+// file or URL path with {file} and to the index with {index}.
+func sourceLabel(template, addr string, index int) string {
+	host, file := "", filepath.Base(addr)
+	if _, err := os.Stat(addr); err != nil {
+		if u, _ := adjustURL(addr, 0, 0); u != "" {
+			if pu, err := url.Parse(u); err == nil {
+				host, file = pu.Host, ""
+				if pu.Path != "" && pu.Path != "/" {
+					file = path.Base(pu.Path)
+				}
+			}
+		}
+	}
+	return strings.NewReplacer(
+		"{source}", addr,
+		"{host}", host,
+		"{file}", file,
+		"{index}", strconv.Itoa(index),
+	).Replace(template)
+}
+
+// alignSampleTypes makes the sample types of profiles identical so
+// that they can be merged, according to mergeMode. For the union and
+// intersection modes, the values of sample types with the same type and
+// compatible units are converted to a common unit, and the profiles
+// are changed to the union or intersection of their sample types, with
+// missing values set to zero. Period types that cannot be reconciled
+// are cleared. The strict mode, the default, leaves profiles unchanged.
+func alignSampleTypes(profiles []*profile.Profile, mergeMode string) error {
+	var sampleTypes func([]*profile.Profile) []*profile.ValueType
+	switch mergeMode {
+	case "", "strict":
+		return nil
+	case "union":
+		sampleTypes = profile.UnionSampleTypes
+	case "intersection":
+		sampleTypes = profile.IntersectSampleTypes
+	default:
+		return fmt.Errorf("unknown merge mode %q", mergeMode)
+	}
+
+	byType := make(map[string][]*profile.ValueType)
+	for _, p := range profiles {
+		for _, st := range p.SampleType {
+			byType[st.Type] = append(byType[st.Type], st)
+		}
+	}
+	for typ, sts := range byType {
+		common, err := measurement.CommonValueType(sts)
+		if err != nil || common == nil {
+			// Incompatible units are kept as separate sample types.
+			continue
+		}
+		for _, p := range profiles {
+			ratios := make([]float64, len(p.SampleType))
+			for i, st := range p.SampleType {
+				ratios[i] = 1
+				if st.Type == typ {
+					ratios[i], _ = measurement.Scale(1, st.Unit, common.Unit)
+					st.Unit = common.Unit
+				}
+			}
+			if err := p.ScaleN(ratios); err != nil {
+				return err
+			}
+		}
+	}
+
+	types := sampleTypes(profiles)
+	if len(types) == 0 {
+		return fmt.Errorf("profiles have no sample types in common")
+	}
+	for _, p := range profiles {
+		p.SetSampleTypes(types)
+	}
+
+	var periodTypes []*profile.ValueType
+	for _, p := range profiles {
+		if p.PeriodType != nil {
+			periodTypes = append(periodTypes, p.PeriodType)
+		}
+	}
+	if _, err := measurement.CommonValueType(periodTypes); err != nil {
+		for _, p := range profiles {
+			p.PeriodType, p.Period = &profile.ValueType{}, 0
+		}
+	}
+	return nil
+}
+
 type profileSource struct {
 	addr   string
+	index  int  // Position of addr in the sources, then the bases, of source.
+	base   bool // Whether addr is one of the bases of source.
 	source *source
 
 	p      *profile.Profile
@@ -320,12 +465,17 @@ func grabProfile(s *source, source string, fetcher plugin.Fetcher, obj plugin.Ob
 	}
 	if err != nil || p == nil {
 		// Fetch the profile over HTTP or from a file.
-		p, src, err = fetch(source, duration, timeout, ui, tr)
+		p, src, err = fetch(source, duration, timeout, s.Repair, ui, tr)
 		if err != nil {
 			return
 		}
 	}
 
+	if s.Repair {
+		for _, fix := range p.Repair() {
+			ui.PrintErr(source + ": repaired profile: " + fix)
+		}
+	}
 	if err = p.CheckValid(); err != nil {
 		return
 	}
@@ -459,8 +609,9 @@ mapping:
 
 // fetch fetches a profile from source, within the timeout specified,
 // producing messages through the ui. It returns the profile and the
-// url of the actual source of the profile for remote profiles.
-func fetch(source string, duration, timeout time.Duration, ui plugin.UI, tr http.RoundTripper) (p *profile.Profile, src string, err error) {
+// url of the actual source of the profile for remote profiles. If
+// unchecked is set, the profile is not checked for validity.
+func fetch(source string, duration, timeout time.Duration, unchecked bool, ui plugin.UI, tr http.RoundTripper) (p *profile.Profile, src string, err error) {
 	var f io.ReadCloser
 
 	if sourceURL, timeout := adjustURL(source, duration, timeout); sourceURL != "" {
@@ -477,11 +628,34 @@ func fetch(source string, duration, timeout time.Duration, ui plugin.UI, tr http
 	}
 	if err == nil {
 		defer f.Close()
-		p, err = profile.Parse(f)
+		p, err = parseProfile(f, unchecked)
 	}
 	return
 }
 
+// parseProfile parses a profile in any of the formats supported by
+// profile.Parse. Data that is not recognized by profile.Parse is
+// checked for OTLP ProfilesData, as exported by OpenTelemetry
+// collectors, in which case all its profiles are merged. If unchecked
+// is set, the profile is not checked for validity.
+func parseProfile(r io.Reader, unchecked bool) (*profile.Profile, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	parse := profile.ParseData
+	if unchecked {
+		parse = profile.ParseDataUnchecked
+	}
+	p, err := parse(data)
+	if err != nil {
+		if profs, otlpErr := profile.ParseOTLP(data); otlpErr == nil {
+			return profile.Merge(profs)
+		}
+	}
+	return p, err
+}
+
 // fetchURL fetches a profile from a URL using HTTP.
 func fetchURL(source string, timeout time.Duration, tr http.RoundTripper) (io.ReadCloser, error) {
 	client := &http.Client{
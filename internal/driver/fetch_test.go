@@ -180,6 +180,7 @@ func TestFetch(t *testing.T) {
 
 	for _, tc := range []testcase{
 		{path + "go.crc32.cpu", ""},
+		{path + "go.crc32.cpu.otlp", ""},
 		{path + "go.nomappings.crash", "/bin/gotest.exe"},
 		{"http://localhost/profile?file=cppbench.cpu", ""},
 	} {
@@ -523,6 +524,145 @@ func closedError() string {
 	return "use of closed"
 }
 
+func TestMergeMode(t *testing.T) {
+	newProfile := func(sampleTypes []*profile.ValueType, values []int64) *profile.Profile {
+		m := &profile.Mapping{ID: 1, File: "a.out"}
+		f := &profile.Function{ID: 1, Name: "main"}
+		l := &profile.Location{ID: 1, Mapping: m, Line: []profile.Line{{Function: f}}}
+		return &profile.Profile{
+			SampleType: sampleTypes,
+			PeriodType: &profile.ValueType{Type: sampleTypes[0].Type, Unit: sampleTypes[0].Unit},
+			Period:     1,
+			Sample:     []*profile.Sample{{Location: []*profile.Location{l}, Value: values}},
+			Mapping:    []*profile.Mapping{m},
+			Function:   []*profile.Function{f},
+			Location:   []*profile.Location{l},
+		}
+	}
+	newProfiles := func() []*profile.Profile {
+		return []*profile.Profile{
+			newProfile([]*profile.ValueType{
+				{Type: "samples", Unit: "count"},
+				{Type: "cpu", Unit: "milliseconds"},
+			}, []int64{1, 2}),
+			newProfile([]*profile.ValueType{
+				{Type: "cpu", Unit: "nanoseconds"},
+				{Type: "alloc", Unit: "bytes"},
+			}, []int64{3000000, 4}),
+		}
+	}
+
+	for _, tc := range []struct {
+		mode       string
+		wantTypes  []string
+		wantValues []int64
+		wantErr    bool
+	}{
+		{mode: "strict", wantErr: true},
+		{
+			mode:       "union",
+			wantTypes:  []string{"samples/count", "cpu/nanoseconds", "alloc/bytes"},
+			wantValues: []int64{1, 5000000, 4},
+		},
+		{
+			mode:       "intersection",
+			wantTypes:  []string{"cpu/nanoseconds"},
+			wantValues: []int64{5000000},
+		},
+		{mode: "bogus", wantErr: true},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			profiles := newProfiles()
+			p, _, err := combineProfiles(profiles, make([]plugin.MappingSources, len(profiles)), tc.mode)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("combineProfiles() got no error, want one")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("combineProfiles(): %v", err)
+			}
+			var types []string
+			for _, st := range p.SampleType {
+				types = append(types, st.Type+"/"+st.Unit)
+			}
+			if !reflect.DeepEqual(types, tc.wantTypes) {
+				t.Errorf("got sample types %v, want %v", types, tc.wantTypes)
+			}
+			if len(p.Sample) != 1 || !reflect.DeepEqual(p.Sample[0].Value, tc.wantValues) {
+				t.Errorf("got samples %v, want a single sample with values %v", p.Sample, tc.wantValues)
+			}
+		})
+	}
+}
+
+func TestSourceLabel(t *testing.T) {
+	file := filepath.Join("testdata", "cppbench.cpu")
+	for _, tc := range []struct {
+		template, addr string
+		index          int
+		want           string
+	}{
+		{"{source}", "http://host1:8080/debug/pprof/profile", 0, "http://host1:8080/debug/pprof/profile"},
+		{"{host}", "http://host1:8080/debug/pprof/profile", 0, "host1:8080"},
+		{"{host}", "host2:8080/debug/pprof/heap", 1, "host2:8080"},
+		{"{file}", "https://host3/debug/pprof/heap?gc=1", 0, "heap"},
+		{"{file}", file, 0, "cppbench.cpu"},
+		{"{host}", file, 0, ""},
+		{"run-{index}", file, 3, "run-3"},
+	} {
+		if got := sourceLabel(tc.template, tc.addr, tc.index); got != tc.want {
+			t.Errorf("sourceLabel(%q, %q, %d) = %q, want %q", tc.template, tc.addr, tc.index, got, tc.want)
+		}
+	}
+}
+
+func TestFetchLabelSources(t *testing.T) {
+	baseVars := pprofVariables
+	defer func() { pprofVariables = baseVars }()
+	pprofVariables = baseVars.makeCopy()
+
+	const path = "testdata/"
+	f := testFlags{
+		strings: map[string]string{
+			"label_sources": "run={index}",
+		},
+		stringLists: map[string][]string{
+			"diff_base": {path + "cppbench.contention"},
+		},
+		args: []string{path + "cppbench.contention", path + "cppbench.contention"},
+	}
+	o := setDefaults(&plugin.Options{
+		UI:            &proftest.TestUI{T: t, AllowRx: "Local symbolization failed|Some binary filenames not available"},
+		Flagset:       f,
+		HTTPTransport: transport.New(nil),
+	})
+	src, _, err := parseFlags(o)
+	if err != nil {
+		t.Fatalf("parseFlags: %v", err)
+	}
+	p, err := fetchProfiles(src, o)
+	if err != nil {
+		t.Fatalf("fetchProfiles: %v", err)
+	}
+
+	got := make(map[string]bool)
+	for i, s := range p.Sample {
+		runs := s.Label["run"]
+		if len(runs) != 1 {
+			t.Fatalf("sample %d has labels %v, want a single run", i, s.Label)
+		}
+		if want := s.DiffBaseSample(); (runs[0] == "2") != want {
+			t.Errorf("sample %d of run %s: got base %v, want %v", i, runs[0], !want, want)
+		}
+		got[runs[0]] = true
+	}
+	if want := map[string]bool{"0": true, "1": true, "2": true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got runs %v, want %v", got, want)
+	}
+}
+
 func TestHTTPSInsecure(t *testing.T) {
 	if runtime.GOOS == "nacl" || runtime.GOOS == "js" {
 		t.Skip("test assumes tcp available")
@@ -16,6 +16,7 @@ package driver
 
 import (
 	"encoding/json"
+	"fmt"
 	"html/template"
 	"net/http"
 	"strings"
@@ -31,9 +32,18 @@ type treeNode struct {
 	Cum       int64       `json:"v"`
 	CumFormat string      `json:"l"`
 	Percent   string      `json:"p"`
+	Recursion string      `json:"r,omitempty"`
 	Children  []*treeNode `json:"c"`
 }
 
+// recursionLabel describes the recursion folded into a node, if any.
+func recursionLabel(n *graph.Node) string {
+	if r := n.Recursion; r != nil {
+		return fmt.Sprintf("recursion depth %d max, %.1f avg", r.Max, r.Mean())
+	}
+	return ""
+}
+
 // flamegraph generates a web page containing a flamegraph.
 func (ui *webInterface) flamegraph(w http.ResponseWriter, req *http.Request) {
 	// Force the call tree so that the graph is a tree.
@@ -60,6 +70,7 @@ func (ui *webInterface) flamegraph(w http.ResponseWriter, req *http.Request) {
 			Cum:       v,
 			CumFormat: config.FormatValue(v),
 			Percent:   strings.TrimSpace(measurement.Percentage(v, config.Total)),
+			Recursion: recursionLabel(n),
 		}
 		nodes = append(nodes, node)
 		if len(n.In) == 0 {
@@ -276,6 +276,14 @@ func parseCommandLine(input []string) ([]string, variables, error) {
 		}
 		cmd = append(cmd, args[0])
 		args = args[1:]
+		if c.format == report.Paths {
+			// The paths command takes the regexps of both ends.
+			if len(args) == 0 {
+				return nil, nil, fmt.Errorf("command %s requires two arguments", name)
+			}
+			cmd = append(cmd, args[0])
+			args = args[1:]
+		}
 	}
 
 	// Copy the variables as options set in the command line are not persistent.
@@ -283,6 +283,19 @@ func TestInteractiveCommands(t *testing.T) {
 				"output":    "out",
 			},
 		},
+		{
+			"paths handle Write focus1 -ignore",
+			map[string]string{
+				"functions": "true",
+				"nodecount": "80",
+				"focus":     "focus1",
+				"ignore":    "ignore",
+			},
+		},
+		{
+			"paths handle",
+			nil, // Error
+		},
 		{
 			"999",
 			nil, // Error
@@ -0,0 +1,202 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package driver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/lemonlinger/pprof/internal/graph"
+	"github.com/lemonlinger/pprof/internal/report"
+	"github.com/lemonlinger/pprof/profile"
+)
+
+// ownerRule assigns owners to the frames of a stack. Exactly one of
+// function, file and pkg is set.
+type ownerRule struct {
+	function *regexp.Regexp // Matches function names.
+	file     *regexp.Regexp // Matches source file names.
+	pkg      string         // Package path, including subpackages.
+	owners   []string
+}
+
+// parseOwners parses an owners file. Each line has a pattern followed
+// by one or more owners, as in CODEOWNERS files, and later lines take
+// precedence over earlier ones. Patterns are function name regexps
+// prefixed by "func:", Go, C++ or Java packages prefixed by "pkg:", or
+// source file globs, optionally prefixed by "file:". A glob matches at
+// any directory unless it starts with a slash, and matches the files
+// below a directory it names, with "**" matching any number of
+// directories. Blank lines and lines starting with # are ignored.
+func parseOwners(data []byte) ([]*ownerRule, error) {
+	var rules []*ownerRule
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("owners line %d: want a pattern and at least one owner", n)
+		}
+		kind, pattern := "file", fields[0]
+		if i := strings.Index(pattern, ":"); i >= 0 {
+			switch pattern[:i] {
+			case "func", "pkg", "file":
+				kind, pattern = pattern[:i], pattern[i+1:]
+			}
+		}
+		if pattern == "" {
+			return nil, fmt.Errorf("owners line %d: empty %s pattern", n, kind)
+		}
+		r := &ownerRule{owners: fields[1:]}
+		var err error
+		switch kind {
+		case "func":
+			r.function, err = regexp.Compile(pattern)
+		case "pkg":
+			r.pkg = pattern
+		default:
+			r.file, err = regexp.Compile(globRegexp(pattern))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("owners line %d: %v", n, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, s.Err()
+}
+
+// globRegexp returns a regexp matching the file names matched by a
+// glob of an owners file.
+func globRegexp(glob string) string {
+	var re bytes.Buffer
+	if strings.HasPrefix(glob, "/") {
+		re.WriteString("^/?")
+	} else {
+		re.WriteString("(?:^|/)")
+	}
+	glob = strings.Trim(glob, "/")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**") {
+				re.WriteString(".*")
+				i++
+			} else {
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			re.WriteString("[^/]")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("(?:/|$)")
+	return re.String()
+}
+
+// ownersOf returns the owners of a frame by the last matching rule, or
+// nil if it has none.
+func ownersOf(rules []*ownerRule, fn *profile.Function) []string {
+	if fn == nil {
+		return nil
+	}
+	for i := len(rules) - 1; i >= 0; i-- {
+		r := rules[i]
+		switch {
+		case r.function != nil:
+			if r.function.MatchString(fn.Name) {
+				return r.owners
+			}
+		case r.file != nil:
+			if fn.Filename != "" && r.file.MatchString(fn.Filename) {
+				return r.owners
+			}
+		default:
+			if pkg := graph.PackageName(fn.Name); pkg == r.pkg || strings.HasPrefix(pkg, r.pkg+"/") || strings.HasPrefix(pkg, r.pkg+"::") || strings.HasPrefix(pkg, r.pkg+".") {
+				return r.owners
+			}
+		}
+	}
+	return nil
+}
+
+// applyOwners labels the samples of a profile with their owners, as
+// given by the owners file named by the owners option, under the
+// report.OwnerLabel key. The owners_policy option selects the frame
+// that decides the owners of a sample: "leaf" for the leaf frame, or
+// "first" for the first frame from the leaf that has owners. Samples
+// without owners keep any label they already had under that key.
+func applyOwners(p *profile.Profile, v variables) error {
+	file := v["owners"].value
+	if file == "" {
+		return nil
+	}
+	var firstOwned bool
+	switch policy := v["owners_policy"].value; policy {
+	case "", "leaf":
+	case "first":
+		firstOwned = true
+	default:
+		return fmt.Errorf("unknown owners_policy %q, must be leaf or first", policy)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("reading owners file: %v", err)
+	}
+	rules, err := parseOwners(data)
+	if err != nil {
+		return err
+	}
+
+	cache := make(map[*profile.Function][]string)
+	owners := func(fn *profile.Function) []string {
+		o, ok := cache[fn]
+		if !ok {
+			o = ownersOf(rules, fn)
+			cache[fn] = o
+		}
+		return o
+	}
+	for _, s := range p.Sample {
+		var o []string
+	stack:
+		for _, l := range s.Location {
+			lines := l.Line
+			if len(lines) == 0 {
+				lines = []profile.Line{{}}
+			}
+			for _, ln := range lines {
+				if o = owners(ln.Function); o != nil || !firstOwned {
+					break stack
+				}
+			}
+		}
+		if o == nil {
+			continue
+		}
+		if s.Label == nil {
+			s.Label = make(map[string][]string)
+		}
+		s.Label[report.OwnerLabel] = o
+	}
+	return nil
+}
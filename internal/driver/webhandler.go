@@ -54,6 +54,9 @@ type webHandler struct {
 
 	mtx         *sync.Mutex
 	profCache   map[string]*profile.Profile
+	callTrees   callTreeCache
+	profTypes   []string
+	profSources map[string]func() (*profile.Profile, error)
 	inProfiling bool
 }
 
@@ -75,13 +78,14 @@ func NewWebHandler(prefix, path string) *webHandler {
 	addTemplates(templates)
 	report.AddSourceTemplates(templates)
 	h := &webHandler{
-		prefix:    prefix,
-		path:      path,
-		templates: templates,
-		options:   opts,
-		mux:       http.NewServeMux(),
-		mtx:       new(sync.Mutex),
-		profCache: map[string]*profile.Profile{},
+		prefix:      prefix,
+		path:        path,
+		templates:   templates,
+		options:     opts,
+		mux:         http.NewServeMux(),
+		mtx:         new(sync.Mutex),
+		profCache:   map[string]*profile.Profile{},
+		profSources: map[string]func() (*profile.Profile, error){},
 	}
 
 	handlers := map[string]http.Handler{
@@ -90,7 +94,13 @@ func NewWebHandler(prefix, path string) *webHandler {
 		"/disasm":     http.HandlerFunc(h.disasm),
 		"/source":     http.HandlerFunc(h.source),
 		"/peek":       http.HandlerFunc(h.peek),
+		"/butterfly":  http.HandlerFunc(h.butterfly),
+		"/topdown":    http.HandlerFunc(h.topdown),
+		"/bottomup":   http.HandlerFunc(h.bottomup),
+		"/calltree":   http.HandlerFunc(h.callTreeJSON),
+		"/dominators": http.HandlerFunc(h.dominators),
 		"/flamegraph": http.HandlerFunc(h.flamegraph),
+		"/timeline":   http.HandlerFunc(h.timeline),
 		"/genprof":    http.HandlerFunc(h.genprof),
 		"/clearprof":  http.HandlerFunc(h.clearprof),
 	}
@@ -108,6 +118,18 @@ func NewWebHandler(prefix, path string) *webHandler {
 	return h
 }
 
+// AddProfileType adds a profile type that can be selected in the web
+// interface besides cpu and heap. Profiles of this type are created by
+// calling get.
+func (h *webHandler) AddProfileType(name string, get func() (*profile.Profile, error)) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if _, ok := h.profSources[name]; !ok {
+		h.profTypes = append(h.profTypes, name)
+	}
+	h.profSources[name] = get
+}
+
 func (h *webHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	h.mux.ServeHTTP(w, req)
 }
@@ -129,8 +151,13 @@ func (h *webHandler) dot(w http.ResponseWriter, req *http.Request) {
 	legend := config.Labels
 	legend = append(legend, "File: "+name)
 	config.Labels = nil
+	config.Collapsed = collapsedClusters(req.URL)
+	attrs, err := pathHighlights(g, req.URL)
+	if err != nil {
+		errList = append(errList, err.Error())
+	}
 	dot := &bytes.Buffer{}
-	graph.ComposeDot(dot, g, &graph.DotAttributes{}, config)
+	graph.ComposeDot(dot, g, attrs, config)
 
 	// Get all node names into an array.
 	nodes := []string{""} // dot starts with node numbered 1
@@ -258,6 +285,117 @@ func (h *webHandler) peek(w http.ResponseWriter, req *http.Request) {
 	})
 }
 
+// topdown generates a web page with the call tree of the profile.
+func (h *webHandler) topdown(w http.ResponseWriter, req *http.Request) {
+	h.callTree(w, req, false)
+}
+
+// bottomup generates a web page with the inverted call tree of the
+// profile.
+func (h *webHandler) bottomup(w http.ResponseWriter, req *http.Request) {
+	h.callTree(w, req, true)
+}
+
+// callTree generates a web page with the roots of a call tree, whose
+// other nodes are loaded from callTreeJSON as they are expanded.
+func (h *webHandler) callTree(w http.ResponseWriter, req *http.Request, inverted bool) {
+	name, prof, err := h.tryGetProfile(getProfileNameFromQuery(req.URL))
+	if err != nil {
+		h.render(w, "calltree", &report.Report{}, []string{err.Error()}, nil, webArgs{})
+		return
+	}
+
+	rpt, errList := h.makeReport(prof, w, req, []string{"tree"})
+	if rpt == nil {
+		return // error already reported
+	}
+
+	tree := report.NewCallTree(rpt, inverted)
+	h.callTrees.add(callTreeKey(req.URL, inverted), prof, tree)
+	ct, err := tree.Children(nil, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := json.Marshal(ct)
+	if err != nil {
+		http.Error(w, "error serializing call tree", http.StatusInternalServerError)
+		return
+	}
+
+	legend := report.ProfileLabels(rpt)
+	legend = append(legend, "File: "+name)
+	h.render(w, "calltree", rpt, errList, legend, webArgs{
+		CallTree:    template.JS(b),
+		SampleTypes: sampleTypes(prof),
+	})
+}
+
+// callTreeJSON serves the children of a node of a call tree as JSON.
+// The tree is only built if it is not in the cache.
+func (h *webHandler) callTreeJSON(w http.ResponseWriter, req *http.Request) {
+	inverted, path, match, err := callTreeParams(req.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, prof, err := h.tryGetProfile(getProfileNameFromQuery(req.URL))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key := callTreeKey(req.URL, inverted)
+	tree := h.callTrees.get(key, prof)
+	if tree == nil {
+		rpt, _ := h.makeReport(prof, w, req, []string{"tree"})
+		if rpt == nil {
+			return // error already reported
+		}
+		tree = report.NewCallTree(rpt, inverted)
+		h.callTrees.add(key, prof, tree)
+	}
+
+	ct, err := tree.Children(path, match)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := json.Marshal(ct)
+	if err != nil {
+		http.Error(w, "error serializing call tree", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// dominators generates a web page with the dominators report.
+func (h *webHandler) dominators(w http.ResponseWriter, req *http.Request) {
+	name, prof, err := h.tryGetProfile(getProfileNameFromQuery(req.URL))
+	if err != nil {
+		h.render(w, "plaintext", &report.Report{}, []string{err.Error()}, nil, webArgs{})
+		return
+	}
+
+	rpt, errList := h.makeReport(prof, w, req, []string{"dominators"})
+	if rpt == nil {
+		return // error already reported
+	}
+
+	out := &bytes.Buffer{}
+	if err := report.Generate(out, rpt, h.options.Obj); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	legend := report.ProfileLabels(rpt)
+	legend = append(legend, "File: "+name)
+	h.render(w, "plaintext", rpt, errList, legend, webArgs{
+		TextBody:    out.String(),
+		SampleTypes: sampleTypes(prof),
+	})
+}
+
 // flamegraph generates a web page containing a flamegraph.
 func (h *webHandler) flamegraph(w http.ResponseWriter, req *http.Request) {
 	name, prof, err := h.tryGetProfile(getProfileNameFromQuery(req.URL))
@@ -290,6 +428,7 @@ func (h *webHandler) flamegraph(w http.ResponseWriter, req *http.Request) {
 			Cum:       v,
 			CumFormat: config.FormatValue(v),
 			Percent:   strings.TrimSpace(measurement.Percentage(v, config.Total)),
+			Recursion: recursionLabel(n),
 		}
 		nodes = append(nodes, node)
 		if len(n.In) == 0 {
@@ -333,6 +472,71 @@ func (h *webHandler) flamegraph(w http.ResponseWriter, req *http.Request) {
 	})
 }
 
+// butterfly generates a web page with the callers and callees of the
+// function named by the c parameter.
+func (h *webHandler) butterfly(w http.ResponseWriter, req *http.Request) {
+	name, prof, err := h.tryGetProfile(getProfileNameFromQuery(req.URL))
+	if err != nil {
+		h.render(w, "butterfly", &report.Report{}, []string{err.Error()}, nil, webArgs{})
+		return
+	}
+
+	rpt, errList := h.makeReport(prof, w, req, []string{"peek"})
+	if rpt == nil {
+		return // error already reported
+	}
+
+	bf, err := report.GetButterfly(rpt, req.URL.Query().Get("c"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := json.Marshal(bf)
+	if err != nil {
+		http.Error(w, "error serializing butterfly", http.StatusInternalServerError)
+		return
+	}
+
+	legend := report.ProfileLabels(rpt)
+	legend = append(legend, "File: "+name)
+	h.render(w, "butterfly", rpt, errList, legend, webArgs{
+		Butterfly:   template.JS(b),
+		SampleTypes: sampleTypes(prof),
+	})
+}
+
+// timeline generates a web page with the sample values over time.
+func (h *webHandler) timeline(w http.ResponseWriter, req *http.Request) {
+	name, prof, err := h.tryGetProfile(getProfileNameFromQuery(req.URL))
+	if err != nil {
+		h.render(w, "timeline", &report.Report{}, []string{err.Error()}, nil, webArgs{})
+		return
+	}
+
+	rpt, errList := h.makeReport(prof, w, req, []string{"timeline"})
+	if rpt == nil {
+		return // error already reported
+	}
+
+	tl, err := report.GetTimeline(rpt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := json.Marshal(tl)
+	if err != nil {
+		http.Error(w, "error serializing timeline", http.StatusInternalServerError)
+		return
+	}
+
+	legend := report.ProfileLabels(rpt)
+	legend = append(legend, "File: "+name)
+	h.render(w, "timeline", rpt, errList, legend, webArgs{
+		Timeline:    template.JS(b),
+		SampleTypes: sampleTypes(prof),
+	})
+}
+
 func (h *webHandler) genprof(w http.ResponseWriter, req *http.Request) {
 	profType := getProfileTypeFromQuery(req.URL)
 	period := getSamplePerioidFromQuery(req.URL)
@@ -352,6 +556,7 @@ func (h *webHandler) clearprof(w http.ResponseWriter, req *http.Request) {
 	} else {
 		h.profCache = map[string]*profile.Profile{}
 	}
+	h.callTrees.reset()
 	redirectWithQuery(path.Join(h.prefix, h.path)+"/")(w, req)
 }
 
@@ -384,6 +589,7 @@ func (h *webHandler) render(w http.ResponseWriter, tmpl string,
 	//data.SampleTypes = sampleTypes(h.prof)
 	data.Legend = legend
 	data.ProfileNames = h.profileNames()
+	data.ProfileTypes = h.profileTypes()
 	data.Path = filepath.Join(h.prefix, h.path)
 	html := &bytes.Buffer{}
 	if err := h.templates.ExecuteTemplate(html, tmpl, data); err != nil {
@@ -408,6 +614,12 @@ func (h *webHandler) profileNames() []string {
 	return names
 }
 
+func (h *webHandler) profileTypes() []string {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	return append([]string(nil), h.profTypes...)
+}
+
 func (h *webHandler) getProfile(name string) *profile.Profile {
 	h.mtx.Lock()
 	defer h.mtx.Unlock()
@@ -443,25 +655,36 @@ func (h *webHandler) createProfile(profType string, samplePeriod time.Duration)
 		h.inProfiling = false
 	}()
 
+	h.mtx.Lock()
+	get := h.profSources[profType]
+	h.mtx.Unlock()
+	if get != nil {
+		samplePeriod = 0
+	}
+
 	profName := profileName(profType, samplePeriod, time.Now())
 	buf := &bytes.Buffer{}
-	switch profType {
-	case ProfileTypeCPU:
+	var p *profile.Profile
+	var err error
+	switch {
+	case get != nil:
+		p, err = get()
+	case profType == ProfileTypeCPU:
 		if err := pprof.StartCPUProfile(buf); err != nil {
 			return "", nil, err
 		}
 		time.Sleep(samplePeriod)
 		pprof.StopCPUProfile()
-	case ProfileTypeHeap:
+		p, err = profile.Parse(buf)
+	case profType == ProfileTypeHeap:
 		runtime.GC()
 		if err := pprof.WriteHeapProfile(buf); err != nil {
 			return "", nil, err
 		}
+		p, err = profile.Parse(buf)
 	default:
 		return "", nil, errors.New("unknown profile type")
 	}
-
-	p, err := profile.Parse(buf)
 	if err != nil {
 		return "", nil, err
 	}
@@ -535,6 +758,9 @@ const (
   <select name="pt">
     <option value="cpu">cpu</option>
 	<option value="heap">heap</option>
+    {{range .ProfileTypes}}
+    <option value="{{.}}">{{.}}</option>
+    {{end}}
   </select>
   Sampling:
   <select name="sp">
@@ -246,8 +246,17 @@ form {
     <div class="submenu">
       <a title="{{.Help.top}}"  href="./top" id="topbtn">Top</a>
       <a title="{{.Help.graph}}" href="./" id="graphbtn">Graph</a>
+      <a title="{{.Help.hotpath}}" href="./" id="hotpath">Hot Path</a>
+      <a title="{{.Help.cluster}}" href="./" id="cluster-package">Package Clusters</a>
+      <a title="{{.Help.cluster}}" href="./" id="cluster-file">File Clusters</a>
+      <a title="{{.Help.cluster}}" href="./" id="cluster-object">Object Clusters</a>
       <a title="{{.Help.flamegraph}}" href="./flamegraph" id="flamegraph">Flame Graph</a>
+      <a title="{{.Help.topdown}}" href="./topdown" id="topdown">Top Down</a>
+      <a title="{{.Help.bottomup}}" href="./bottomup" id="bottomup">Bottom Up</a>
       <a title="{{.Help.peek}}" href="./peek" id="peek">Peek</a>
+      <a title="{{.Help.butterfly}}" href="./butterfly" id="butterfly">Butterfly</a>
+      <a title="{{.Help.dominators}}" href="./dominators" id="dominators">Dominators</a>
+      <a title="{{.Help.timeline}}" href="./timeline" id="timeline">Timeline</a>
       <a title="{{.Help.list}}" href="./source" id="list">Source</a>
       <a title="{{.Help.disasm}}" href="./disasm" id="disasm">Disassemble</a>
     </div>
@@ -279,6 +288,8 @@ form {
       <a title="{{.Help.hide}}" href="?" id="hide">Hide</a>
       <a title="{{.Help.show}}" href="?" id="show">Show</a>
       <a title="{{.Help.show_from}}" href="?" id="show-from">Show from</a>
+      <a title="{{.Help.paths_from}}" href="./" id="paths-from">Paths from</a>
+      <a title="{{.Help.paths_to}}" href="./" id="paths-to">Paths to</a>
       <hr>
       <a title="{{.Help.reset}}" href="?">Reset</a>
     </div>
@@ -690,6 +701,11 @@ function viewer(baseUrl, nodes) {
     }
     if (!elem) return;
 
+    if (elem.classList.contains('cluster')) {
+      toggleCluster(elem);
+      return;
+    }
+
     // Disable regexp mode.
     regexpActive = false;
 
@@ -703,6 +719,30 @@ function viewer(baseUrl, nodes) {
     updateButtons();
   }
 
+  // Collapse a cluster of the graph into a single node, or expand it
+  // back, by toggling its name in the cc parameter.
+  function toggleCluster(elem) {
+    const title = elem.querySelector('title');
+    const prefix = 'cluster:';
+    if (title == null || !title.textContent.startsWith(prefix)) return;
+    const name = title.textContent.slice(prefix.length);
+
+    setHrefParams(window.location, function (params) {
+      const collapsed = (params.get('cc') || '').split('\n').filter(c => c != '');
+      const i = collapsed.indexOf(name);
+      if (i < 0) {
+        collapsed.push(name);
+      } else {
+        collapsed.splice(i, 1);
+      }
+      if (collapsed.length > 0) {
+        params.set('cc', collapsed.join('\n'));
+      } else {
+        params.delete('cc');
+      }
+    });
+  }
+
   function unselect(n, elem) {
     if (elem == null) return;
     selected.delete(n);
@@ -780,6 +820,8 @@ function viewer(baseUrl, nodes) {
     if (id == 'hide') param = 'h';
     if (id == 'show') param = 's';
     if (id == 'show-from') param = 'sf';
+    if (id == 'paths-from') param = 'pf';
+    if (id == 'paths-to') param = 'pt';
 
     // We update on mouseenter so middle-click/right-click work properly.
     elem.addEventListener('mouseenter', updater);
@@ -794,8 +836,9 @@ function viewer(baseUrl, nodes) {
 
       setHrefParams(elem, function (params) {
         if (re != '') {
-          // For focus/show/show-from, forget old parameter. For others, add to re.
-          if (param != 'f' && param != 's' && param != 'sf' && params.has(param)) {
+          // For focus/show/show-from/paths, forget old parameter. For others, add to re.
+          if (param != 'f' && param != 's' && param != 'sf' &&
+              param != 'pf' && param != 'pt' && params.has(param)) {
             const old = params.get(param);
             if (old != '') {
               re += '|' + old;
@@ -856,7 +899,8 @@ function viewer(baseUrl, nodes) {
     const enable = (search.value != '' || selected.size != 0);
     if (buttonsEnabled == enable) return;
     buttonsEnabled = enable;
-    for (const id of ['focus', 'ignore', 'hide', 'show', 'show-from']) {
+    for (const id of ['focus', 'ignore', 'hide', 'show', 'show-from',
+                      'paths-from', 'paths-to']) {
       const link = document.getElementById(id);
       if (link != null) {
         link.classList.toggle('disabled', !enable);
@@ -871,16 +915,66 @@ function viewer(baseUrl, nodes) {
   initMenus();
   if (svg != null) {
     initPanAndZoom(svg, toggleSvgSelect);
+  } else {
+    // The graph is rendered after the viewer is set up, so clusters are
+    // toggled from clicks on the element holding it.
+    const graph = document.getElementById('graph');
+    if (graph != null) {
+      graph.addEventListener('click', function (e) {
+        const cluster = e.target.closest('g.cluster');
+        if (cluster != null) {
+          toggleCluster(cluster);
+        }
+      });
+    }
   }
   if (toptable != null) {
     toptable.addEventListener('mousedown', handleTopClick);
     toptable.addEventListener('touchstart', handleTopClick);
   }
 
-  const ids = ['topbtn', 'graphbtn', 'flamegraph', 'peek', 'list', 'disasm',
-               'focus', 'ignore', 'hide', 'show', 'show-from'];
+  const ids = ['topbtn', 'graphbtn', 'flamegraph', 'topdown', 'bottomup',
+               'peek', 'dominators', 'timeline', 'list', 'disasm', 'focus',
+               'ignore', 'hide', 'show', 'show-from', 'paths-from', 'paths-to'];
   ids.forEach(makeSearchLinkDynamic);
 
+  // The hot path replaces any highlighted call paths.
+  const hotpath = document.getElementById('hotpath');
+  if (hotpath != null) {
+    setHrefParams(hotpath, function (params) {
+      params.delete('pf');
+      params.delete('pt');
+      params.set('hp', '1');
+    });
+  }
+
+  // Clustering the graph another way forgets the collapsed clusters.
+  for (const cluster of ['package', 'file', 'object']) {
+    const link = document.getElementById('cluster-' + cluster);
+    if (link != null) {
+      setHrefParams(link, function (params) {
+        params.delete('cc');
+        params.set('cl', cluster);
+      });
+    }
+  }
+
+  // The butterfly view is centered on the first selected node.
+  const butterfly = document.getElementById('butterfly');
+  if (butterfly != null) {
+    const updater = function () {
+      setHrefParams(butterfly, function (params) {
+        const first = selected.keys().next();
+        if (!regexpActive && !first.done) {
+          params.set('c', nodes[first.value]);
+        }
+      });
+    };
+    // We update on mouseenter so middle-click/right-click work properly.
+    butterfly.addEventListener('mouseenter', updater);
+    butterfly.addEventListener('touchstart', updater);
+  }
+
   const sampleIDs = [{{range .SampleTypes}}'{{.}}', {{end}}];
   sampleIDs.forEach(setSampleIndexLink);
 
@@ -1121,8 +1215,9 @@ function viewer(baseUrl, nodes) {
       .tooltip(false)
       .details(document.getElementById('flamegraphdetails'));
 
-    // <full name> (percentage, value)
-    flameGraph.label((d) => d.data.f + ' (' + d.data.p + ', ' + d.data.l + ')');
+    // <full name> (percentage, value[, recursion depth])
+    flameGraph.label((d) => d.data.f + ' (' + d.data.p + ', ' + d.data.l +
+                            (d.data.r ? ', ' + d.data.r : '') + ')');
 
     (function(flameGraph) {
       var oldColorMapper = flameGraph.color();
@@ -1183,5 +1278,475 @@ function viewer(baseUrl, nodes) {
 </body>
 </html>
 {{end}}
+
+{{define "butterfly" -}}
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  {{template "css" .}}
+  <style type="text/css">
+    #butterfly-view {
+      display: flex;
+      align-items: flex-start;
+      overflow-y: auto;
+      padding: 1em;
+    }
+    #butterfly-view table {
+      flex: 1;
+      width: auto;
+    }
+    #butterfly-view table tr th:last-child,
+    #butterfly-view table tr td:last-child {
+      text-align: left;
+    }
+    #butterfly-view table tr th {
+      cursor: ns-resize;
+    }
+    #butterfly-view tbody tr {
+      cursor: pointer;
+    }
+    #butterfly-view tbody tr:hover {
+      background-color: #ebf5fb;
+    }
+    #center {
+      margin: 2em 1em 0;
+      padding: .5em 1em;
+      border: 1px solid #888;
+      text-align: center;
+      white-space: nowrap;
+    }
+    #center div:first-child {
+      font-weight: bold;
+    }
+  </style>
+</head>
+<body>
+  {{template "header" .}}
+  {{template "profiles" .}}
+  <div id="butterfly-view">
+    <table id="callers">
+      <thead>
+        <tr>
+          <th data-key="edge">Calls</th>
+          <th data-key="edge" title="Percentage of the cum value of the function">Calls%</th>
+          <th data-key="flat">Flat</th>
+          <th data-key="cum">Cum</th>
+          <th data-key="name">Caller</th>
+        </tr>
+      </thead>
+      <tbody></tbody>
+    </table>
+    <div id="center"></div>
+    <table id="callees">
+      <thead>
+        <tr>
+          <th data-key="edge">Calls</th>
+          <th data-key="edge" title="Percentage of the cum value of the function">Calls%</th>
+          <th data-key="flat">Flat</th>
+          <th data-key="cum">Cum</th>
+          <th data-key="name">Callee</th>
+        </tr>
+      </thead>
+      <tbody></tbody>
+    </table>
+  </div>
+  {{template "script" .}}
+  <script>viewer(new URL(window.location.href), null);</script>
+  <script>
+    const data = {{.Butterfly}};
+
+    function percent(v, total) {
+      return (total != 0 ? 100 * v / total : 0).toFixed(2) + '%';
+    }
+
+    // Clicking an entry recenters the butterfly on it.
+    function recenter(name) {
+      const url = new URL(window.location.href);
+      url.searchParams.set('c', name);
+      window.location.href = url.toString();
+    }
+
+    const center = document.getElementById('center');
+    for (const text of [
+      data.center.name,
+      'flat ' + data.center.flatFormat + ' (' + percent(data.center.flat, data.total) + ')',
+      'cum ' + data.center.cumFormat + ' (' + percent(data.center.cum, data.total) + ')',
+    ]) {
+      const div = document.createElement('div');
+      div.textContent = text;
+      center.appendChild(div);
+    }
+
+    // Fill a table with entries, sorted by the column of a header when it
+    // is clicked, and in reverse order when it is clicked again.
+    function makeTable(id, entries) {
+      const table = document.getElementById(id);
+      const tbody = table.querySelector('tbody');
+      let key = 'edge';
+      let descending = true;
+
+      function render() {
+        entries.sort(function (a, b) {
+          const av = key == 'name' ? a.name : Math.abs(a[key]);
+          const bv = key == 'name' ? b.name : Math.abs(b[key]);
+          if (av != bv) {
+            return (av < bv) == descending ? 1 : -1;
+          }
+          return a.name < b.name ? -1 : (a.name > b.name ? 1 : 0);
+        });
+        const fragment = document.createDocumentFragment();
+        for (const e of entries) {
+          const tr = document.createElement('tr');
+          for (const text of [e.edgeFormat, percent(e.edge, data.center.cum),
+                              e.flatFormat, e.cumFormat,
+                              e.name + (e.inline ? ' (inline)' : '')]) {
+            const td = document.createElement('td');
+            td.textContent = text;
+            tr.appendChild(td);
+          }
+          tr.addEventListener('click', function () {
+            recenter(e.name);
+          });
+          fragment.appendChild(tr);
+        }
+        tbody.textContent = '';
+        tbody.appendChild(fragment);
+      }
+
+      for (const th of table.querySelectorAll('th')) {
+        th.addEventListener('click', function () {
+          if (key == th.dataset.key) {
+            descending = !descending;
+          } else {
+            key = th.dataset.key;
+            descending = (key != 'name');
+          }
+          render();
+        });
+      }
+      render();
+    }
+    makeTable('callers', data.callers);
+    makeTable('callees', data.callees);
+  </script>
+</body>
+</html>
+{{end}}
+
+{{define "calltree" -}}
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  {{template "css" .}}
+  <style type="text/css">
+    #calltree {
+      overflow-y: auto;
+    }
+    #calltree table tr th:last-child,
+    #calltree table tr td:last-child {
+      text-align: left;
+      width: 100%;
+    }
+    #calltree .toggle {
+      display: inline-block;
+      width: 1.2em;
+      cursor: pointer;
+      color: #888;
+    }
+  </style>
+</head>
+<body>
+  {{template "header" .}}
+  {{template "profiles" .}}
+  <div id="calltree">
+    <table>
+      <thead>
+        <tr>
+          <th>Self</th>
+          <th>Self%</th>
+          <th>Total</th>
+          <th>Total%</th>
+          <th id="calltreename">Name</th>
+        </tr>
+      </thead>
+      <tbody id="calltreerows"></tbody>
+    </table>
+  </div>
+  {{template "script" .}}
+  <script>viewer(new URL(window.location.href), null);</script>
+  <script>
+    const data = {{.CallTree}};
+    const rows = document.getElementById('calltreerows');
+    const errors = document.getElementById('errors');
+    document.getElementById('calltreename').textContent =
+      data.inverted ? 'Name (callers below)' : 'Name (callees below)';
+
+    function percent(v) {
+      return (data.total != 0 ? 100 * v / data.total : 0).toFixed(2) + '%';
+    }
+
+    // Give the nodes loaded as the children of the node at path their
+    // own paths, so that their children can be requested later on. The
+    // nodes that come with their children, leading to search matches,
+    // are expanded.
+    function setPaths(nodes, path) {
+      for (let i = 0; i < nodes.length; i++) {
+        const n = nodes[i];
+        n.path = (path == '' ? '' : path + '.') + i;
+        n.expanded = (n.children != null);
+        if (n.children != null) {
+          setPaths(n.children, n.path);
+        }
+      }
+    }
+    let roots = data.nodes;
+    setPaths(roots, '');
+
+    // Load the children of the node at path, expanded to the functions
+    // matching re if it is not empty.
+    function load(path, re, done) {
+      const url = new URL('./calltree', window.location.href);
+      for (const p of new URLSearchParams(window.location.search)) {
+        url.searchParams.set(p[0], p[1]);
+      }
+      if (data.inverted) url.searchParams.set('bu', '1');
+      if (path != '') url.searchParams.set('n', path);
+      if (re != '') url.searchParams.set('q', re);
+      fetch(url.toString()).then(function (resp) {
+        if (!resp.ok) {
+          return resp.text().then(function (text) {
+            throw new Error(text);
+          });
+        }
+        return resp.json();
+      }).then(function (result) {
+        setPaths(result.nodes, path);
+        done(result.nodes);
+      }).catch(function (err) {
+        const div = document.createElement('div');
+        div.textContent = err.message;
+        errors.appendChild(div);
+      });
+    }
+
+    function toggle(n) {
+      if (n.expanded) {
+        n.expanded = false;
+        render();
+        return;
+      }
+      if (n.children != null) {
+        n.expanded = true;
+        render();
+        return;
+      }
+      load(n.path, '', function (children) {
+        n.children = children;
+        n.expanded = true;
+        render();
+      });
+    }
+
+    function render() {
+      const fragment = document.createDocumentFragment();
+      function addRows(nodes, depth) {
+        for (const n of nodes) {
+          const tr = document.createElement('tr');
+          tr.classList.toggle('hilite', n.match === true);
+          for (const text of [n.selfFormat, percent(n.self),
+                              n.totalFormat, percent(n.total)]) {
+            const td = document.createElement('td');
+            td.textContent = text;
+            tr.appendChild(td);
+          }
+          const td = document.createElement('td');
+          td.style.paddingLeft = (0.5 + 1.2 * depth) + 'em';
+          const toggler = document.createElement('span');
+          toggler.className = 'toggle';
+          if (n.childCount > 0) {
+            toggler.textContent = n.expanded ? '▾' : '▸';
+            tr.addEventListener('click', function () {
+              toggle(n);
+            });
+          }
+          td.appendChild(toggler);
+          td.appendChild(document.createTextNode(n.name + (n.inline ? ' (inline)' : '')));
+          tr.appendChild(td);
+          fragment.appendChild(tr);
+          if (n.expanded) {
+            addRows(n.children, depth + 1);
+          }
+        }
+      }
+      addRows(roots, 0);
+      rows.textContent = '';
+      rows.appendChild(fragment);
+    }
+    render();
+
+    // Searching reloads the tree expanded to the matching functions.
+    const search = document.getElementById('search');
+    let searchAlarm = null;
+    let searched = '';
+
+    function expandMatching() {
+      searchAlarm = null;
+      const re = search.value;
+      if (re == searched) return;
+      try {
+        new RegExp(re);
+      } catch (e) {
+        return;
+      }
+      searched = re;
+      load('', re, function (nodes) {
+        if (re != searched) return; // A later search is on its way.
+        roots = nodes;
+        render();
+      });
+    }
+
+    function handleSearch() {
+      // Delay expensive processing so a flurry of key strokes is handled once.
+      if (searchAlarm != null) {
+        clearTimeout(searchAlarm);
+      }
+      searchAlarm = setTimeout(expandMatching, 300);
+    }
+
+    search.addEventListener('input', handleSearch);
+  </script>
+</body>
+</html>
+{{end}}
+
+{{define "timeline" -}}
+<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  {{template "css" .}}
+  <style type="text/css">
+    #timeline-chart {
+      display: flex;
+      align-items: flex-end;
+      height: 300px;
+      width: 90%;
+      margin: 20px 5% 0;
+      border-bottom: 1px solid #888;
+      user-select: none;
+      cursor: crosshair;
+    }
+    #timeline-chart div {
+      flex: 1;
+      margin: 0 1px;
+      background-color: #7a9ccc;
+      min-height: 1px;
+    }
+    #timeline-chart div.selected {
+      background-color: #e68a00;
+    }
+    #timeline-details {
+      width: 90%;
+      margin: 10px 5%;
+      height: 1.2em;
+    }
+  </style>
+</head>
+<body>
+  {{template "header" .}}
+  {{template "profiles" .}}
+  <div id="bodycontainer">
+    <div id="timeline-chart"></div>
+    <div id="timeline-details"></div>
+  </div>
+  {{template "script" .}}
+  <script>viewer(new URL(window.location.href), null);</script>
+  <script>
+    const data = {{.Timeline}};
+    const chart = document.getElementById('timeline-chart');
+    const details = document.getElementById('timeline-details');
+    const bars = [];
+
+    // Offsets are shown in seconds relative to the start of the profile.
+    // Times are nanoseconds since the epoch, kept as strings since they
+    // do not fit in a double.
+    function offset(t) {
+      return (Number(BigInt(t) - BigInt(data.base)) / 1e9).toFixed(3) + 's';
+    }
+
+    let max = 0;
+    for (const b of data.buckets) {
+      max = Math.max(max, Math.abs(b.value));
+    }
+    data.buckets.forEach((b, i) => {
+      const bar = document.createElement('div');
+      bar.style.height = (max > 0 ? 100 * Math.abs(b.value) / max : 0) + '%';
+      bar.title = offset(b.start) + ' - ' + offset(b.end) + ': ' + b.label;
+      bar.dataset.index = i;
+      chart.appendChild(bar);
+      bars.push(bar);
+    });
+
+    // Brushing selects a range of buckets, and releasing the mouse shows
+    // the profile restricted to their time window.
+    let first = -1;
+    let last = -1;
+
+    function barIndex(e) {
+      const i = e.target.dataset.index;
+      return i === undefined ? -1 : Number(i);
+    }
+
+    function showSelection() {
+      const lo = Math.min(first, last);
+      const hi = Math.max(first, last);
+      let sum = 0;
+      bars.forEach((bar, i) => {
+        const selected = i >= lo && i <= hi;
+        bar.classList.toggle('selected', selected);
+        if (selected) {
+          sum += data.buckets[i].value;
+        }
+      });
+      const pct = data.total != 0 ? (100 * sum / data.total).toFixed(2) : '0.00';
+      details.textContent = offset(data.buckets[lo].start) + ' - ' +
+          offset(data.buckets[hi].end) + ': ' + pct + '% of the total';
+    }
+
+    chart.addEventListener('mousedown', (e) => {
+      first = last = barIndex(e);
+      if (first >= 0) {
+        showSelection();
+      }
+      e.preventDefault();
+    });
+    chart.addEventListener('mouseover', (e) => {
+      const i = barIndex(e);
+      if (first >= 0 && i >= 0) {
+        last = i;
+        showSelection();
+      }
+    });
+    document.addEventListener('mouseup', () => {
+      if (first < 0) {
+        return;
+      }
+      const lo = Math.min(first, last);
+      const hi = Math.max(first, last);
+      first = last = -1;
+      const url = new URL(window.location.href);
+      url.searchParams.set('tr', data.buckets[lo].start + ',' + data.buckets[hi].end);
+      window.location.href = url.toString();
+    });
+  </script>
+</body>
+</html>
+{{end}}
 `))
 }
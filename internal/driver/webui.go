@@ -16,6 +16,7 @@ package driver
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"net"
@@ -23,8 +24,10 @@ import (
 	gourl "net/url"
 	"os"
 	"os/exec"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/lemonlinger/pprof/internal/graph"
@@ -39,6 +42,7 @@ type webInterface struct {
 	options   *plugin.Options
 	help      map[string]string
 	templates *template.Template
+	callTrees callTreeCache
 }
 
 func makeWebInterface(p *profile.Profile, opt *plugin.Options) *webInterface {
@@ -80,7 +84,11 @@ type webArgs struct {
 	TextBody      string
 	Top           []report.TextItem
 	FlameGraph    template.JS
+	Timeline      template.JS
+	Butterfly     template.JS
+	CallTree      template.JS
 	ProfileNames  []string
+	ProfileTypes  []string
 	ActiveProfile string
 	Path          string
 }
@@ -98,9 +106,14 @@ func serveWebInterface(hostport string, p *profile.Profile, o *plugin.Options, d
 	for n, v := range pprofVariables {
 		ui.help[n] = v.help
 	}
+	ui.help["bottomup"] = "Show the call tree from the leaf functions up to their callers"
+	ui.help["butterfly"] = "Show the callers and callees of a function"
 	ui.help["details"] = "Show information about the profile and this view"
 	ui.help["graph"] = "Display profile as a directed graph"
+	ui.help["paths_from"] = "Highlight the call paths from the selection in the graph"
+	ui.help["paths_to"] = "Highlight the call paths to the selection in the graph"
 	ui.help["reset"] = "Show the entire profile"
+	ui.help["topdown"] = "Show the call tree from the roots down to their callees"
 
 	server := o.HTTPServer
 	if server == nil {
@@ -116,7 +129,13 @@ func serveWebInterface(hostport string, p *profile.Profile, o *plugin.Options, d
 			"/disasm":     http.HandlerFunc(ui.disasm),
 			"/source":     http.HandlerFunc(ui.source),
 			"/peek":       http.HandlerFunc(ui.peek),
+			"/butterfly":  http.HandlerFunc(ui.butterfly),
+			"/topdown":    http.HandlerFunc(ui.topdown),
+			"/bottomup":   http.HandlerFunc(ui.bottomup),
+			"/calltree":   http.HandlerFunc(ui.callTreeJSON),
+			"/dominators": http.HandlerFunc(ui.dominators),
 			"/flamegraph": http.HandlerFunc(ui.flamegraph),
+			"/timeline":   http.HandlerFunc(ui.timeline),
 		},
 	}
 
@@ -218,6 +237,7 @@ func openBrowser(url string, o *plugin.Options) {
 		{"i", "ignore"},
 		{"h", "hide"},
 		{"si", "sample_index"},
+		{"tr", "time_range"},
 	} {
 		if v := pprofVariables[p.key].value; v != "" {
 			q.Set(p.param, v)
@@ -251,6 +271,8 @@ func varsFromURL(u *gourl.URL) variables {
 	vars["ignore"].value = u.Query().Get("i")
 	vars["hide"].value = u.Query().Get("h")
 	vars["sample_index"].value = u.Query().Get("si")
+	vars["time_range"].value = u.Query().Get("tr")
+	vars["cluster"].value = u.Query().Get("cl")
 	return vars
 }
 
@@ -305,8 +327,13 @@ func (ui *webInterface) dot(w http.ResponseWriter, req *http.Request) {
 	g, config := report.GetDOT(rpt)
 	legend := config.Labels
 	config.Labels = nil
+	config.Collapsed = collapsedClusters(req.URL)
+	attrs, err := pathHighlights(g, req.URL)
+	if err != nil {
+		errList = append(errList, err.Error())
+	}
 	dot := &bytes.Buffer{}
-	graph.ComposeDot(dot, g, &graph.DotAttributes{}, config)
+	graph.ComposeDot(dot, g, attrs, config)
 
 	// Convert to svg.
 	svg, err := dotToSvg(dot.Bytes())
@@ -329,6 +356,155 @@ func (ui *webInterface) dot(w http.ResponseWriter, req *http.Request) {
 	})
 }
 
+// collapsedClusters returns the clusters of a graph view drawn as a
+// single node, listed one per line in the cc URL parameter.
+func collapsedClusters(u *gourl.URL) map[string]bool {
+	collapsed := make(map[string]bool)
+	for _, name := range strings.Split(u.Query().Get("cc"), "\n") {
+		if name != "" {
+			collapsed[name] = true
+		}
+	}
+	return collapsed
+}
+
+// callTreeParams returns the call tree node whose children are
+// requested by the URL parameters: whether the tree is bottom-up, given
+// by the bu parameter, the path to the node, given by the n parameter as
+// dot separated child indexes, and the regexp of the functions to
+// expand the tree to, given by the q parameter.
+func callTreeParams(u *gourl.URL) (inverted bool, path []int, match *regexp.Regexp, err error) {
+	q := u.Query()
+	inverted = q.Get("bu") == "1"
+	if n := q.Get("n"); n != "" {
+		for _, s := range strings.Split(n, ".") {
+			i, err := strconv.Atoi(s)
+			if err != nil {
+				return false, nil, nil, fmt.Errorf("invalid call tree node %q", n)
+			}
+			path = append(path, i)
+		}
+	}
+	if re := q.Get("q"); re != "" {
+		if match, err = regexp.Compile(re); err != nil {
+			return false, nil, nil, fmt.Errorf("invalid search regexp: %v", err)
+		}
+	}
+	return inverted, path, match, nil
+}
+
+// maxCallTrees is the number of call trees kept by a callTreeCache.
+const maxCallTrees = 8
+
+// callTreeCache keeps the call trees of the recently viewed call tree
+// pages, so that loading the children of their nodes does not build
+// them again. The zero value is an empty cache.
+type callTreeCache struct {
+	mu    sync.Mutex
+	trees []*cachedCallTree // Least recently used first.
+}
+
+// cachedCallTree is a call tree built from prof with the report
+// parameters identified by key.
+type cachedCallTree struct {
+	key  string
+	prof *profile.Profile
+	tree *report.CallTree
+}
+
+// callTreeKey returns the key of the call tree of a call tree page or
+// of its nodes, identifying the profile and the report parameters of
+// the URL u but not the node or search.
+func callTreeKey(u *gourl.URL, inverted bool) string {
+	q := u.Query()
+	for _, param := range []string{"bu", "n", "q"} {
+		q.Del(param)
+	}
+	return fmt.Sprintf("%t?%s", inverted, q.Encode())
+}
+
+// get returns the call tree of prof with the given key, or nil if it
+// is not in the cache.
+func (c *callTreeCache) get(key string, prof *profile.Profile) *report.CallTree {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, t := range c.trees {
+		if t.key == key && t.prof == prof {
+			c.trees = append(append(c.trees[:i:i], c.trees[i+1:]...), t)
+			return t.tree
+		}
+	}
+	return nil
+}
+
+// add adds the call tree of prof with the given key to the cache,
+// evicting the least recently used trees beyond maxCallTrees.
+func (c *callTreeCache) add(key string, prof *profile.Profile, tree *report.CallTree) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, t := range c.trees {
+		if t.key == key {
+			c.trees = append(c.trees[:i:i], c.trees[i+1:]...)
+			break
+		}
+	}
+	c.trees = append(c.trees, &cachedCallTree{key, prof, tree})
+	if n := len(c.trees) - maxCallTrees; n > 0 {
+		c.trees = append([]*cachedCallTree(nil), c.trees[n:]...)
+	}
+}
+
+// reset empties the cache.
+func (c *callTreeCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.trees = nil
+}
+
+// highlightColor is the color of the highlighted nodes and edges of
+// the graph view.
+const highlightColor = "#0000b2"
+
+// pathHighlights returns the DOT attributes of a graph view that
+// highlight the call paths between the nodes matching the pf and pt URL
+// parameters, either of which may be omitted, or the hot path of the
+// graph if the hp parameter is set.
+func pathHighlights(g *graph.Graph, u *gourl.URL) (*graph.DotAttributes, error) {
+	attrs := &graph.DotAttributes{
+		Nodes: make(map[*graph.Node]*graph.DotNodeAttributes),
+		Edges: make(map[*graph.Edge]*graph.DotEdgeAttributes),
+	}
+	q := u.Query()
+	var nodes graph.Nodes
+	var edges []*graph.Edge
+	switch from, to := q.Get("pf"), q.Get("pt"); {
+	case from != "" || to != "":
+		var match [2]func(*graph.Node) bool
+		for i, expr := range []string{from, to} {
+			if expr == "" {
+				continue
+			}
+			re, err := compileRegexOption("paths", expr, nil)
+			if err != nil {
+				return attrs, err
+			}
+			match[i] = func(n *graph.Node) bool { return re.MatchString(n.Info.Name) }
+		}
+		if nodes, edges = g.PathsBetween(match[0], match[1]); len(nodes) == 0 {
+			return attrs, fmt.Errorf("no call paths from %q to %q in the graph", from, to)
+		}
+	case q.Get("hp") != "":
+		nodes, edges = g.HotPath()
+	}
+	for _, n := range nodes {
+		attrs.Nodes[n] = &graph.DotNodeAttributes{Bold: true, Color: highlightColor}
+	}
+	for _, e := range edges {
+		attrs.Edges[e] = &graph.DotEdgeAttributes{Bold: true, Color: highlightColor}
+	}
+	return attrs, nil
+}
+
 func dotToSvg(dot []byte) ([]byte, error) {
 	cmd := exec.Command("dot", "-Tsvg")
 	out := &bytes.Buffer{}
@@ -430,6 +606,153 @@ func (ui *webInterface) peek(w http.ResponseWriter, req *http.Request) {
 	})
 }
 
+// butterfly generates a web page with the callers and callees of the
+// function named by the c parameter.
+func (ui *webInterface) butterfly(w http.ResponseWriter, req *http.Request) {
+	rpt, errList := ui.makeReport(w, req, []string{"peek"})
+	if rpt == nil {
+		return // error already reported
+	}
+
+	bf, err := report.GetButterfly(rpt, req.URL.Query().Get("c"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		ui.options.UI.PrintErr(err)
+		return
+	}
+	b, err := json.Marshal(bf)
+	if err != nil {
+		http.Error(w, "error serializing butterfly", http.StatusInternalServerError)
+		ui.options.UI.PrintErr(err)
+		return
+	}
+
+	legend := report.ProfileLabels(rpt)
+	ui.render(w, "butterfly", rpt, errList, legend, webArgs{
+		Butterfly: template.JS(b),
+	})
+}
+
+// topdown generates a web page with the call tree of the profile.
+func (ui *webInterface) topdown(w http.ResponseWriter, req *http.Request) {
+	ui.callTree(w, req, false)
+}
+
+// bottomup generates a web page with the inverted call tree of the
+// profile.
+func (ui *webInterface) bottomup(w http.ResponseWriter, req *http.Request) {
+	ui.callTree(w, req, true)
+}
+
+// callTree generates a web page with the roots of a call tree, whose
+// other nodes are loaded from callTreeJSON as they are expanded.
+func (ui *webInterface) callTree(w http.ResponseWriter, req *http.Request, inverted bool) {
+	rpt, errList := ui.makeReport(w, req, []string{"tree"})
+	if rpt == nil {
+		return // error already reported
+	}
+
+	tree := report.NewCallTree(rpt, inverted)
+	ui.callTrees.add(callTreeKey(req.URL, inverted), ui.prof, tree)
+	ct, err := tree.Children(nil, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		ui.options.UI.PrintErr(err)
+		return
+	}
+	b, err := json.Marshal(ct)
+	if err != nil {
+		http.Error(w, "error serializing call tree", http.StatusInternalServerError)
+		ui.options.UI.PrintErr(err)
+		return
+	}
+
+	legend := report.ProfileLabels(rpt)
+	ui.render(w, "calltree", rpt, errList, legend, webArgs{
+		CallTree: template.JS(b),
+	})
+}
+
+// callTreeJSON serves the children of a node of a call tree as JSON.
+// The tree is only built if it is not in the cache.
+func (ui *webInterface) callTreeJSON(w http.ResponseWriter, req *http.Request) {
+	inverted, path, match, err := callTreeParams(req.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key := callTreeKey(req.URL, inverted)
+	tree := ui.callTrees.get(key, ui.prof)
+	if tree == nil {
+		rpt, _ := ui.makeReport(w, req, []string{"tree"})
+		if rpt == nil {
+			return // error already reported
+		}
+		tree = report.NewCallTree(rpt, inverted)
+		ui.callTrees.add(key, ui.prof, tree)
+	}
+
+	ct, err := tree.Children(path, match)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := json.Marshal(ct)
+	if err != nil {
+		http.Error(w, "error serializing call tree", http.StatusInternalServerError)
+		ui.options.UI.PrintErr(err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// dominators generates a web page with the dominators report.
+func (ui *webInterface) dominators(w http.ResponseWriter, req *http.Request) {
+	rpt, errList := ui.makeReport(w, req, []string{"dominators"})
+	if rpt == nil {
+		return // error already reported
+	}
+
+	out := &bytes.Buffer{}
+	if err := report.Generate(out, rpt, ui.options.Obj); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		ui.options.UI.PrintErr(err)
+		return
+	}
+
+	legend := report.ProfileLabels(rpt)
+	ui.render(w, "plaintext", rpt, errList, legend, webArgs{
+		TextBody: out.String(),
+	})
+}
+
+// timeline generates a web page with the sample values over time.
+func (ui *webInterface) timeline(w http.ResponseWriter, req *http.Request) {
+	rpt, errList := ui.makeReport(w, req, []string{"timeline"})
+	if rpt == nil {
+		return // error already reported
+	}
+
+	tl, err := report.GetTimeline(rpt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		ui.options.UI.PrintErr(err)
+		return
+	}
+	b, err := json.Marshal(tl)
+	if err != nil {
+		http.Error(w, "error serializing timeline", http.StatusInternalServerError)
+		ui.options.UI.PrintErr(err)
+		return
+	}
+
+	legend := report.ProfileLabels(rpt)
+	ui.render(w, "timeline", rpt, errList, legend, webArgs{
+		Timeline: template.JS(b),
+	})
+}
+
 // getFromLegend returns the suffix of an entry in legend that starts
 // with param.  It returns def if no such entry is found.
 func getFromLegend(legend []string, param, def string) string {
@@ -29,6 +29,7 @@ import (
 
 	"github.com/lemonlinger/pprof/internal/plugin"
 	"github.com/lemonlinger/pprof/internal/proftest"
+	"github.com/lemonlinger/pprof/internal/report"
 	"github.com/lemonlinger/pprof/profile"
 )
 
@@ -74,6 +75,10 @@ func TestWebInterface(t *testing.T) {
 	}
 	testcases := []testCase{
 		{"/", []string{"F1", "F2", "F3", "testbin", "cpu"}, true},
+		{"/?hp=1", []string{`stroke="#0000b2"`}, true},
+		{"/?pf=F3&pt=F1", []string{"no call paths from &#34;F3&#34; to &#34;F1&#34;"}, true},
+		{"/?cl=object", []string{"cluster:testbin", "F1", "F2", "F3"}, true},
+		{"/?cl=object&cc=testbin", []string{"cluster:testbin", "3 nodes"}, true},
 		{"/top", []string{`"Name":"F2","InlineLabel":"","Flat":200,"Cum":300,"FlatFormat":"200ms","CumFormat":"300ms"}`}, false},
 		{"/source?f=" + url.QueryEscape("F[12]"),
 			[]string{"F1", "F2", "300ms +line1"}, false},
@@ -82,6 +87,18 @@ func TestWebInterface(t *testing.T) {
 		{"/disasm?f=" + url.QueryEscape("F[12]"),
 			[]string{"f1:asm", "f2:asm"}, false},
 		{"/flamegraph", []string{"File: testbin", "\"n\":\"root\"", "\"n\":\"F1\"", "var flamegraph = function", "function hierarchy"}, false},
+		{"/dominators", []string{`300ms.*F1 \(root\)`, `100ms.*F3 \(F2\)`}, false},
+		{"/butterfly", []string{`"center":{"name":"F1"`}, false},
+		{"/butterfly?c=F2", []string{`"center":{"name":"F2"`, `"callers":\[{"name":"F1"`, `"callees":\[{"name":"F3".*"edge":100`}, false},
+		{"/butterfly?c=F2&h=F3", []string{`"flat":300`, `"callees":\[\]`}, false},
+		{"/topdown", []string{"File: testbin", `"nodes":\[{"name":"F1"`, `"childCount":1`}, false},
+		{"/bottomup", []string{`"inverted":true`, `"nodes":\[{"name":"F2","self":200`}, false},
+		{"/calltree?n=0", []string{`^{"total":300,"inverted":false,"nodes":\[{"name":"F2"`}, false},
+		{"/calltree?bu=1&q=F1", []string{`"name":"F1","self":0,.*"match":true`}, false},
+		{"/calltree?n=0.1", []string{"no call tree node at \\[0 1\\]"}, false},
+		{"/calltree?q=(", []string{"invalid search regexp"}, false},
+		{"/timeline", []string{"File: testbin", `"total":300`, `"start":"1000000000"`, `"label":"100ms"`}, false},
+		{"/timeline?tr=2500000000,", []string{`"total":100`, `"start":"3000000000"`}, false},
 	}
 	for _, c := range testcases {
 		if c.needDot && !haveDot {
@@ -224,10 +241,12 @@ func makeFakeProfile() *profile.Profile {
 			{
 				Location: []*profile.Location{locs[2], locs[1], locs[0]},
 				Value:    []int64{100},
+				NumLabel: map[string][]int64{profile.TimestampLabel: {1e9}},
 			},
 			{
 				Location: []*profile.Location{locs[1], locs[0]},
 				Value:    []int64{200},
+				NumLabel: map[string][]int64{profile.TimestampLabel: {2e9, 3e9}},
 			},
 		},
 		Location: locs,
@@ -236,6 +255,52 @@ func makeFakeProfile() *profile.Profile {
 	}
 }
 
+func TestCallTreeCache(t *testing.T) {
+	key := func(u string, inverted bool) string {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			t.Fatalf("url.Parse(%q): %v", u, err)
+		}
+		return callTreeKey(parsed, inverted)
+	}
+	// The nodes of a page share its tree, whatever node or search they
+	// load.
+	if page, nodes := key("/bottomup?f=F1&si=cycles", true), key("/calltree?si=cycles&f=F1&bu=1&n=0.1&q=F2", true); page != nodes {
+		t.Errorf("page key %q differs from nodes key %q", page, nodes)
+	}
+	if key("/topdown?f=F1", false) == key("/topdown?f=F2", false) {
+		t.Error("keys of different reports are equal")
+	}
+
+	p1, p2 := makeFakeProfile(), makeFakeProfile()
+	var c callTreeCache
+	tree := new(report.CallTree)
+	c.add("a", p1, tree)
+	if got := c.get("a", p1); got != tree {
+		t.Errorf("get: got %v, want the added tree", got)
+	}
+	if got := c.get("a", p2); got != nil {
+		t.Errorf("get with another profile: got %v, want nil", got)
+	}
+	// Trees beyond maxCallTrees are evicted, least recently used first.
+	for i := 0; i < maxCallTrees; i++ {
+		if i == maxCallTrees-1 {
+			c.get("a", p1)
+		}
+		c.add(fmt.Sprint(i), p1, new(report.CallTree))
+	}
+	if c.get("a", p1) == nil {
+		t.Error("recently used tree was evicted")
+	}
+	if c.get("0", p1) != nil {
+		t.Error("least recently used tree was not evicted")
+	}
+	c.reset()
+	if c.get("a", p1) != nil {
+		t.Error("reset kept a tree")
+	}
+}
+
 func TestGetHostAndPort(t *testing.T) {
 	if runtime.GOOS == "nacl" || runtime.GOOS == "js" {
 		t.Skip("test assumes tcp available")
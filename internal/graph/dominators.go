@@ -0,0 +1,141 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+// Dominators is the dominator tree of a graph. A node dominates
+// another if every call path from a root of the program to the other
+// node goes through it.
+type Dominators struct {
+	// Idom maps each node to its immediate dominator, the closest of
+	// the nodes dominating it. Nodes only dominated by the roots of
+	// the program, such as the roots themselves, map to nil.
+	Idom map[*Node]*Node
+	// Dominated maps each node to the sum of the flat values of the
+	// nodes it dominates, including itself: the value that would go
+	// away if the node was never called.
+	Dominated map[*Node]int64
+}
+
+// Dominators computes the dominator tree of the graph. The roots of
+// the program are the nodes reached by samples that have no callers in
+// the graph, identified by a cum value larger than the total weight of
+// their incoming edges.
+func (g *Graph) Dominators() *Dominators {
+	// Number the nodes in reverse postorder of a depth-first traversal
+	// from a virtual root, numbered 0, with edges to the roots of the
+	// program. Heavier edges are visited first so that the numbering
+	// does not depend on map order.
+	index := make(map[*Node]int, len(g.Nodes))
+	var order Nodes // Nodes in postorder.
+	var visit func(n *Node)
+	visit = func(n *Node) {
+		index[n] = -1
+		for _, e := range n.Out.Sort() {
+			if _, ok := index[e.Dest]; !ok {
+				visit(e.Dest)
+			}
+		}
+		order = append(order, n)
+	}
+	var roots Nodes
+	for _, n := range g.Nodes {
+		if n.isRoot() {
+			roots = append(roots, n)
+			if _, ok := index[n]; !ok {
+				visit(n)
+			}
+		}
+	}
+	// Nodes only reachable through cycles, such as recursive entry
+	// points, are treated as roots too.
+	for _, n := range g.Nodes {
+		if _, ok := index[n]; !ok {
+			roots = append(roots, n)
+			visit(n)
+		}
+	}
+	nodes := make(Nodes, len(order)+1)
+	for i, n := range order {
+		nodes[len(order)-i] = n
+		index[n] = len(order) - i
+	}
+
+	preds := make([][]int, len(nodes))
+	for _, n := range roots {
+		preds[index[n]] = append(preds[index[n]], 0)
+	}
+	for _, n := range order {
+		for src := range n.In {
+			preds[index[n]] = append(preds[index[n]], index[src])
+		}
+	}
+
+	// Iterate to a fixed point, as described in "A Simple, Fast
+	// Dominance Algorithm" by Cooper, Harvey and Kennedy.
+	idom := make([]int, len(nodes))
+	for i := range idom {
+		idom[i] = -1
+	}
+	idom[0] = 0
+	intersect := func(a, b int) int {
+		for a != b {
+			for a > b {
+				a = idom[a]
+			}
+			for b > a {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := 1; i < len(nodes); i++ {
+			d := -1
+			for _, p := range preds[i] {
+				if idom[p] == -1 {
+					continue
+				}
+				if d == -1 {
+					d = p
+				} else {
+					d = intersect(p, d)
+				}
+			}
+			if d != idom[i] {
+				idom[i] = d
+				changed = true
+			}
+		}
+	}
+
+	doms := &Dominators{
+		Idom:      make(map[*Node]*Node, len(order)),
+		Dominated: make(map[*Node]int64, len(order)),
+	}
+	dominated := make([]int64, len(nodes))
+	// Children follow their dominators in reverse postorder, so adding
+	// in postorder accumulates each subtree before its dominator.
+	for i := len(nodes) - 1; i > 0; i-- {
+		n := nodes[i]
+		dominated[i] += n.FlatValue()
+		dominated[idom[i]] += dominated[i]
+		doms.Dominated[n] = dominated[i]
+		if idom[i] != 0 {
+			doms.Idom[n] = nodes[idom[i]]
+		}
+	}
+	return doms
+}
@@ -28,6 +28,7 @@ import (
 // insight into how its elements should be rendered.
 type DotAttributes struct {
 	Nodes map[*Node]*DotNodeAttributes // A map allowing each Node to have its own visualization option
+	Edges map[*Edge]*DotEdgeAttributes // A map allowing each Edge to have its own visualization option
 }
 
 // DotNodeAttributes contains Node specific visualization options.
@@ -37,6 +38,13 @@ type DotNodeAttributes struct {
 	Peripheries int                    // An optional number of borders to place around a node
 	URL         string                 // An optional url link to add to a node
 	Formatter   func(*NodeInfo) string // An optional formatter for the node's label
+	Color       string                 // An optional color overriding the one based on the node value
+}
+
+// DotEdgeAttributes contains Edge specific visualization options.
+type DotEdgeAttributes struct {
+	Bold  bool   // If the edge should be drawn thicker or not
+	Color string // An optional color overriding the one based on the edge weight
 }
 
 // DotConfig contains attributes about how a graph should be
@@ -45,6 +53,11 @@ type DotConfig struct {
 	Title     string   // The title of the DOT graph
 	LegendURL string   // The URL to link to from the legend.
 	Labels    []string // The labels for the DOT's legend
+	Unit      string   // The unit of the values, for formats exporting them as numbers
+
+	Cluster       func(*Node) string // An optional function naming the cluster of each node
+	ClusterTotals map[string]*Node   // Optional nodes holding the values of the clusters
+	Collapsed     map[string]bool    // The clusters drawn as a single node
 
 	FormatValue func(int64) string // A formatting function for values
 	Total       int64              // The total weight of the graph, used to compute percentages
@@ -52,6 +65,8 @@ type DotConfig struct {
 
 const maxNodelets = 4 // Number of nodelets for labels (both numeric and non)
 
+const minClusterNodes = 3 // Number of nodes of the smallest cluster drawn
+
 // ComposeDot creates and writes a in the DOT format to the writer, using
 // the configurations given.
 func ComposeDot(w io.Writer, g *Graph, a *DotAttributes, c *DotConfig) {
@@ -78,12 +93,22 @@ func ComposeDot(w io.Writer, g *Graph, a *DotAttributes, c *DotConfig) {
 		}
 	}
 
+	clusters := dotClusters(g.Nodes, c.Cluster)
+	inCluster := make(map[*Node]bool)
+	for _, cl := range clusters {
+		for _, n := range cl.nodes {
+			inCluster[n] = true
+		}
+	}
+
 	edges := EdgeMap{}
 
 	// Add nodes and nodelets to DOT builder.
 	for _, n := range g.Nodes {
-		builder.addNode(n, nodeIDMap[n], maxFlat)
-		hasNodelets[n] = builder.addNodelets(n, nodeIDMap[n])
+		if !inCluster[n] {
+			builder.addNode(n, nodeIDMap[n], maxFlat)
+			hasNodelets[n] = builder.addNodelets(n, nodeIDMap[n])
+		}
 
 		// Collect all edges. Use a fake node to support multiple incoming edges.
 		for _, e := range n.Out {
@@ -91,12 +116,141 @@ func ComposeDot(w io.Writer, g *Graph, a *DotAttributes, c *DotConfig) {
 		}
 	}
 
+	// Add clusters, with the nodes of collapsed clusters replaced by a
+	// node summarizing them.
+	summaries := make(map[*Node]*Node)
+	for _, cl := range clusters {
+		summary := cl.summary(c.ClusterTotals[cl.name])
+		builder.startCluster(cl.name, summary)
+		if c.Collapsed[cl.name] {
+			nodeIDMap[summary] = len(nodeIDMap) + 1
+			// Draw the summary node with the largest font if its flat
+			// value is larger than the one of every node.
+			builder.addNode(summary, nodeIDMap[summary], math.Max(maxFlat, float64(abs64(summary.FlatValue()))))
+			for _, n := range cl.nodes {
+				summaries[n] = summary
+			}
+		} else {
+			for _, n := range cl.nodes {
+				builder.addNode(n, nodeIDMap[n], maxFlat)
+				hasNodelets[n] = builder.addNodelets(n, nodeIDMap[n])
+			}
+		}
+		builder.finish()
+	}
+
 	// Add edges to DOT builder. Sort edges by frequency as a hint to the graph layout engine.
-	for _, e := range edges.Sort() {
+	sorted := edges.Sort()
+	if len(summaries) > 0 {
+		sorted = collapseEdges(sorted, summaries)
+	}
+	for _, e := range sorted {
 		builder.addEdge(e, nodeIDMap[e.Src], nodeIDMap[e.Dest], hasNodelets[e.Src])
 	}
 }
 
+// dotCluster is a group of nodes drawn together in a DOT cluster.
+type dotCluster struct {
+	name  string
+	nodes Nodes
+}
+
+// dotClusters groups nodes by the cluster named by cluster, in the order
+// of their first nodes. Nodes without a cluster name and clusters of
+// less than minClusterNodes nodes are left out.
+func dotClusters(nodes Nodes, cluster func(*Node) string) []*dotCluster {
+	if cluster == nil {
+		return nil
+	}
+	var all []*dotCluster
+	byName := make(map[string]*dotCluster)
+	for _, n := range nodes {
+		name := cluster(n)
+		if name == "" {
+			continue
+		}
+		cl := byName[name]
+		if cl == nil {
+			cl = &dotCluster{name: name}
+			byName[name] = cl
+			all = append(all, cl)
+		}
+		cl.nodes = append(cl.nodes, n)
+	}
+	var clusters []*dotCluster
+	for _, cl := range all {
+		if len(cl.nodes) >= minClusterNodes {
+			clusters = append(clusters, cl)
+		}
+	}
+	return clusters
+}
+
+// summary returns a node standing for the nodes of the cluster, with the
+// values of total if it is not nil. Otherwise its flat value is the sum
+// of their flat values, and its cum value the sum of their cum values
+// less the weight of the edges between them. This counts samples going
+// through several of the nodes once, unless edges between them were
+// trimmed or the samples recurse back into the cluster.
+func (cl *dotCluster) summary(total *Node) *Node {
+	s := &Node{Info: NodeInfo{Name: fmt.Sprintf("%d nodes", len(cl.nodes))}}
+	if total != nil {
+		s.Flat, s.FlatDiv, s.Cum, s.CumDiv = total.Flat, total.FlatDiv, total.Cum, total.CumDiv
+		return s
+	}
+	inCluster := make(map[*Node]bool, len(cl.nodes))
+	for _, n := range cl.nodes {
+		inCluster[n] = true
+	}
+	for _, n := range cl.nodes {
+		s.Flat += n.Flat
+		s.FlatDiv += n.FlatDiv
+		s.Cum += n.Cum
+		s.CumDiv += n.CumDiv
+		for dest, e := range n.Out {
+			if dest != n && inCluster[dest] {
+				s.Cum -= e.Weight
+				s.CumDiv -= e.WeightDiv
+			}
+		}
+	}
+	return s
+}
+
+// collapseEdges replaces the ends of edges by the nodes summarizing them,
+// merging the edges between the same nodes and dropping the edges within
+// a collapsed cluster. The edges are returned sorted by weight.
+func collapseEdges(edges []*Edge, summaries map[*Node]*Node) []*Edge {
+	replace := func(n *Node) *Node {
+		if s := summaries[n]; s != nil {
+			return s
+		}
+		return n
+	}
+	merged := make(map[[2]*Node]*Edge)
+	collapsed := EdgeMap{}
+	for _, e := range edges {
+		src, dest := replace(e.Src), replace(e.Dest)
+		switch {
+		case src == e.Src && dest == e.Dest:
+			collapsed[&Node{}] = e
+		case src == dest:
+			// Within a collapsed cluster.
+		case merged[[2]*Node{src, dest}] != nil:
+			m := merged[[2]*Node{src, dest}]
+			m.Weight += e.Weight
+			m.WeightDiv += e.WeightDiv
+			m.Residual = m.Residual && e.Residual
+			m.Inline = m.Inline && e.Inline
+		default:
+			m := &Edge{Src: src, Dest: dest, Weight: e.Weight, WeightDiv: e.WeightDiv, Residual: e.Residual, Inline: e.Inline}
+			merged[[2]*Node{src, dest}] = m
+			collapsed[&Node{}] = m
+		}
+	}
+	return collapsed.Sort()
+}
+
 // builder wraps an io.Writer and understands how to compose DOT formatted elements.
 type builder struct {
 	io.Writer
@@ -137,6 +291,19 @@ func (b *builder) addLegend() {
 	fmt.Fprintf(b, "] }\n")
 }
 
+// startCluster opens a DOT cluster, labeled with its name and the values
+// of the node summarizing it. finish closes it.
+func (b *builder) startCluster(name string, summary *Node) {
+	name = strings.Replace(name, `"`, `\"`, -1)
+	flat, cum := summary.FlatValue(), summary.CumValue()
+	label := fmt.Sprintf(`%s\n%s (%s)\nof %s (%s)`, name,
+		b.config.FormatValue(flat), strings.TrimSpace(measurement.Percentage(flat, b.config.Total)),
+		b.config.FormatValue(cum), strings.TrimSpace(measurement.Percentage(cum, b.config.Total)))
+	fmt.Fprintf(b, `subgraph "cluster:%s" {`+"\n", name)
+	fmt.Fprintf(b, `graph [label="%s" tooltip="%s" fontsize=16 style="rounded,dashed" color="%s"]`+"\n",
+		label, name, dotColor(float64(cum)/float64(abs64(b.config.Total)), false))
+}
+
 // addNode generates a graph node in DOT format.
 func (b *builder) addNode(node *Node, nodeID int, maxFlat float64) {
 	flat, cum := node.FlatValue(), node.CumValue()
@@ -170,6 +337,9 @@ func (b *builder) addNode(node *Node, nodeID int, maxFlat float64) {
 			cumValue,
 			strings.TrimSpace(measurement.Percentage(cum, b.config.Total)))
 	}
+	if r := node.Recursion; r != nil {
+		label = label + fmt.Sprintf(`\nrecursion depth %d max, %.1f avg`, r.Max, r.Mean())
+	}
 
 	// Scale font sizes from 8 to 24 based on percentage of flat frequency.
 	// Use non linear growth to emphasize the size difference.
@@ -185,10 +355,14 @@ func (b *builder) addNode(node *Node, nodeID int, maxFlat float64) {
 		shape = attrs.Shape
 	}
 
+	color := dotColor(float64(node.CumValue())/float64(abs64(b.config.Total)), false)
+	if attrs != nil && attrs.Color != "" {
+		color = attrs.Color
+	}
+
 	// Create DOT attribute for node.
 	attr := fmt.Sprintf(`label="%s" id="node%d" fontsize=%d shape=%s tooltip="%s (%s)" color="%s" fillcolor="%s"`,
-		label, nodeID, fontSize, shape, node.Info.PrintableName(), cumValue,
-		dotColor(float64(node.CumValue())/float64(abs64(b.config.Total)), false),
+		label, nodeID, fontSize, shape, node.Info.PrintableName(), cumValue, color,
 		dotColor(float64(node.CumValue())/float64(abs64(b.config.Total)), true))
 
 	// Add on extra attributes if provided.
@@ -289,16 +463,28 @@ func (b *builder) addEdge(edge *Edge, from, to int, hasNodelets bool) {
 	}
 	w := b.config.FormatValue(edge.WeightValue())
 	attr := fmt.Sprintf(`label=" %s%s"`, w, inline)
+	width, color := 1, ""
 	if b.config.Total != 0 {
 		// Note: edge.weight > b.config.Total is possible for profile diffs.
 		if weight := 1 + int(min64(abs64(edge.WeightValue()*100/b.config.Total), 100)); weight > 1 {
 			attr = fmt.Sprintf(`%s weight=%d`, attr, weight)
 		}
-		if width := 1 + int(min64(abs64(edge.WeightValue()*5/b.config.Total), 5)); width > 1 {
-			attr = fmt.Sprintf(`%s penwidth=%d`, attr, width)
+		width = 1 + int(min64(abs64(edge.WeightValue()*5/b.config.Total), 5))
+		color = dotColor(float64(edge.WeightValue())/float64(abs64(b.config.Total)), false)
+	}
+	if attrs := b.attributes.Edges[edge]; attrs != nil {
+		if attrs.Bold {
+			width += 2
+		}
+		if attrs.Color != "" {
+			color = attrs.Color
 		}
-		attr = fmt.Sprintf(`%s color="%s"`, attr,
-			dotColor(float64(edge.WeightValue())/float64(abs64(b.config.Total)), false))
+	}
+	if width > 1 {
+		attr = fmt.Sprintf(`%s penwidth=%d`, attr, width)
+	}
+	if color != "" {
+		attr = fmt.Sprintf(`%s color="%s"`, attr, color)
 	}
 	arrow := "->"
 	if edge.Residual {
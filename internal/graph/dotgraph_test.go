@@ -138,6 +138,74 @@ func TestComposeWithStandardGraphAndURL(t *testing.T) {
 	compareGraphs(t, buf.Bytes(), "compose6.dot")
 }
 
+func TestComposeWithHighlightedPath(t *testing.T) {
+	g := baseGraph()
+	a, c := baseAttrsAndConfig()
+
+	// Highlight Node 1, Node 2 and the edge between them.
+	a.Edges = make(map[*Edge]*DotEdgeAttributes)
+	for _, n := range g.Nodes {
+		a.Nodes[n] = &DotNodeAttributes{Bold: true, Color: "#0000b2"}
+	}
+	a.Edges[g.Nodes[0].Out[g.Nodes[1]]] = &DotEdgeAttributes{Bold: true, Color: "#0000b2"}
+
+	var buf bytes.Buffer
+	ComposeDot(&buf, g, a, c)
+
+	compareGraphs(t, buf.Bytes(), "compose7.dot")
+}
+
+func TestComposeWithClusters(t *testing.T) {
+	var nodes Nodes
+	node := func(name string, flat, cum int64) *Node {
+		n := &Node{
+			Info:        NodeInfo{Name: name},
+			Flat:        flat,
+			Cum:         cum,
+			In:          make(EdgeMap),
+			Out:         make(EdgeMap),
+			LabelTags:   make(TagMap),
+			NumericTags: make(map[string]TagMap),
+		}
+		nodes = append(nodes, n)
+		return n
+	}
+	edge := func(src, dest *Node, weight int64) {
+		e := &Edge{Src: src, Dest: dest, Weight: weight}
+		src.Out[dest] = e
+		dest.In[src] = e
+	}
+	main := node("main.main", 0, 100)
+	a := node("pkg.A", 10, 80)
+	b := node("pkg.B", 20, 50)
+	c := node("pkg.C", 30, 30)
+	d := node("other.D", 20, 20)
+	edge(main, a, 80)
+	edge(main, d, 20)
+	edge(a, b, 50)
+	edge(a, c, 20)
+	edge(b, c, 10)
+	edge(c, d, 5)
+	g := &Graph{Nodes: nodes}
+
+	for _, tc := range []struct {
+		golden    string
+		collapsed map[string]bool
+	}{
+		{"compose8.dot", nil},
+		{"compose9.dot", map[string]bool{"pkg": true}},
+	} {
+		attrs, conf := baseAttrsAndConfig()
+		conf.Cluster = func(n *Node) string { return strings.Split(n.Info.Name, ".")[0] }
+		conf.Collapsed = tc.collapsed
+
+		var buf bytes.Buffer
+		ComposeDot(&buf, g, attrs, conf)
+
+		compareGraphs(t, buf.Bytes(), tc.golden)
+	}
+}
+
 func baseGraph() *Graph {
 	src := &Node{
 		Info:        NodeInfo{Name: "src"},
@@ -0,0 +1,337 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/lemonlinger/pprof/internal/measurement"
+)
+
+// exportElements returns the identifiers of the nodes of a graph, and
+// its edges sorted by weight as ComposeDot does.
+func exportElements(g *Graph) (map[*Node]string, []*Edge) {
+	ids := make(map[*Node]string, len(g.Nodes))
+	edges := EdgeMap{}
+	for i, n := range g.Nodes {
+		ids[n] = fmt.Sprintf("N%d", i+1)
+		for _, e := range n.Out {
+			// Use a fake node to support multiple incoming edges.
+			edges[&Node{}] = e
+		}
+	}
+	return ids, edges.Sort()
+}
+
+// nodeAddress returns the address of a node in hexadecimal, or an empty
+// string if it has none.
+func nodeAddress(n *Node) string {
+	if n.Info.Address == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%#x", n.Info.Address)
+}
+
+type graphMLDocument struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Data        []graphMLData `xml:"data"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	ID     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+// ComposeGraphML writes a graph in GraphML format, as read by yEd and
+// NetworkX. The flat and cum values of the nodes and the weights of the
+// edges are raw numbers in the unit of the configuration, and the title
+// and labels of the configuration are graph data.
+func ComposeGraphML(w io.Writer, g *Graph, c *DotConfig) error {
+	doc := graphMLDocument{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{"title", "graph", "title", "string"},
+			{"labels", "graph", "labels", "string"},
+			{"unit", "graph", "unit", "string"},
+			{"label", "node", "label", "string"},
+			{"name", "node", "name", "string"},
+			{"flat", "node", "flat", "long"},
+			{"cum", "node", "cum", "long"},
+			{"file", "node", "file", "string"},
+			{"line", "node", "line", "int"},
+			{"address", "node", "address", "string"},
+			{"object", "node", "object", "string"},
+			{"weight", "edge", "weight", "long"},
+			{"inline", "edge", "inline", "boolean"},
+			{"residual", "edge", "residual", "boolean"},
+		},
+		Graph: graphMLGraph{
+			ID:          "G",
+			EdgeDefault: "directed",
+			Data: []graphMLData{
+				{"title", c.Title},
+				{"labels", strings.Join(c.Labels, "\n")},
+				{"unit", c.Unit},
+			},
+		},
+	}
+	ids, edges := exportElements(g)
+	for _, n := range g.Nodes {
+		node := graphMLNode{ID: ids[n], Data: []graphMLData{
+			{"label", n.Info.PrintableName()},
+			{"name", n.Info.Name},
+			{"flat", strconv.FormatInt(n.FlatValue(), 10)},
+			{"cum", strconv.FormatInt(n.CumValue(), 10)},
+		}}
+		for _, d := range []graphMLData{
+			{"file", n.Info.File},
+			{"line", strconv.Itoa(n.Info.Lineno)},
+			{"address", nodeAddress(n)},
+			{"object", n.Info.Objfile},
+		} {
+			if d.Value != "" && d.Value != "0" {
+				node.Data = append(node.Data, d)
+			}
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, node)
+	}
+	for i, e := range edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			ID:     fmt.Sprintf("E%d", i+1),
+			Source: ids[e.Src],
+			Target: ids[e.Dest],
+			Data: []graphMLData{
+				{"weight", strconv.FormatInt(e.WeightValue(), 10)},
+				{"inline", strconv.FormatBool(e.Inline)},
+				{"residual", strconv.FormatBool(e.Residual)},
+			},
+		})
+	}
+	return writeXML(w, doc)
+}
+
+type gexfDocument struct {
+	XMLName xml.Name  `xml:"gexf"`
+	Xmlns   string    `xml:"xmlns,attr"`
+	Version string    `xml:"version,attr"`
+	Meta    gexfMeta  `xml:"meta"`
+	Graph   gexfGraph `xml:"graph"`
+}
+
+type gexfMeta struct {
+	Creator     string `xml:"creator"`
+	Description string `xml:"description"`
+}
+
+type gexfGraph struct {
+	DefaultEdgeType string           `xml:"defaultedgetype,attr"`
+	Attributes      []gexfAttributes `xml:"attributes"`
+	Nodes           []gexfNode       `xml:"nodes>node"`
+	Edges           []gexfEdge       `xml:"edges>edge"`
+}
+
+type gexfAttributes struct {
+	Class     string          `xml:"class,attr"`
+	Attribute []gexfAttribute `xml:"attribute"`
+}
+
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type gexfNode struct {
+	ID     string      `xml:"id,attr"`
+	Label  string      `xml:"label,attr"`
+	Values []gexfValue `xml:"attvalues>attvalue"`
+}
+
+type gexfEdge struct {
+	ID     string      `xml:"id,attr"`
+	Source string      `xml:"source,attr"`
+	Target string      `xml:"target,attr"`
+	Weight int64       `xml:"weight,attr"`
+	Values []gexfValue `xml:"attvalues>attvalue"`
+}
+
+// ComposeGEXF writes a graph in GEXF format, as read by Gephi, with the
+// same attributes as ComposeGraphML. The title, labels and unit of the
+// configuration make up the description of the graph.
+func ComposeGEXF(w io.Writer, g *Graph, c *DotConfig) error {
+	var description []string
+	if c.Title != "" {
+		description = append(description, c.Title)
+	}
+	description = append(description, c.Labels...)
+	if c.Unit != "" {
+		description = append(description, "Unit: "+c.Unit)
+	}
+	doc := gexfDocument{
+		Xmlns:   "http://www.gexf.net/1.2draft",
+		Version: "1.2",
+		Meta:    gexfMeta{Creator: "pprof", Description: strings.Join(description, "\n")},
+		Graph: gexfGraph{
+			DefaultEdgeType: "directed",
+			Attributes: []gexfAttributes{
+				{"node", []gexfAttribute{
+					{"name", "name", "string"},
+					{"flat", "flat", "long"},
+					{"cum", "cum", "long"},
+					{"file", "file", "string"},
+					{"line", "line", "integer"},
+					{"address", "address", "string"},
+					{"object", "object", "string"},
+				}},
+				{"edge", []gexfAttribute{
+					{"inline", "inline", "boolean"},
+					{"residual", "residual", "boolean"},
+				}},
+			},
+		},
+	}
+	ids, edges := exportElements(g)
+	for _, n := range g.Nodes {
+		node := gexfNode{ID: ids[n], Label: n.Info.PrintableName(), Values: []gexfValue{
+			{"name", n.Info.Name},
+			{"flat", strconv.FormatInt(n.FlatValue(), 10)},
+			{"cum", strconv.FormatInt(n.CumValue(), 10)},
+		}}
+		for _, v := range []gexfValue{
+			{"file", n.Info.File},
+			{"line", strconv.Itoa(n.Info.Lineno)},
+			{"address", nodeAddress(n)},
+			{"object", n.Info.Objfile},
+		} {
+			if v.Value != "" && v.Value != "0" {
+				node.Values = append(node.Values, v)
+			}
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, node)
+	}
+	for i, e := range edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, gexfEdge{
+			ID:     fmt.Sprintf("E%d", i+1),
+			Source: ids[e.Src],
+			Target: ids[e.Dest],
+			Weight: e.WeightValue(),
+			Values: []gexfValue{
+				{"inline", strconv.FormatBool(e.Inline)},
+				{"residual", strconv.FormatBool(e.Residual)},
+			},
+		})
+	}
+	return writeXML(w, doc)
+}
+
+// writeXML writes an indented XML document with its header.
+func writeXML(w io.Writer, doc interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// ComposeMermaid writes a graph as a Mermaid flowchart, which renders
+// inline in Markdown documents. The nodes are labeled with their
+// formatted values as in ComposeDot, residual edges are dotted and
+// inline edges are marked in their labels. The title and labels of the
+// configuration are written as comments.
+func ComposeMermaid(w io.Writer, g *Graph, c *DotConfig) error {
+	var b bytes.Buffer
+	b.WriteString("flowchart TD\n")
+	if c.Title != "" {
+		fmt.Fprintf(&b, "    %%%% %s\n", c.Title)
+	}
+	for _, l := range c.Labels {
+		fmt.Fprintf(&b, "    %%%% %s\n", l)
+	}
+	ids, edges := exportElements(g)
+	for _, n := range g.Nodes {
+		flat, cum := n.FlatValue(), n.CumValue()
+		label := []string{mermaidEscape(n.Info.PrintableName()), "0"}
+		if flat != 0 {
+			label[1] = fmt.Sprintf("%s (%s)", c.FormatValue(flat), strings.TrimSpace(measurement.Percentage(flat, c.Total)))
+		}
+		if cum != flat {
+			label = append(label, fmt.Sprintf("of %s (%s)", c.FormatValue(cum), strings.TrimSpace(measurement.Percentage(cum, c.Total))))
+		}
+		fmt.Fprintf(&b, "    %s[\"%s\"]\n", ids[n], strings.Join(label, "<br/>"))
+	}
+	for _, e := range edges {
+		arrow := "-->"
+		if e.Residual {
+			arrow = "-.->"
+		}
+		label := c.FormatValue(e.WeightValue())
+		if e.Inline {
+			label += " (inline)"
+		}
+		fmt.Fprintf(&b, "    %s %s|\"%s\"| %s\n", ids[e.Src], arrow, mermaidEscape(label), ids[e.Dest])
+	}
+	_, err := b.WriteTo(w)
+	return err
+}
+
+// mermaidEscape replaces the characters that end or alter a quoted
+// Mermaid label by their entity codes.
+func mermaidEscape(s string) string {
+	return strings.NewReplacer("#", "#35;", `"`, "#quot;", "<", "#lt;", ">", "#gt;").Replace(s)
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestExportFormats(t *testing.T) {
+	g := baseGraph()
+	_, c := baseAttrsAndConfig()
+	c.Unit = "count"
+	g.Nodes[0].Info = NodeInfo{Name: `std::vector<"a" & #b>::push`, File: "src.cc", Lineno: 3, Address: 0x1234, Objfile: "bin"}
+	e := g.Nodes[0].Out[g.Nodes[1]]
+	e.Inline, e.Residual = true, true
+
+	for _, tc := range []struct {
+		format  string
+		compose func(*bytes.Buffer) error
+		want    []string
+	}{
+		{"graphml", func(b *bytes.Buffer) error { return ComposeGraphML(b, g, c) }, []string{
+			`<data key="name">std::vector&lt;&#34;a&#34; &amp; #b&gt;::push</data>`,
+			`<data key="address">0x1234</data>`,
+			`<data key="object">bin</data>`,
+			`<data key="unit">count</data>`,
+			`<edge id="E1" source="N1" target="N2">`,
+			`<data key="residual">true</data>`,
+		}},
+		{"gexf", func(b *bytes.Buffer) error { return ComposeGEXF(b, g, c) }, []string{
+			`<attvalue for="line" value="3"></attvalue>`,
+			`<edge id="E1" source="N1" target="N2" weight="10">`,
+			`<attvalue for="inline" value="true"></attvalue>`,
+		}},
+		{"mermaid", func(b *bytes.Buffer) error { return ComposeMermaid(b, g, c) }, []string{
+			`N1["0000000000001234 std::vector#lt;#quot;a#quot; & #35;b#gt;::push src.cc:3<br/>10 (10.00%)<br/>of 25 (25.00%)"]`,
+			`N1 -.->|"10 (inline)"| N2`,
+			`%% label2`,
+		}},
+	} {
+		var buf bytes.Buffer
+		if err := tc.compose(&buf); err != nil {
+			t.Fatalf("%s: %v", tc.format, err)
+		}
+		got := buf.String()
+		for _, want := range tc.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s output does not contain %s:\n%s", tc.format, want, got)
+			}
+		}
+		if tc.format != "mermaid" {
+			var doc struct{}
+			if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+				t.Errorf("%s output is not valid XML: %v", tc.format, err)
+			}
+		}
+	}
+}
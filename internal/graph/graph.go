@@ -20,9 +20,11 @@ import (
 	"math"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/lemonlinger/pprof/profile"
 )
@@ -47,8 +49,11 @@ type Options struct {
 	ObjNames          bool                       // Always preserve obj filename
 	OrigFnNames       bool                       // Preserve original (eg mangled) function names
 
-	CallTree     bool // Build a tree instead of a graph
-	DropNegative bool // Drop nodes with overall negative values
+	CallTree      bool // Build a tree instead of a graph
+	Inverted      bool // Build the tree from the leaf frames of the samples, with callers as children
+	DropNegative  bool // Drop nodes with overall negative values
+	FoldRecursion bool // Collapse recursive calls in each sample
+	SkipTags      bool // Do not collect the label and numeric tags of the nodes
 
 	KeptNodes NodeSet // If non-nil, only use nodes in this set
 }
@@ -85,6 +90,43 @@ type Node struct {
 	// for NumericTags is the name of the LabelTag they are associated
 	// to, or "" for numeric tags not associated to a label tag.
 	NumericTags map[string]TagMap
+
+	// Recursion describes the recursive calls folded into this node
+	// when the graph is built with FoldRecursion, or is nil if there
+	// were none.
+	Recursion *RecursionDepth
+}
+
+// RecursionDepth summarizes the depths of the recursive calls of a
+// node, as the number of times it appears in the stacks of the samples
+// in which it is recursive.
+type RecursionDepth struct {
+	Max     int
+	Total   int64 // Sum of the depths.
+	Samples int64 // Number of samples in which the node is recursive.
+}
+
+// Mean returns the average recursion depth.
+func (r *RecursionDepth) Mean() float64 {
+	if r.Samples == 0 {
+		return 0
+	}
+	return float64(r.Total) / float64(r.Samples)
+}
+
+// addRecursion records a recursion of the given depth.
+func (n *Node) addRecursion(depth int) {
+	if depth < 2 {
+		return
+	}
+	if n.Recursion == nil {
+		n.Recursion = &RecursionDepth{}
+	}
+	if depth > n.Recursion.Max {
+		n.Recursion.Max = depth
+	}
+	n.Recursion.Total += int64(depth)
+	n.Recursion.Samples++
 }
 
 // FlatValue returns the exclusive value for this node, computing the
@@ -302,12 +344,141 @@ func New(prof *profile.Profile, o *Options) *Graph {
 	return g
 }
 
+// minShardSamples is the number of samples per shard below which a
+// graph is not built from shards of the samples accumulated in parallel.
+const minShardSamples = 10000
+
 // newGraph computes a graph from a profile. It returns the graph, and
 // a map from the profile location indices to the corresponding graph
 // nodes.
 func newGraph(prof *profile.Profile, o *Options) (*Graph, map[uint64]Nodes) {
 	nodes, locationMap := CreateNodes(prof, o)
-	for _, sample := range prof.Sample {
+	locations := newLocationIndex(nodes, locationMap)
+
+	// Accumulate the samples in shards, merged into the nodes in order.
+	// Merging only adds values, so the graph does not depend on the
+	// number of shards.
+	count := runtime.GOMAXPROCS(0)
+	if n := len(prof.Sample) / minShardSamples; n < count {
+		count = n
+	}
+	if count < 1 {
+		count = 1
+	}
+	shards := make([]*graphShard, count)
+	var wg sync.WaitGroup
+	for i := range shards {
+		shards[i] = newGraphShard(len(nodes))
+		samples := prof.Sample[i*len(prof.Sample)/count : (i+1)*len(prof.Sample)/count]
+		if count == 1 {
+			shards[i].addSamples(samples, locations, o)
+			break
+		}
+		wg.Add(1)
+		go func(shard *graphShard) {
+			defer wg.Done()
+			shard.addSamples(samples, locations, o)
+		}(shards[i])
+	}
+	wg.Wait()
+	for _, shard := range shards {
+		shard.mergeInto(nodes)
+	}
+
+	return selectNodesForGraph(nodes, o.DropNegative), locationMap
+}
+
+// locationIndex maps the IDs of the locations of a profile to the
+// indexes of the nodes of their lines in a graph, or -1 for the lines
+// without a node. IDs are looked up in a slice when they are dense
+// enough, as they usually are.
+type locationIndex struct {
+	dense  [][]int32
+	sparse map[uint64][]int32
+}
+
+func newLocationIndex(nodes Nodes, locations map[uint64]Nodes) *locationIndex {
+	index := make(map[*Node]int32, len(nodes))
+	for i, n := range nodes {
+		index[n] = int32(i)
+	}
+	var maxID uint64
+	for id := range locations {
+		if id > maxID {
+			maxID = id
+		}
+	}
+	li := &locationIndex{}
+	if maxID < uint64(4*len(locations)+16) {
+		li.dense = make([][]int32, maxID+1)
+	} else {
+		li.sparse = make(map[uint64][]int32, len(locations))
+	}
+	for id, lnodes := range locations {
+		ids := make([]int32, len(lnodes))
+		for i, n := range lnodes {
+			ids[i] = -1
+			if n != nil {
+				ids[i] = index[n]
+			}
+		}
+		if li.dense != nil {
+			li.dense[id] = ids
+		} else {
+			li.sparse[id] = ids
+		}
+	}
+	return li
+}
+
+// get returns the node indexes of the lines of a location.
+func (li *locationIndex) get(id uint64) []int32 {
+	if li.dense == nil {
+		return li.sparse[id]
+	}
+	if id < uint64(len(li.dense)) {
+		return li.dense[id]
+	}
+	return nil
+}
+
+// graphShard accumulates the values of a shard of the samples of a
+// profile into partial nodes and edges, addressed by the indexes of the
+// nodes of the graph, to be merged into the graph afterwards.
+type graphShard struct {
+	nodes []*Node
+	edges map[[2]int32]*Edge
+
+	// Buffers reused across samples.
+	frames   []stackFrame
+	seenNode []int // Last sample adding to the cum value of each node.
+	seenEdge map[[2]int32]bool
+}
+
+func newGraphShard(nodes int) *graphShard {
+	return &graphShard{
+		nodes:    make([]*Node, nodes),
+		edges:    make(map[[2]int32]*Edge),
+		seenNode: make([]int, nodes),
+		seenEdge: make(map[[2]int32]bool),
+	}
+}
+
+// node returns the partial node with an index, creating it if needed.
+func (s *graphShard) node(i int32) *Node {
+	n := s.nodes[i]
+	if n == nil {
+		n = &Node{
+			LabelTags:   make(TagMap),
+			NumericTags: make(map[string]TagMap),
+		}
+		s.nodes[i] = n
+	}
+	return n
+}
+
+func (s *graphShard) addSamples(samples []*profile.Sample, locations *locationIndex, o *Options) {
+	for si, sample := range samples {
 		var w, dw int64
 		w = o.SampleValue(sample.Value)
 		if o.SampleMeanDivisor != nil {
@@ -316,44 +487,108 @@ func newGraph(prof *profile.Profile, o *Options) (*Graph, map[uint64]Nodes) {
 		if dw == 0 && w == 0 {
 			continue
 		}
-		seenNode := make(map[*Node]bool, len(sample.Location))
-		seenEdge := make(map[nodePair]bool, len(sample.Location))
-		var parent *Node
-		// A residual edge goes over one or more nodes that were not kept.
+		// Collect the nodes of the sample frames, root first. A
+		// residual frame follows one or more frames that were not kept.
+		frames := s.frames[:0]
 		residual := false
-
-		labels := joinLabels(sample)
-		// Group the sample frames, based on a global map.
 		for i := len(sample.Location) - 1; i >= 0; i-- {
-			l := sample.Location[i]
-			locNodes := locationMap[l.ID]
-			for ni := len(locNodes) - 1; ni >= 0; ni-- {
-				n := locNodes[ni]
-				if n == nil {
+			ids := locations.get(sample.Location[i].ID)
+			for ni := len(ids) - 1; ni >= 0; ni-- {
+				if ids[ni] < 0 {
 					residual = true
 					continue
 				}
-				// Add cum weight to all nodes in stack, avoiding double counting.
-				if _, ok := seenNode[n]; !ok {
-					seenNode[n] = true
-					n.addSample(dw, w, labels, sample.NumLabel, sample.NumUnit, o.FormatTag, false)
-				}
-				// Update edge weights for all edges in stack, avoiding double counting.
-				if _, ok := seenEdge[nodePair{n, parent}]; !ok && parent != nil && n != parent {
-					seenEdge[nodePair{n, parent}] = true
-					parent.AddToEdgeDiv(n, dw, w, residual, ni != len(locNodes)-1)
-				}
-				parent = n
+				frames = append(frames, stackFrame{ids[ni], residual, ni != len(ids)-1})
 				residual = false
 			}
 		}
-		if parent != nil && !residual {
+		s.frames = frames
+		var depths []int
+		if o.FoldRecursion {
+			frames, depths = foldRecursion(frames)
+		}
+
+		var labels string
+		var numLabel map[string][]int64
+		var numUnit map[string][]string
+		if !o.SkipTags {
+			labels, numLabel, numUnit = joinLabels(sample), sample.NumLabel, sample.NumUnit
+		}
+		for e := range s.seenEdge {
+			delete(s.seenEdge, e)
+		}
+		parent := int32(-1)
+		for i, f := range frames {
+			// Add cum weight to all nodes in stack, avoiding double counting.
+			if s.seenNode[f.id] != si+1 {
+				s.seenNode[f.id] = si + 1
+				n := s.node(f.id)
+				n.addSample(dw, w, labels, numLabel, numUnit, o.FormatTag, false)
+				if depths != nil {
+					n.addRecursion(depths[i])
+				}
+			}
+			// Update edge weights for all edges in stack, avoiding double counting.
+			if e := [2]int32{parent, f.id}; parent >= 0 && f.id != parent && !s.seenEdge[e] {
+				s.seenEdge[e] = true
+				s.addEdge(e, dw, w, f.residual, f.inline)
+			}
+			parent = f.id
+		}
+		if parent >= 0 && !residual {
 			// Add flat weight to leaf node.
-			parent.addSample(dw, w, labels, sample.NumLabel, sample.NumUnit, o.FormatTag, true)
+			s.node(parent).addSample(dw, w, labels, numLabel, numUnit, o.FormatTag, true)
 		}
 	}
+}
 
-	return selectNodesForGraph(nodes, o.DropNegative), locationMap
+// addEdge adds to the weight of the edge between two nodes as
+// Node.AddToEdgeDiv does.
+func (s *graphShard) addEdge(nodes [2]int32, dw, w int64, residual, inline bool) {
+	e := s.edges[nodes]
+	if e == nil {
+		s.edges[nodes] = &Edge{WeightDiv: dw, Weight: w, Residual: residual, Inline: inline}
+		return
+	}
+	e.WeightDiv += dw
+	e.Weight += w
+	e.Residual = e.Residual || residual
+	e.Inline = e.Inline && inline
+}
+
+// mergeInto adds the values, tags, recursions and edges of the partial
+// nodes of the shard to the nodes of the graph.
+func (s *graphShard) mergeInto(nodes Nodes) {
+	for i, p := range s.nodes {
+		if p == nil {
+			continue
+		}
+		n := nodes[i]
+		n.Flat += p.Flat
+		n.FlatDiv += p.FlatDiv
+		n.Cum += p.Cum
+		n.CumDiv += p.CumDiv
+		n.LabelTags.merge(p.LabelTags)
+		for l, tm := range p.NumericTags {
+			if n.NumericTags[l] == nil {
+				n.NumericTags[l] = make(TagMap)
+			}
+			n.NumericTags[l].merge(tm)
+		}
+		if r := p.Recursion; r != nil {
+			if n.Recursion == nil {
+				n.Recursion = &RecursionDepth{}
+			}
+			if r.Max > n.Recursion.Max {
+				n.Recursion.Max = r.Max
+			}
+			n.Recursion.Total += r.Total
+			n.Recursion.Samples += r.Samples
+		}
+	}
+	for e, w := range s.edges {
+		nodes[e[0]].AddToEdgeDiv(nodes[e[1]], w.WeightDiv, w.Weight, w.Residual, w.Inline)
+	}
 }
 
 func selectNodesForGraph(nodes Nodes, dropNegative bool) *Graph {
@@ -380,6 +615,39 @@ type nodePair struct {
 
 func newTree(prof *profile.Profile, o *Options) (g *Graph) {
 	parentNodeMap := make(map[*Node]NodeMap, len(prof.Sample))
+
+	// Intern the infos of the lines of the locations, so that they are
+	// computed once and frames refer to them by index.
+	var infos []NodeInfo
+	infoIDs := make(map[NodeInfo]int32)
+	locationInfos := make(map[*profile.Location][]int32, len(prof.Location))
+	lineInfos := func(l *profile.Location) []int32 {
+		if ids, ok := locationInfos[l]; ok {
+			return ids
+		}
+		lines := l.Line
+		if len(lines) == 0 {
+			lines = []profile.Line{{}} // Create empty line to include location info.
+		}
+		var objfile string
+		if m := l.Mapping; m != nil && m.File != "" {
+			objfile = m.File
+		}
+		ids := make([]int32, len(lines))
+		for i, line := range lines {
+			info := *nodeInfo(l, line, objfile, o)
+			id, ok := infoIDs[info]
+			if !ok {
+				id = int32(len(infos))
+				infos = append(infos, info)
+				infoIDs[info] = id
+			}
+			ids[i] = id
+		}
+		locationInfos[l] = ids
+		return ids
+	}
+
 	for _, sample := range prof.Sample {
 		var w, dw int64
 		w = o.SampleValue(sample.Value)
@@ -389,34 +657,60 @@ func newTree(prof *profile.Profile, o *Options) (g *Graph) {
 		if dw == 0 && w == 0 {
 			continue
 		}
-		var parent *Node
-		labels := joinLabels(sample)
-		// Group the sample frames, based on a per-node map.
+		// Collect the sample frames, root first.
+		var frames []stackFrame
 		for i := len(sample.Location) - 1; i >= 0; i-- {
-			l := sample.Location[i]
-			lines := l.Line
-			if len(lines) == 0 {
-				lines = []profile.Line{{}} // Create empty line to include location info.
+			ids := lineInfos(sample.Location[i])
+			for lidx := len(ids) - 1; lidx >= 0; lidx-- {
+				frames = append(frames, stackFrame{id: ids[lidx], inline: lidx != len(ids)-1})
 			}
-			for lidx := len(lines) - 1; lidx >= 0; lidx-- {
-				nodeMap := parentNodeMap[parent]
-				if nodeMap == nil {
-					nodeMap = make(NodeMap)
-					parentNodeMap[parent] = nodeMap
-				}
-				n := nodeMap.findOrInsertLine(l, lines[lidx], o)
-				if n == nil {
-					continue
-				}
-				n.addSample(dw, w, labels, sample.NumLabel, sample.NumUnit, o.FormatTag, false)
-				if parent != nil {
-					parent.AddToEdgeDiv(n, dw, w, false, lidx != len(lines)-1)
+		}
+		var depths []int
+		if o.FoldRecursion {
+			frames, depths = foldRecursion(frames)
+		}
+		if o.Inverted {
+			reverseFrames(frames, depths)
+		}
+
+		var parent, leaf *Node
+		var labels string
+		var numLabel map[string][]int64
+		var numUnit map[string][]string
+		if !o.SkipTags {
+			labels, numLabel, numUnit = joinLabels(sample), sample.NumLabel, sample.NumUnit
+		}
+		// Group the sample frames, based on a per-node map.
+		for i, f := range frames {
+			nodeMap := parentNodeMap[parent]
+			if nodeMap == nil {
+				nodeMap = make(NodeMap)
+				parentNodeMap[parent] = nodeMap
+			}
+			n := nodeMap.FindOrInsertNode(infos[f.id], o.KeptNodes)
+			if n == nil {
+				continue
+			}
+			n.addSample(dw, w, labels, numLabel, numUnit, o.FormatTag, false)
+			if depths != nil {
+				n.addRecursion(depths[i])
+			}
+			if parent != nil {
+				inline := f.inline
+				if o.Inverted {
+					// The edge goes from a callee to its caller, so it is
+					// inlined if the callee is.
+					inline = frames[i-1].inline
 				}
-				parent = n
+				parent.AddToEdgeDiv(n, dw, w, false, inline)
+			}
+			if leaf == nil || !o.Inverted {
+				leaf = n
 			}
+			parent = n
 		}
-		if parent != nil {
-			parent.addSample(dw, w, labels, sample.NumLabel, sample.NumUnit, o.FormatTag, true)
+		if leaf != nil {
+			leaf.addSample(dw, w, labels, numLabel, numUnit, o.FormatTag, true)
 		}
 	}
 
@@ -427,6 +721,53 @@ func newTree(prof *profile.Profile, o *Options) (g *Graph) {
 	return selectNodesForGraph(nodes, o.DropNegative)
 }
 
+// stackFrame is a frame of the stack of a sample while building a
+// graph. The id is the index of the node of the frame when building a
+// graph, and of its interned info when building a tree.
+type stackFrame struct {
+	id               int32
+	residual, inline bool
+}
+
+// reverseFrames reverses a stack in place, along with the recursion
+// depths of its frames, if any.
+func reverseFrames(frames []stackFrame, depths []int) {
+	for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
+		frames[i], frames[j] = frames[j], frames[i]
+		if depths != nil {
+			depths[i], depths[j] = depths[j], depths[i]
+		}
+	}
+}
+
+// foldRecursion collapses the recursive calls of a stack, root first:
+// when a frame repeats an earlier one, directly or through other
+// frames, the frames after the earlier one are dropped, so that the
+// stack continues from it. It returns the folded stack and, for each
+// of its frames, the number of times it appeared in the original one.
+func foldRecursion(frames []stackFrame) ([]stackFrame, []int) {
+	var folded []stackFrame
+	index := make(map[int32]int, len(frames)) // Position in folded.
+	count := make(map[int32]int, len(frames))
+	for _, f := range frames {
+		count[f.id]++
+		if i, ok := index[f.id]; ok {
+			for _, g := range folded[i+1:] {
+				delete(index, g.id)
+			}
+			folded = folded[:i+1]
+			continue
+		}
+		index[f.id] = len(folded)
+		folded = append(folded, f)
+	}
+	depths := make([]int, len(folded))
+	for i, f := range folded {
+		depths[i] = count[f.id]
+	}
+	return folded, depths
+}
+
 // ShortenFunctionName returns a shortened version of a function's name.
 func ShortenFunctionName(f string) string {
 	for _, re := range []*regexp.Regexp{goRegExp, javaRegExp, cppRegExp} {
@@ -639,6 +980,9 @@ func (n *Node) addSample(dw, w int64, labels string, numLabel map[string][]int64
 		}
 	}
 
+	if len(numLabel) == 0 {
+		return
+	}
 	numericTags := n.NumericTags[labels]
 	if numericTags == nil {
 		numericTags = TagMap{}
@@ -672,6 +1016,17 @@ func defaultLabelFormat(v int64, key string) string {
 	return strconv.FormatInt(v, 10)
 }
 
+// merge adds the values of the tags of another map to the tags of m.
+func (m TagMap) merge(other TagMap) {
+	for label, o := range other {
+		t := m.findOrAddTag(label, o.Unit, o.Value)
+		t.Flat += o.Flat
+		t.FlatDiv += o.FlatDiv
+		t.Cum += o.Cum
+		t.CumDiv += o.CumDiv
+	}
+}
+
 func (m TagMap) findOrAddTag(label, unit string, value int64) *Tag {
 	l := m[label]
 	if l == nil {
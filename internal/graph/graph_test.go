@@ -16,6 +16,10 @@ package graph
 
 import (
 	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/lemonlinger/pprof/profile"
@@ -491,3 +495,296 @@ func TestShortenFunctionName(t *testing.T) {
 		}
 	}
 }
+
+// testStack is the call stack of a sample of a test profile, leaf first,
+// with its value and labels.
+type testStack struct {
+	stack  []string
+	value  int64
+	labels map[string][]string
+}
+
+// stackProfile returns a profile with a sample for each of stacks.
+func stackProfile(t *testing.T, stacks ...testStack) *profile.Profile {
+	t.Helper()
+	b := profile.NewBuilder([]*profile.ValueType{{Type: "samples", Unit: "count"}})
+	for _, s := range stacks {
+		var stack []profile.Frame
+		for _, f := range s.stack {
+			stack = append(stack, profile.Frame{Function: f})
+		}
+		if err := b.Add(profile.Frames(stack...), []int64{s.value}, &profile.SampleLabels{Label: s.labels}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	return b.Profile()
+}
+
+// handlerStacks are the stacks of a server whose handlers both call
+// serialize, with an unrelated background stack.
+var handlerStacks = []testStack{
+	{[]string{"encode", "serialize", "handleA", "main"}, 40, nil},
+	{[]string{"encode", "serialize", "handleB", "main"}, 35, nil},
+	{[]string{"serialize", "handleB", "main"}, 5, nil},
+	{[]string{"handleA", "main"}, 10, nil},
+	{[]string{"handleB", "main"}, 10, nil},
+	{[]string{"background"}, 3, nil},
+}
+
+func TestDominators(t *testing.T) {
+	g := New(stackProfile(t, handlerStacks...), &Options{SampleValue: func(v []int64) int64 { return v[0] }})
+	doms := g.Dominators()
+
+	want := map[string]struct {
+		idom      string
+		dominated int64
+	}{
+		"main":       {"", 100},
+		"handleA":    {"main", 10},
+		"handleB":    {"main", 10},
+		"serialize":  {"main", 80},
+		"encode":     {"serialize", 75},
+		"background": {"", 3},
+	}
+	if len(g.Nodes) != len(want) {
+		t.Fatalf("got %d nodes, want %d", len(g.Nodes), len(want))
+	}
+	for _, n := range g.Nodes {
+		var idom string
+		if d := doms.Idom[n]; d != nil {
+			idom = d.Info.Name
+		}
+		w := want[n.Info.Name]
+		if idom != w.idom || doms.Dominated[n] != w.dominated {
+			t.Errorf("%s: got dominator %q and dominated %d, want %q and %d", n.Info.Name, idom, doms.Dominated[n], w.idom, w.dominated)
+		}
+	}
+}
+
+func TestHotPathAndPathsBetween(t *testing.T) {
+	g := New(stackProfile(t, handlerStacks...), &Options{SampleValue: func(v []int64) int64 { return v[0] }})
+	names := func(nodes Nodes, edges []*Edge) []string {
+		var got []string
+		for _, n := range nodes {
+			got = append(got, n.Info.Name)
+		}
+		for _, e := range edges {
+			got = append(got, e.Src.Info.Name+"->"+e.Dest.Info.Name)
+		}
+		sort.Strings(got)
+		return got
+	}
+	match := func(name string) func(*Node) bool {
+		return func(n *Node) bool { return n.Info.Name == name }
+	}
+
+	for _, tc := range []struct {
+		desc      string
+		got, want []string
+	}{
+		{
+			"hot path",
+			names(g.HotPath()),
+			[]string{"encode", "handleA", "handleA->serialize", "main", "main->handleA", "serialize", "serialize->encode"},
+		},
+		{
+			"paths from handleB to encode",
+			names(g.PathsBetween(match("handleB"), match("encode"))),
+			[]string{"encode", "handleB", "handleB->serialize", "serialize", "serialize->encode"},
+		},
+		{
+			"paths to handleA",
+			names(g.PathsBetween(nil, match("handleA"))),
+			[]string{"handleA", "main", "main->handleA"},
+		},
+		{
+			"paths from encode to main",
+			names(g.PathsBetween(match("encode"), match("main"))),
+			nil,
+		},
+	} {
+		if !reflect.DeepEqual(tc.got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.desc, tc.got, tc.want)
+		}
+	}
+}
+
+func TestFoldRecursion(t *testing.T) {
+	prof := stackProfile(t,
+		testStack{[]string{"visit", "walk", "walk", "walk", "main"}, 10, nil},
+		testStack{[]string{"walk", "walk", "main"}, 5, nil},
+		testStack{[]string{"term", "expr", "parse", "expr", "parse", "main"}, 20, nil},
+	)
+
+	type want struct {
+		flat, cum int64
+		max       int
+		mean      float64
+	}
+	// The call tree has the same nodes, since recursion is folded.
+	wantNodes := map[string]want{
+		"main":  {0, 35, 0, 0},
+		"walk":  {5, 15, 3, 2.5},
+		"visit": {10, 10, 0, 0},
+		"parse": {0, 20, 2, 2},
+		"expr":  {0, 20, 2, 2},
+		"term":  {20, 20, 0, 0},
+	}
+	for _, callTree := range []bool{false, true} {
+		g := New(prof, &Options{
+			SampleValue:   func(v []int64) int64 { return v[0] },
+			CallTree:      callTree,
+			FoldRecursion: true,
+		})
+		got := make(map[string]want)
+		for _, n := range g.Nodes {
+			if _, ok := got[n.Info.Name]; ok {
+				t.Errorf("call tree %v: got several nodes for %s", callTree, n.Info.Name)
+			}
+			w := want{flat: n.Flat, cum: n.Cum}
+			if r := n.Recursion; r != nil {
+				w.max, w.mean = r.Max, r.Mean()
+			}
+			got[n.Info.Name] = w
+		}
+		if !reflect.DeepEqual(got, wantNodes) {
+			t.Errorf("call tree %v: got nodes %v, want %v", callTree, got, wantNodes)
+		}
+	}
+}
+
+func TestInvertedTree(t *testing.T) {
+	prof := stackProfile(t,
+		testStack{[]string{"bar", "foo", "main"}, 10, nil},
+		testStack{[]string{"bar", "main"}, 5, nil},
+		testStack{[]string{"foo", "main"}, 3, nil},
+	)
+	g := New(prof, &Options{
+		SampleValue: func(v []int64) int64 { return v[0] },
+		CallTree:    true,
+		Inverted:    true,
+	})
+
+	// Name each node by its path from the root of the tree, leaf first.
+	path := func(n *Node) string {
+		p := n.Info.Name
+		for len(n.In) != 0 {
+			for src := range n.In {
+				n = src
+			}
+			p = n.Info.Name + " " + p
+		}
+		return p
+	}
+	got := make(map[string][2]int64)
+	for _, n := range g.Nodes {
+		got[path(n)] = [2]int64{n.Flat, n.Cum}
+	}
+	want := map[string][2]int64{
+		"bar":          {15, 15},
+		"bar foo":      {0, 10},
+		"bar foo main": {0, 10},
+		"bar main":     {0, 5},
+		"foo":          {3, 3},
+		"foo main":     {0, 3},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got nodes %v, want %v", got, want)
+	}
+}
+
+func TestNewGraphShards(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+
+	prof := stackProfile(t,
+		testStack{[]string{"malloc", "alloc", "main"}, 1, map[string][]string{"key": {"a"}}},
+		testStack{[]string{"alloc", "walk", "walk", "main"}, 1, map[string][]string{"key": {"b"}}},
+		testStack{[]string{"walk", "main"}, 1, map[string][]string{"key": {"a"}}},
+		testStack{[]string{"main"}, 1, nil},
+	)
+	// Repeat the samples enough to be split in shards, each of which has
+	// some of every stack.
+	stacks := prof.Sample
+	prof.Sample = nil
+	for i := 0; i < 4*minShardSamples; i++ {
+		s := *stacks[i%len(stacks)]
+		s.Value = []int64{int64(i%3 + 1)}
+		if len(s.Label) != 0 {
+			s.NumLabel = map[string][]int64{"bytes": {int64(64 << uint(i%2))}}
+		}
+		prof.Sample = append(prof.Sample, &s)
+	}
+
+	summary := func(g *Graph) []string {
+		var lines []string
+		for _, n := range g.Nodes {
+			lines = append(lines, fmt.Sprintf("%s %d %d", n.Info.Name, n.Flat, n.Cum))
+			if r := n.Recursion; r != nil {
+				lines = append(lines, fmt.Sprintf("%s recursion %d %d %d", n.Info.Name, r.Max, r.Total, r.Samples))
+			}
+			for _, t := range n.LabelTags {
+				lines = append(lines, fmt.Sprintf("%s tag %s %d %d", n.Info.Name, t.Name, t.Flat, t.Cum))
+			}
+			for l, tm := range n.NumericTags {
+				for _, t := range tm {
+					lines = append(lines, fmt.Sprintf("%s tag %s/%s %d %d", n.Info.Name, l, t.Name, t.Flat, t.Cum))
+				}
+			}
+			for _, e := range n.Out {
+				lines = append(lines, fmt.Sprintf("%s -> %s %d residual=%v inline=%v", n.Info.Name, e.Dest.Info.Name, e.Weight, e.Residual, e.Inline))
+			}
+		}
+		sort.Strings(lines)
+		return lines
+	}
+	for _, o := range []Options{
+		{},
+		{FoldRecursion: true},
+		{SkipTags: true},
+	} {
+		o.SampleValue = func(v []int64) int64 { return v[0] }
+		runtime.GOMAXPROCS(1)
+		want := summary(New(prof, &o))
+		runtime.GOMAXPROCS(4)
+		got := summary(New(prof, &o))
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("options %+v: got graph from shards\n%s\nwant\n%s", o, strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+		if hasTags := strings.Contains(strings.Join(got, "\n"), " tag "); hasTags == o.SkipTags {
+			t.Errorf("options %+v: got tags %v, want %v", o, hasTags, !o.SkipTags)
+		}
+	}
+}
+
+func TestPackageName(t *testing.T) {
+	for _, tc := range []struct {
+		name, pkg, module string
+	}{
+		{"main.main", "main", "main"},
+		{"runtime.mallocgc", "runtime", "std"},
+		{"sync.(*Mutex).Lock", "sync", "std"},
+		{"strings.Builder.String", "strings", "std"},
+		{"runtime.gcBgMarkWorker.func1", "runtime", "std"},
+		{"net/http.(*conn).serve", "net/http", "std"},
+		{"github.com/user/repo/pkg.(*Server).Handle.func2", "github.com/user/repo/pkg", "github.com/user/repo"},
+		{"github.com/user/repo/v2/pkg.Map[go.shape.int,go.shape.string]", "github.com/user/repo/v2/pkg", "github.com/user/repo/v2"},
+		{"example.com/mod/pkg.(*Tree[example.com/mod/other.Key]).Walk", "example.com/mod/pkg", "example.com/mod"},
+		{"golang.org/x/net/http2.(*Framer).ReadFrame", "golang.org/x/net/http2", "golang.org/x/net"},
+		{"google.golang.org/grpc.(*Server).serveStreams", "google.golang.org/grpc", "google.golang.org/grpc"},
+		{"gopkg.in/yaml.v2.(*decoder).unmarshal", "gopkg.in/yaml.v2", "gopkg.in/yaml.v2"},
+		{"std::vector<std::pair<int, int> >::push_back(std::pair<int, int> const&)", "std::vector", "std"},
+		{"(anonymous namespace)::Parser::ParseExpr() const", "Parser", "Parser"},
+		{"core::ptr::drop_in_place<alloc::vec::Vec<u8>>", "core::ptr", "core"},
+		{"com.example.server.Handler$Inner.lambda$run$0", "com.example.server", "com.example"},
+		{"java.lang.Thread.run", "java.lang", "java.lang"},
+		{"malloc", "", ""},
+		{"", "", ""},
+	} {
+		if got := PackageName(tc.name); got != tc.pkg {
+			t.Errorf("PackageName(%q) = %q, want %q", tc.name, got, tc.pkg)
+		}
+		if got := ModuleName(tc.name); got != tc.module {
+			t.Errorf("ModuleName(%q) = %q, want %q", tc.name, got, tc.module)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	goVersionRegExp   = regexp.MustCompile(`^v[0-9]+$`)
+	cppArgumentRegExp = regexp.MustCompile(`\([^()]*\)(?: const)?$`)
+)
+
+// PackageName returns the package of a function from its symbol name,
+// or an empty string if it has none. It handles Go names, including
+// generic functions, closures and methods, such as
+// "example.com/mod/pkg.(*T[...]).Method.func1" in package
+// "example.com/mod/pkg", C++ and Rust names, whose package is the
+// enclosing namespace or class, and Java names, whose package is made
+// of the lowercase components before the class name.
+func PackageName(name string) string {
+	if strings.Contains(name, "::") {
+		parts := cppParts(name)
+		if len(parts) < 2 {
+			return ""
+		}
+		return strings.Join(parts[:len(parts)-1], "::")
+	}
+	path, elems := goParts(name)
+	if len(elems) < 2 {
+		return ""
+	}
+	if path != "" {
+		return path + goPackageElem(path, elems)
+	}
+	// A Java package, or a Go package of the standard library.
+	for i := 1; i < len(elems); i++ {
+		if !startsWith(elems[i], unicode.IsUpper) {
+			continue
+		}
+		for _, e := range elems[:i] {
+			if !startsWith(e, unicode.IsLower) {
+				return elems[0]
+			}
+		}
+		return strings.Join(elems[:i], ".")
+	}
+	return elems[0]
+}
+
+// ModuleName returns the module of a function from its symbol name, or
+// an empty string if it has none. Go packages are grouped by the
+// repository path they are imported from, with the standard library in
+// "std". C++ and Rust names are grouped by their outermost namespace
+// or crate, and Java names by the first two components of their
+// package.
+func ModuleName(name string) string {
+	pkg := PackageName(name)
+	switch {
+	case pkg == "":
+		return ""
+	case strings.Contains(name, "::"):
+		return strings.SplitN(pkg, "::", 2)[0]
+	case strings.Contains(pkg, "/"):
+		return goModule(pkg)
+	case strings.Contains(pkg, "."):
+		// A Java package.
+		parts := strings.SplitN(pkg, ".", 3)
+		return parts[0] + "." + parts[1]
+	case pkg == "main", !startsWith(pkg, unicode.IsLower):
+		// The main Go package, or a Java class in the default package.
+		return pkg
+	}
+	return "std"
+}
+
+// goParts splits a Go or Java symbol name into the path of its package,
+// up to its last slash, and the dot-separated elements that follow,
+// dropping the type parameters of generic functions.
+func goParts(name string) (string, []string) {
+	name = stripBrackets(name, '[', ']')
+	var path string
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		path, name = name[:i+1], name[i+1:]
+	}
+	return path, strings.Split(name, ".")
+}
+
+// goPackageElem returns the last element of a Go package path from the
+// elements of a symbol name following the path, which is the first one
+// unless the package is versioned as in "gopkg.in/yaml.v2".
+func goPackageElem(path string, elems []string) string {
+	if strings.HasPrefix(path, "gopkg.in/") && goVersionRegExp.MatchString(elems[1]) {
+		return elems[0] + "." + elems[1]
+	}
+	return elems[0]
+}
+
+// goModule returns the module of a Go package path with a slash. It
+// uses the conventions of the common code hosts, since the actual
+// module boundaries are not recorded in profiles.
+func goModule(pkg string) string {
+	parts := strings.Split(pkg, "/")
+	if !strings.Contains(parts[0], ".") {
+		// Standard library packages have no domain name.
+		return "std"
+	}
+	n := 2
+	switch parts[0] {
+	case "github.com", "gitlab.com", "bitbucket.org", "golang.org":
+		n = 3
+	case "gopkg.in":
+		if len(parts) > 1 && !strings.Contains(parts[1], ".") {
+			n = 3
+		}
+	}
+	if len(parts) > n && goVersionRegExp.MatchString(parts[n]) {
+		n++
+	}
+	if n > len(parts) {
+		n = len(parts)
+	}
+	return strings.Join(parts[:n], "/")
+}
+
+// cppParts splits a C++ or Rust symbol name into its namespaces,
+// classes and function name, dropping template arguments and the
+// argument list.
+func cppParts(name string) []string {
+	name = strings.TrimPrefix(name, "(anonymous namespace)::")
+	name = cppArgumentRegExp.ReplaceAllString(name, "")
+	name = stripBrackets(name, '<', '>')
+	return strings.Split(name, "::")
+}
+
+// stripBrackets removes the text between open and close brackets,
+// including nested ones.
+func stripBrackets(s string, left, right byte) string {
+	if strings.IndexByte(s, left) < 0 {
+		return s
+	}
+	var b bytes.Buffer
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == left:
+			depth++
+		case c == right && depth > 0:
+			depth--
+		case depth == 0:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func startsWith(s string, f func(rune) bool) bool {
+	for _, r := range s {
+		return f(r)
+	}
+	return false
+}
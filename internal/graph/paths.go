@@ -0,0 +1,111 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package graph
+
+// HotPath returns the path of the graph that starts at its heaviest
+// root and follows the heaviest outgoing edge of each node, skipping
+// edges back to the path, until it reaches a node without callees. It
+// returns the nodes of the path and the edges between them.
+func (g *Graph) HotPath() (Nodes, []*Edge) {
+	var root *Node
+	for _, n := range g.Nodes {
+		if !n.isRoot() {
+			continue
+		}
+		if root == nil || abs64(n.Cum) > abs64(root.Cum) ||
+			abs64(n.Cum) == abs64(root.Cum) && n.Info.PrintableName() < root.Info.PrintableName() {
+			root = n
+		}
+	}
+	if root == nil {
+		return nil, nil
+	}
+
+	nodes := Nodes{root}
+	var edges []*Edge
+	onPath := map[*Node]bool{root: true}
+	for n := root; ; {
+		var next *Edge
+		for _, e := range n.Out.Sort() {
+			if !onPath[e.Dest] {
+				next = e
+				break
+			}
+		}
+		if next == nil {
+			return nodes, edges
+		}
+		n = next.Dest
+		onPath[n] = true
+		nodes = append(nodes, n)
+		edges = append(edges, next)
+	}
+}
+
+// PathsBetween returns the nodes and edges of the graph that lie on a
+// call path from a node matching from to a node matching to. A nil
+// function matches every node, so that all the paths from or to some
+// nodes can be selected.
+func (g *Graph) PathsBetween(from, to func(*Node) bool) (Nodes, []*Edge) {
+	// Nodes reached from a from node, and reaching a to node.
+	reached := make(map[*Node]bool)
+	reaching := make(map[*Node]bool)
+	var forward, backward func(n *Node)
+	forward = func(n *Node) {
+		if !reached[n] {
+			reached[n] = true
+			for dest := range n.Out {
+				forward(dest)
+			}
+		}
+	}
+	backward = func(n *Node) {
+		if !reaching[n] {
+			reaching[n] = true
+			for src := range n.In {
+				backward(src)
+			}
+		}
+	}
+	for _, n := range g.Nodes {
+		if from == nil || from(n) {
+			forward(n)
+		}
+		if to == nil || to(n) {
+			backward(n)
+		}
+	}
+
+	var nodes Nodes
+	var edges []*Edge
+	for _, n := range g.Nodes {
+		if !reached[n] || !reaching[n] {
+			continue
+		}
+		nodes = append(nodes, n)
+		for _, e := range n.Out.Sort() {
+			if reaching[e.Dest] {
+				edges = append(edges, e)
+			}
+		}
+	}
+	return nodes, edges
+}
+
+// isRoot reports whether a node is a root of the program, reached by
+// samples with no callers in the graph.
+func (n *Node) isRoot() bool {
+	return len(n.In) == 0 || abs64(n.Cum) > abs64(n.In.Sum())
+}
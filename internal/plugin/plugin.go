@@ -96,6 +96,11 @@ type FlagSet interface {
 // time to wait for a profile before returning an error. Returns the
 // fetched profile, the URL of the actual source of the profile, or an
 // error.
+//
+// The -repair option only repairs the profiles returned by a Fetcher,
+// so a Fetcher that parses profiles with profile.ParseData rejects the
+// malformed ones before they can be repaired. Fetchers should parse
+// profiles with profile.ParseDataUnchecked to let them be repaired.
 type Fetcher interface {
 	Fetch(src string, duration, timeout time.Duration) (*profile.Profile, string, error)
 }
@@ -0,0 +1,102 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+
+	"github.com/lemonlinger/pprof/internal/graph"
+)
+
+// ButterflyData holds a node of the call graph of a profile with its
+// callers and callees, and the weights of the calls between them.
+type ButterflyData struct {
+	Total   int64            `json:"total"`
+	Center  ButterflyEntry   `json:"center"`
+	Callers []ButterflyEntry `json:"callers"`
+	Callees []ButterflyEntry `json:"callees"`
+}
+
+// ButterflyEntry holds the values of a node of a butterfly, and the
+// weight of its call from or to the center node. The weight is zero for
+// the center itself.
+type ButterflyEntry struct {
+	Name       string `json:"name"`
+	Flat       int64  `json:"flat"`
+	FlatFormat string `json:"flatFormat"`
+	Cum        int64  `json:"cum"`
+	CumFormat  string `json:"cumFormat"`
+	Edge       int64  `json:"edge"`
+	EdgeFormat string `json:"edgeFormat"`
+	Inline     bool   `json:"inline"`
+}
+
+// GetButterfly returns the node of the call graph of the report with a
+// name, or its node with the largest cum value if name is empty, with
+// its callers and callees ordered by the weight of their calls.
+func GetButterfly(rpt *Report, name string) (*ButterflyData, error) {
+	g := rpt.newGraph(nil)
+	rpt.selectOutputUnit(g)
+
+	var center *graph.Node
+	for _, n := range g.Nodes {
+		if name != "" {
+			if n.Info.PrintableName() == name {
+				center = n
+				break
+			}
+			continue
+		}
+		if center == nil || abs64(n.CumValue()) > abs64(center.CumValue()) ||
+			abs64(n.CumValue()) == abs64(center.CumValue()) && n.Info.PrintableName() < center.Info.PrintableName() {
+			center = n
+		}
+	}
+	if center == nil {
+		if name == "" {
+			return nil, fmt.Errorf("profile has no samples")
+		}
+		return nil, fmt.Errorf("no function named %q", name)
+	}
+
+	entry := func(n *graph.Node, e *graph.Edge) ButterflyEntry {
+		be := ButterflyEntry{
+			Name:       n.Info.PrintableName(),
+			Flat:       n.FlatValue(),
+			FlatFormat: rpt.formatValue(n.FlatValue()),
+			Cum:        n.CumValue(),
+			CumFormat:  rpt.formatValue(n.CumValue()),
+		}
+		if e != nil {
+			be.Edge = e.WeightValue()
+			be.EdgeFormat = rpt.formatValue(e.WeightValue())
+			be.Inline = e.Inline
+		}
+		return be
+	}
+	data := &ButterflyData{
+		Total:   rpt.total,
+		Center:  entry(center, nil),
+		Callers: []ButterflyEntry{},
+		Callees: []ButterflyEntry{},
+	}
+	for _, e := range center.In.Sort() {
+		data.Callers = append(data.Callers, entry(e.Src, e))
+	}
+	for _, e := range center.Out.Sort() {
+		data.Callees = append(data.Callees, entry(e.Dest, e))
+	}
+	return data, nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/lemonlinger/pprof/internal/graph"
+)
+
+// maxCallTreeMatches is the number of search matches after which the
+// nodes of a call tree leading to further matches are not expanded.
+const maxCallTreeMatches = 100
+
+// CallTreeData holds the children of a node of the call tree of a
+// profile, or its roots.
+type CallTreeData struct {
+	Total    int64           `json:"total"`
+	Inverted bool            `json:"inverted"`
+	Nodes    []*CallTreeNode `json:"nodes"`
+}
+
+// CallTreeNode holds the values of a node of a call tree. Its children
+// are only included when it is expanded to show a search match, and
+// ChildCount is their number in any case.
+type CallTreeNode struct {
+	Name        string          `json:"name"`
+	Self        int64           `json:"self"`
+	SelfFormat  string          `json:"selfFormat"`
+	Total       int64           `json:"total"`
+	TotalFormat string          `json:"totalFormat"`
+	Inline      bool            `json:"inline,omitempty"`
+	Match       bool            `json:"match,omitempty"`
+	ChildCount  int             `json:"childCount"`
+	Children    []*CallTreeNode `json:"children,omitempty"`
+}
+
+// CallTree is the call tree of a report, built once to serve the
+// children of its nodes as they are expanded. It is not modified after
+// it is built and can be used concurrently.
+type CallTree struct {
+	rpt      *Report
+	inverted bool
+	roots    graph.Nodes
+}
+
+// NewCallTree builds the call tree of the report. The tree is
+// top-down, with the callees of each function as its children, or
+// bottom-up if inverted, starting from the leaf functions of the
+// samples and with the callers of each function as its children.
+func NewCallTree(rpt *Report, inverted bool) *CallTree {
+	gopt := rpt.graphOptions(nil)
+	gopt.CallTree = true
+	gopt.Inverted = inverted
+	gopt.SkipTags = true
+	g := graph.New(rpt.prof, gopt)
+	rpt.selectOutputUnit(g)
+
+	var roots graph.Nodes
+	for _, n := range g.Nodes {
+		if len(n.In) == 0 {
+			roots = append(roots, n)
+		}
+	}
+	roots.Sort(graph.CumNameOrder)
+	return &CallTree{rpt: rpt, inverted: inverted, roots: roots}
+}
+
+// Children returns the children of a node of the call tree, or its
+// roots if path is empty. The node is found from the roots by
+// following, for each element of path, the child with that index, with
+// the children of each node ordered by their total value. If match is
+// non-nil, the nodes leading to functions matching it are expanded.
+func (ct *CallTree) Children(path []int, match *regexp.Regexp) (*CallTreeData, error) {
+	rpt := ct.rpt
+	nodes := ct.roots
+	var parent *graph.Node
+	for i, p := range path {
+		if p < 0 || p >= len(nodes) {
+			return nil, fmt.Errorf("no call tree node at %v", path[:i+1])
+		}
+		parent = nodes[p]
+		nodes = callTreeChildren(parent)
+	}
+
+	// leadsToMatch records whether any descendant of a node matches.
+	leadsToMatch := make(map[*graph.Node]bool)
+	var hasMatch func(n *graph.Node) bool
+	hasMatch = func(n *graph.Node) bool {
+		found, ok := leadsToMatch[n]
+		if ok {
+			return found
+		}
+		for c := range n.Out {
+			if hasMatch(c) || match.MatchString(c.Info.PrintableName()) {
+				found = true
+			}
+		}
+		leadsToMatch[n] = found
+		return found
+	}
+
+	matches := 0
+	var entries func(parent *graph.Node, nodes graph.Nodes) []*CallTreeNode
+	entries = func(parent *graph.Node, nodes graph.Nodes) []*CallTreeNode {
+		es := make([]*CallTreeNode, 0, len(nodes))
+		for _, n := range nodes {
+			e := &CallTreeNode{
+				Name:        n.Info.PrintableName(),
+				Self:        n.FlatValue(),
+				SelfFormat:  rpt.formatValue(n.FlatValue()),
+				Total:       n.CumValue(),
+				TotalFormat: rpt.formatValue(n.CumValue()),
+				ChildCount:  len(n.Out),
+			}
+			if parent != nil {
+				e.Inline = parent.Out[n].Inline
+			}
+			if match != nil {
+				if match.MatchString(e.Name) {
+					e.Match = true
+					matches++
+				}
+				if matches < maxCallTreeMatches && hasMatch(n) {
+					e.Children = entries(n, callTreeChildren(n))
+				}
+			}
+			es = append(es, e)
+		}
+		return es
+	}
+
+	return &CallTreeData{
+		Total:    rpt.total,
+		Inverted: ct.inverted,
+		Nodes:    entries(parent, nodes),
+	}, nil
+}
+
+// callTreeChildren returns the children of a node of a call tree,
+// ordered by their total value.
+func callTreeChildren(n *graph.Node) graph.Nodes {
+	children := make(graph.Nodes, 0, len(n.Out))
+	for c := range n.Out {
+		children = append(children, c)
+	}
+	children.Sort(graph.CumNameOrder)
+	return children
+}
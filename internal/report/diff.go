@@ -0,0 +1,295 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lemonlinger/pprof/profile"
+)
+
+// defaultDiffEntries is the number of regressions and improvements
+// listed by the diff report when no node count is set.
+const defaultDiffEntries = 20
+
+// DiffSourceLabel is the label that identifies the profile a sample
+// comes from when several base and source profiles are merged with
+// -diff_base or -base, so that the diff report can test the
+// significance of the differences between them. Its values are built
+// by DiffSourceValue.
+const DiffSourceLabel = "pprof::source"
+
+// DiffDurationLabel is the numeric label holding the duration of the
+// profile a sample comes from when profiles are merged for the diff
+// report, so that it can scale them by their durations.
+const DiffDurationLabel = "pprof::duration"
+
+// diffBasePrefix starts the values of DiffSourceLabel of base profiles,
+// which cannot otherwise be told apart from the others with -base.
+const diffBasePrefix = "base "
+
+// DiffSourceValue returns the value of DiffSourceLabel for the samples
+// of the profile with the given index among the base profiles, if base,
+// or among the source profiles.
+func DiffSourceValue(base bool, index int) string {
+	if base {
+		return diffBasePrefix + strconv.Itoa(index)
+	}
+	return strconv.Itoa(index)
+}
+
+// DiffSource identifies one of the profiles merged for the diff report.
+type DiffSource struct {
+	Base          bool   // Whether it is a base profile.
+	Label         string // Its value of DiffSourceLabel, if any.
+	DurationNanos int64  // Its duration, as given by DiffDurationLabel.
+}
+
+// DiffSources returns the profiles that the samples of p come from, in
+// the order of their first samples. The diff report of a filtered
+// profile should be given those of the unfiltered one in
+// Options.DiffSources, so that the profiles left without samples are
+// still compared.
+func DiffSources(p *profile.Profile) []DiffSource {
+	var sources []DiffSource
+	seen := make(map[DiffSource]bool)
+	for _, s := range p.Sample {
+		src := diffSource(s)
+		if k := src.key(); !seen[k] {
+			seen[k] = true
+			sources = append(sources, src)
+		}
+	}
+	return sources
+}
+
+// RemoveDiffSources removes the labels identifying the profiles
+// compared by the diff report, which the other reports do not use,
+// from the samples of p. Samples only kept apart by those labels are
+// merged, in a new profile if there are any.
+func RemoveDiffSources(p *profile.Profile) (*profile.Profile, error) {
+	var labeled bool
+	for _, s := range p.Sample {
+		if _, ok := s.Label[DiffSourceLabel]; ok {
+			labeled = true
+			delete(s.Label, DiffSourceLabel)
+		}
+		if _, ok := s.NumLabel[DiffDurationLabel]; ok {
+			labeled = true
+			delete(s.NumLabel, DiffDurationLabel)
+			delete(s.NumUnit, DiffDurationLabel)
+		}
+	}
+	if !labeled {
+		return p, nil
+	}
+	return profile.Merge([]*profile.Profile{p})
+}
+
+// diffSource returns the profile that a sample comes from.
+func diffSource(s *profile.Sample) DiffSource {
+	var src DiffSource
+	if l := s.Label[DiffSourceLabel]; len(l) > 0 {
+		src.Label = l[0]
+	}
+	src.Base = s.DiffBaseSample() || strings.HasPrefix(src.Label, diffBasePrefix)
+	if d := s.NumLabel[DiffDurationLabel]; len(d) > 0 {
+		src.DurationNanos = d[0]
+	}
+	return src
+}
+
+// key identifies a source regardless of its duration.
+func (src DiffSource) key() DiffSource {
+	return DiffSource{Base: src.Base, Label: src.Label}
+}
+
+// diffConfidence is the confidence level of the significance tests of
+// the diff report.
+const diffConfidence = 0.95
+
+// printDiff prints the functions with the largest differences between
+// the samples of the base profiles, which have negated values, and the
+// other samples. If the samples come from several base and source
+// profiles, only the differences that are statistically significant
+// are listed.
+func printDiff(w io.Writer, rpt *Report) error {
+	bases, targets := splitDiffSources(rpt.prof, rpt.options.DiffSources)
+	if len(bases) == 0 {
+		return fmt.Errorf("diff report requires a base profile, specified with -diff_base, or several with -base")
+	}
+	o := rpt.options
+	d, err := profile.DiffSources(bases, targets, profile.DiffOptions{
+		SampleType: o.SampleType,
+		Normalize:  o.DiffNormalize,
+	})
+	if err != nil {
+		return err
+	}
+	significance := len(bases) > 1 && len(targets) > 1
+
+	value := func(fd *profile.FunctionDiff) profile.DiffValue { return fd.Flat }
+	if o.CumSort {
+		value = func(fd *profile.FunctionDiff) profile.DiffValue { return fd.Cum }
+	}
+	type entry struct {
+		fd                *profile.FunctionDiff
+		pValue, low, high float64
+	}
+	var regressions, improvements []entry
+	for _, fd := range d.Functions {
+		e := entry{fd: fd}
+		if significance {
+			if e.pValue, e.low, e.high, err = value(fd).Significance(diffConfidence); err != nil {
+				return err
+			}
+			if e.pValue >= 1-diffConfidence {
+				continue
+			}
+		}
+		switch delta := value(fd).Delta(); {
+		case delta > 0:
+			regressions = append(regressions, e)
+		case delta < 0:
+			improvements = append(improvements, e)
+		}
+	}
+	for _, es := range [][]entry{regressions, improvements} {
+		sort.SliceStable(es, func(i, j int) bool {
+			return math.Abs(value(es[i].fd).Delta()) > math.Abs(value(es[j].fd).Delta())
+		})
+	}
+
+	format := func(v float64) string {
+		return rpt.formatValue(int64(math.Round(v)))
+	}
+	fmt.Fprintln(w, strings.Join(ProfileLabels(rpt), "\n"))
+	if significance {
+		fmt.Fprintf(w, "Base profiles: %d, target profiles: %d, showing changes significant at p < %.2f\n",
+			len(bases), len(targets), 1-diffConfidence)
+	}
+	fmt.Fprintf(w, "Base total: %s, target total: %s, delta: %s (%s)\n",
+		format(d.Total.Base), format(d.Total.Target), formatDelta(d.Total.Delta(), format), formatRelative(d.Total))
+
+	n := o.NodeCount
+	if n <= 0 {
+		n = defaultDiffEntries
+	}
+	kind := "flat"
+	if o.CumSort {
+		kind = "cum"
+	}
+	for _, section := range []struct {
+		title   string
+		entries []entry
+	}{
+		{"Regressions", regressions},
+		{"Improvements", improvements},
+	} {
+		fmt.Fprintf(w, "\n%s (%s):\n", section.title, kind)
+		if len(section.entries) == 0 {
+			fmt.Fprintln(w, "  none")
+			continue
+		}
+		if significance {
+			fmt.Fprintf(w, "%10s %10s %10s %8s %8s  %-23s  %s\n", "base", "target", "delta", "delta%", "p-value", "95% confidence interval", "function")
+		} else {
+			fmt.Fprintf(w, "%10s %10s %10s %8s  %s\n", "base", "target", "delta", "delta%", "function")
+		}
+		entries := section.entries
+		if len(entries) > n {
+			entries = entries[:n]
+		}
+		for _, e := range entries {
+			v := value(e.fd)
+			if significance {
+				ci := fmt.Sprintf("[%s, %s]", formatDelta(e.low, format), formatDelta(e.high, format))
+				fmt.Fprintf(w, "%10s %10s %10s %8s %8.3f  %-23s  %s\n",
+					format(v.Base), format(v.Target), formatDelta(v.Delta(), format), formatRelative(v), e.pValue, ci, e.fd.Name)
+				continue
+			}
+			fmt.Fprintf(w, "%10s %10s %10s %8s  %s\n",
+				format(v.Base), format(v.Target), formatDelta(v.Delta(), format), formatRelative(v), e.fd.Name)
+		}
+	}
+	return nil
+}
+
+// splitDiffSources separates the samples of the base profiles merged
+// into p from the others, restoring their original values, and splits
+// them further by the profile they come from. The profiles of sources
+// are created first, and keep their durations even if filtering left
+// them without samples.
+func splitDiffSources(p *profile.Profile, sources []DiffSource) (bases, targets []*profile.Profile) {
+	profiles := make(map[DiffSource]*profile.Profile)
+	add := func(src DiffSource) *profile.Profile {
+		k := src.key()
+		if dp := profiles[k]; dp != nil {
+			return dp
+		}
+		dp := &profile.Profile{
+			SampleType:        p.SampleType,
+			DefaultSampleType: p.DefaultSampleType,
+			TimeNanos:         p.TimeNanos,
+			DurationNanos:     src.DurationNanos,
+		}
+		profiles[k] = dp
+		if src.Base {
+			bases = append(bases, dp)
+		} else {
+			targets = append(targets, dp)
+		}
+		return dp
+	}
+	for _, src := range sources {
+		add(src)
+	}
+	for _, s := range p.Sample {
+		src := diffSource(s)
+		dp := add(src)
+		if src.Base {
+			bs := *s
+			bs.Value = make([]int64, len(s.Value))
+			for i, v := range s.Value {
+				bs.Value[i] = -v
+			}
+			s = &bs
+		}
+		dp.Sample = append(dp.Sample, s)
+	}
+	return bases, targets
+}
+
+func formatDelta(delta float64, format func(float64) string) string {
+	if delta > 0 {
+		return "+" + format(delta)
+	}
+	return format(delta)
+}
+
+func formatRelative(v profile.DiffValue) string {
+	switch {
+	case v.New():
+		return "new"
+	case v.Gone():
+		return "gone"
+	}
+	return fmt.Sprintf("%+.1f%%", 100*v.Relative())
+}
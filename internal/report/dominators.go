@@ -0,0 +1,103 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lemonlinger/pprof/internal/measurement"
+)
+
+// DominatorItem holds a single entry of a dominators report.
+type DominatorItem struct {
+	Name string
+	// Dominator is the name of the immediate dominator of the node, or
+	// empty if it is only dominated by the roots of the program.
+	Dominator       string
+	Flat, Dominated int64
+	FlatFormat      string
+	DominatedFormat string
+}
+
+// DominatorItems returns the nodes of the call graph with the value
+// they dominate, heaviest first, and the labels describing them. The
+// dominator tree is computed on the complete graph, since dropping
+// nodes changes the call paths, and only the first NodeCount entries
+// are returned.
+func DominatorItems(rpt *Report) ([]DominatorItem, []string) {
+	g := rpt.newGraph(nil)
+	rpt.selectOutputUnit(g)
+	doms := g.Dominators()
+
+	nodes := g.Nodes
+	sort.SliceStable(nodes, func(i, j int) bool {
+		di, dj := abs64(doms.Dominated[nodes[i]]), abs64(doms.Dominated[nodes[j]])
+		if di != dj {
+			return di > dj
+		}
+		return nodes[i].Info.PrintableName() < nodes[j].Info.PrintableName()
+	})
+	labels := reportLabels(rpt, g, len(nodes), 0, 0, false)
+	if n := rpt.options.NodeCount; n > 0 && n < len(nodes) {
+		labels = append(labels, fmt.Sprintf("Showing top %d nodes out of %d", n, len(nodes)))
+		nodes = nodes[:n]
+	}
+
+	var items []DominatorItem
+	for _, n := range nodes {
+		var dominator string
+		if d := doms.Idom[n]; d != nil {
+			dominator = d.Info.PrintableName()
+		}
+		flat, dominated := n.FlatValue(), doms.Dominated[n]
+		items = append(items, DominatorItem{
+			Name:            n.Info.PrintableName(),
+			Dominator:       dominator,
+			Flat:            flat,
+			Dominated:       dominated,
+			FlatFormat:      rpt.formatValue(flat),
+			DominatedFormat: rpt.formatValue(dominated),
+		})
+	}
+	return items, labels
+}
+
+// printDominators prints the nodes of the call graph with their
+// immediate dominator and the value they dominate, which is the value
+// of the samples that would go away without them.
+func printDominators(w io.Writer, rpt *Report) error {
+	items, labels := DominatorItems(rpt)
+	fmt.Fprintln(w, strings.Join(labels, "\n"))
+	// Align the columns on their widest values, since percentages of
+	// tiny values are printed in exponent notation.
+	tabw := tabwriter.NewWriter(w, 0, 0, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tabw, "%10s\t%6s\t%10s\t%6s\t %s\n",
+		"dominated", "dom%", "flat", "flat%", "name (immediate dominator)")
+	for _, item := range items {
+		dominator := item.Dominator
+		if dominator == "" {
+			dominator = "root"
+		}
+		fmt.Fprintf(tabw, "%10s\t%s\t%10s\t%s\t %s (%s)\n",
+			item.DominatedFormat, measurement.Percentage(item.Dominated, rpt.total),
+			item.FlatFormat, measurement.Percentage(item.Flat, rpt.total),
+			item.Name, dominator)
+	}
+	return tabw.Flush()
+}
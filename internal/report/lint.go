@@ -0,0 +1,64 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/lemonlinger/pprof/internal/measurement"
+)
+
+// printLint prints the quality problems of the profile found by
+// profile.Lint, with the weight of the samples they affect. If
+// LintFail is set, it returns an error when there are problems, so
+// that pprof exits with a non-zero status.
+func printLint(w io.Writer, rpt *Report) error {
+	prof, o := rpt.prof, rpt.options
+	abs := func(v int64) int64 {
+		if v < 0 {
+			return -v
+		}
+		return v
+	}
+	var total int64
+	for _, s := range prof.Sample {
+		total += abs(o.SampleValue(s.Value))
+	}
+
+	fmt.Fprintln(w, strings.Join(ProfileLabels(rpt), "\n"))
+	issues := prof.Lint()
+	if len(issues) == 0 {
+		fmt.Fprintln(w, "No problems found")
+		return nil
+	}
+	fmt.Fprintf(w, "%d problems found:\n", len(issues))
+	for _, issue := range issues {
+		fmt.Fprintf(w, "  %-15s %s", issue.Category+":", issue.Message)
+		if len(issue.Samples) > 0 {
+			var weight int64
+			for _, s := range issue.Samples {
+				weight += abs(o.SampleValue(s.Value))
+			}
+			fmt.Fprintf(w, " (%s, %s of %s)", rpt.formatValue(weight), measurement.Percentage(weight, total), o.SampleType)
+		}
+		fmt.Fprintln(w)
+	}
+	if o.LintFail {
+		return fmt.Errorf("vet found %d problems", len(issues))
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/lemonlinger/pprof/internal/measurement"
+)
+
+// OwnerLabel is the label holding the owners of a sample, as attributed
+// by the -owners option.
+const OwnerLabel = "owner"
+
+// unownedName is the name under which the owners report lists the
+// samples without owners.
+const unownedName = "(unowned)"
+
+// printOwners prints the value of the samples of each owner, from the
+// OwnerLabel label of the samples. Samples with several owners count
+// for each of them, and a note then tells that the percentages overlap.
+func printOwners(w io.Writer, rpt *Report) error {
+	prof, o := rpt.prof, rpt.options
+	values := make(map[string]int64)
+	var shared int64
+	for _, s := range prof.Sample {
+		v := o.SampleValue(s.Value)
+		owners := s.Label[OwnerLabel]
+		if len(owners) == 0 {
+			owners = []string{unownedName}
+		}
+		if len(owners) > 1 {
+			shared += v
+		}
+		for _, owner := range owners {
+			values[owner] += v
+		}
+	}
+	owners := make([]string, 0, len(values))
+	for owner := range values {
+		owners = append(owners, owner)
+	}
+	sort.Slice(owners, func(i, j int) bool {
+		vi, vj := abs64(values[owners[i]]), abs64(values[owners[j]])
+		if vi != vj {
+			return vi > vj
+		}
+		return owners[i] < owners[j]
+	})
+
+	fmt.Fprintln(w, strings.Join(ProfileLabels(rpt), "\n"))
+	if len(rpt.options.ActiveFilters) > 0 {
+		fmt.Fprintln(w, strings.Join(legendActiveFilters(rpt.options.ActiveFilters), "\n"))
+	}
+	fmt.Fprintf(w, "%10s %6s  %s\n", "flat", "flat%", "owner")
+	for _, owner := range owners {
+		v := values[owner]
+		fmt.Fprintf(w, "%10s %s  %s\n", rpt.formatValue(v), measurement.Percentage(v, rpt.total), owner)
+	}
+	if shared != 0 {
+		fmt.Fprintf(w, "Samples with several owners (%s, %s) count for each of them, so percentages overlap\n",
+			rpt.formatValue(shared), measurement.Percentage(shared, rpt.total))
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/lemonlinger/pprof/internal/graph"
+	"github.com/lemonlinger/pprof/internal/measurement"
+)
+
+// printPaths prints the call paths of the samples from a frame matching
+// the PathFrom option to a frame matching the PathTo option, with the
+// value of the samples following each of them, heaviest first.
+func printPaths(w io.Writer, rpt *Report) error {
+	prof, o := rpt.prof, rpt.options
+	if o.PathFrom == nil || o.PathTo == nil {
+		return errors.New("paths report needs the regexps of both ends of the paths")
+	}
+
+	type path struct {
+		names []string
+		value int64
+	}
+	byKey := make(map[string]*path)
+	var paths []*path
+	var sum int64
+	_, locations := graph.CreateNodes(prof, &graph.Options{})
+	for _, s := range prof.Sample {
+		var stack graph.Nodes
+		for _, loc := range s.Location {
+			stack = append(stack, locations[loc.ID]...)
+		}
+		names := stackPath(stack, o.PathFrom, o.PathTo)
+		if names == nil {
+			continue
+		}
+		key := strings.Join(names, "\n")
+		p := byKey[key]
+		if p == nil {
+			p = &path{names: names}
+			byKey[key] = p
+			paths = append(paths, p)
+		}
+		v := o.SampleValue(s.Value)
+		p.value += v
+		sum += v
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		vi, vj := abs64(paths[i].value), abs64(paths[j].value)
+		if vi != vj {
+			return vi > vj
+		}
+		return strings.Join(paths[i].names, "\n") < strings.Join(paths[j].names, "\n")
+	})
+
+	fmt.Fprintln(w, strings.Join(ProfileLabels(rpt), "\n"))
+	if len(o.ActiveFilters) > 0 {
+		fmt.Fprintln(w, strings.Join(legendActiveFilters(o.ActiveFilters), "\n"))
+	}
+	if len(paths) == 0 {
+		fmt.Fprintf(w, "No paths from %s to %s\n", o.PathFrom, o.PathTo)
+		return nil
+	}
+	fmt.Fprintf(w, "%d paths from %s to %s, %s (%s) of %s total\n", len(paths), o.PathFrom, o.PathTo,
+		rpt.formatValue(sum), strings.TrimSpace(measurement.Percentage(sum, rpt.total)), rpt.formatValue(rpt.total))
+	if n := o.NodeCount; n > 0 && n < len(paths) {
+		fmt.Fprintf(w, "Showing top %d paths out of %d\n", n, len(paths))
+		paths = paths[:n]
+	}
+
+	const separator = "-----------+-------+-----------------------------------------------"
+	for _, p := range paths {
+		fmt.Fprintln(w, separator)
+		fmt.Fprintf(w, "%10s %s  %s\n", rpt.formatValue(p.value), measurement.Percentage(p.value, rpt.total), p.names[0])
+		for _, name := range p.names[1:] {
+			fmt.Fprintf(w, "%10s %6s  %s\n", "", "", name)
+		}
+	}
+	fmt.Fprintln(w, separator)
+	return nil
+}
+
+// stackPath returns the names of the frames of a stack, given leaf
+// first, on the call path from the frame matching to that is closest to
+// the leaf up to its closest caller matching from. The names are
+// ordered from the caller, and are nil if the stack has no such path.
+func stackPath(stack graph.Nodes, from, to *regexp.Regexp) []string {
+	for j, n := range stack {
+		if !to.MatchString(n.Info.Name) {
+			continue
+		}
+		for i := j; i < len(stack); i++ {
+			if from.MatchString(stack[i].Info.Name) {
+				names := make([]string, 0, i-j+1)
+				for k := i; k >= j; k-- {
+					names = append(names, stack[k].Info.PrintableName())
+				}
+				return names
+			}
+		}
+		// Frames matching to further from the leaf only have callers
+		// that were already checked.
+		return nil
+	}
+	return nil
+}
+
+// printHotPath prints the path of the call graph following the heaviest
+// edges from its heaviest root, with the weight of the edge to each node
+// and the cum value of the node.
+func printHotPath(w io.Writer, rpt *Report) error {
+	g := rpt.newGraph(nil)
+	rpt.selectOutputUnit(g)
+	nodes, edges := g.HotPath()
+
+	fmt.Fprintln(w, strings.Join(reportLabels(rpt, g, len(g.Nodes), 0, 0, false), "\n"))
+	fmt.Fprintf(w, "%10s %5s%% %10s %5s%%  %s\n", "edge", "edge", "cum", "cum", "name")
+	for i, n := range nodes {
+		edge, edgePercent := "", ""
+		if i > 0 {
+			weight := edges[i-1].WeightValue()
+			edge, edgePercent = rpt.formatValue(weight), measurement.Percentage(weight, rpt.total)
+		}
+		fmt.Fprintf(w, "%10s %6s %10s %s  %s\n", edge, edgePercent,
+			rpt.formatValue(n.CumValue()), measurement.Percentage(n.CumValue(), rpt.total),
+			n.Info.PrintableName())
+	}
+	return nil
+}
@@ -37,13 +37,23 @@ import (
 const (
 	Callgrind = iota
 	Comments
+	Diff
 	Dis
+	Dominators
 	Dot
+	GEXF
+	GraphML
+	HotPath
+	Lint
 	List
+	Mermaid
+	Owners
+	Paths
 	Proto
 	Raw
 	Tags
 	Text
+	Timeline
 	TopProto
 	Traces
 	Tree
@@ -57,6 +67,7 @@ type Options struct {
 
 	CumSort       bool
 	CallTree      bool
+	FoldRecursion bool
 	DropNegative  bool
 	CompactLabels bool
 	Ratio         float64
@@ -77,8 +88,20 @@ type Options struct {
 	OutputUnit string // Units for data formatting in report.
 
 	Symbol     *regexp.Regexp // Symbols to include on disassembly report.
+	PathFrom   *regexp.Regexp // Callers starting the paths report.
+	PathTo     *regexp.Regexp // Callees ending the paths report.
 	SourcePath string         // Search path for source files.
 	TrimPath   string         // Paths to trim from source file paths.
+
+	Cluster   string // Grouping of the nodes of graphs: "package", "file" or "object".
+	RawFormat string // Encoding of the raw report: "text" (default) or "json".
+
+	MaxSize int // Maximum size in bytes of the proto report, if positive.
+
+	LintFail bool // Whether the lint report fails if there are problems.
+
+	DiffNormalize profile.DiffNormalization // Scaling of the base profiles of the diff report.
+	DiffSources   []DiffSource              // Profiles compared by the diff report, before filtering.
 }
 
 // Generate generates a report as directed by the Report.
@@ -88,20 +111,49 @@ func Generate(w io.Writer, rpt *Report, obj plugin.ObjTool) error {
 	switch o.OutputFormat {
 	case Comments:
 		return printComments(w, rpt)
+	case Diff:
+		return printDiff(w, rpt)
+	case Dominators:
+		return printDominators(w, rpt)
 	case Dot:
 		return printDOT(w, rpt)
+	case GEXF:
+		g, c := GetDOT(rpt)
+		return graph.ComposeGEXF(w, g, c)
+	case GraphML:
+		g, c := GetDOT(rpt)
+		return graph.ComposeGraphML(w, g, c)
+	case Mermaid:
+		g, c := GetDOT(rpt)
+		return graph.ComposeMermaid(w, g, c)
+	case HotPath:
+		return printHotPath(w, rpt)
+	case Paths:
+		return printPaths(w, rpt)
 	case Tree:
 		return printTree(w, rpt)
 	case Text:
 		return printText(w, rpt)
+	case Timeline:
+		return printTimeline(w, rpt)
 	case Traces:
 		return printTraces(w, rpt)
 	case Raw:
-		fmt.Fprint(w, rpt.prof.String())
-		return nil
+		return printRaw(w, rpt)
 	case Tags:
 		return printTags(w, rpt)
+	case Lint:
+		return printLint(w, rpt)
+	case Owners:
+		return printOwners(w, rpt)
 	case Proto:
+		if o.MaxSize > 0 {
+			p, err := rpt.prof.TrimToSize(o.MaxSize)
+			if err != nil {
+				return err
+			}
+			return p.Write(w)
+		}
 		return rpt.prof.Write(w)
 	case TopProto:
 		return printTopProto(w, rpt)
@@ -234,6 +286,12 @@ func (rpt *Report) selectOutputUnit(g *graph.Graph) {
 // only nodes whose info matches are included. Otherwise, all nodes
 // are included, without trimming.
 func (rpt *Report) newGraph(nodes graph.NodeSet) *graph.Graph {
+	return graph.New(rpt.prof, rpt.graphOptions(nodes))
+}
+
+// graphOptions cleans up the profile of the report to build a graph
+// from it, and returns the options for the graph.
+func (rpt *Report) graphOptions(nodes graph.NodeSet) *graph.Options {
 	o := rpt.options
 
 	// Clean up file paths using heuristics.
@@ -278,7 +336,11 @@ func (rpt *Report) newGraph(nodes graph.NodeSet) *graph.Graph {
 		FormatTag:         formatTag,
 		CallTree:          o.CallTree && (o.OutputFormat == Dot || o.OutputFormat == Callgrind),
 		DropNegative:      o.DropNegative,
+		FoldRecursion:     o.FoldRecursion,
 		KeptNodes:         nodes,
+
+		// Only graphs show the tags of the nodes.
+		SkipTags: o.OutputFormat != Dot,
 	}
 
 	// Only keep binary names for disassembly-based reports, otherwise
@@ -287,8 +349,10 @@ func (rpt *Report) newGraph(nodes graph.NodeSet) *graph.Graph {
 	case Raw, List, WebList, Dis, Callgrind:
 		gopt.ObjNames = true
 	}
-
-	return graph.New(rpt.prof, gopt)
+	if o.Cluster == "object" {
+		gopt.ObjNames = true
+	}
+	return gopt
 }
 
 func printTopProto(w io.Writer, rpt *Report) error {
@@ -723,6 +787,19 @@ func printTags(w io.Writer, rpt *Report) error {
 	return tabw.Flush()
 }
 
+// printRaw prints the full profile, either as the text dump produced
+// by profile.String or as JSON.
+func printRaw(w io.Writer, rpt *Report) error {
+	switch rpt.options.RawFormat {
+	case "", "text":
+		fmt.Fprint(w, rpt.prof.String())
+		return nil
+	case "json":
+		return rpt.prof.WriteJSON(w)
+	}
+	return fmt.Errorf("unknown raw format %q", rpt.options.RawFormat)
+}
+
 // printComments prints all freeform comments in the profile.
 func printComments(w io.Writer, rpt *Report) error {
 	p := rpt.prof
@@ -1069,8 +1146,8 @@ func printTree(w io.Writer, rpt *Report) error {
 	return nil
 }
 
-// GetDOT returns a graph suitable for dot processing along with some
-// configuration information.
+// GetDOT returns a graph suitable for dot processing, or for export in
+// other graph formats, along with some configuration information.
 func GetDOT(rpt *Report) (*graph.Graph, *graph.DotConfig) {
 	g, origCount, droppedNodes, droppedEdges := rpt.newTrimmedGraph()
 	rpt.selectOutputUnit(g)
@@ -1079,12 +1156,72 @@ func GetDOT(rpt *Report) (*graph.Graph, *graph.DotConfig) {
 	c := &graph.DotConfig{
 		Title:       rpt.options.Title,
 		Labels:      labels,
+		Unit:        rpt.options.SampleUnit,
+		Cluster:     nodeCluster(rpt.options.Cluster),
 		FormatValue: rpt.formatValue,
 		Total:       rpt.total,
 	}
+	if c.Cluster != nil {
+		c.ClusterTotals = rpt.clusterTotals(c.Cluster)
+	}
 	return g, c
 }
 
+// nodeCluster returns the function naming the cluster of the nodes of a
+// graph for a cluster option, or nil if the nodes are not clustered.
+func nodeCluster(cluster string) func(*graph.Node) string {
+	switch cluster {
+	case "package":
+		return func(n *graph.Node) string { return graph.PackageName(n.Info.Name) }
+	case "file":
+		return func(n *graph.Node) string { return n.Info.File }
+	case "object":
+		return func(n *graph.Node) string { return n.Info.Objfile }
+	}
+	return nil
+}
+
+// clusterTotals returns nodes holding the values of the samples of each
+// cluster named by cluster, including the nodes trimmed from the graph.
+// A sample counts in the flat value of the cluster of its leaf frame, and
+// once in the cum value of each cluster of its frames.
+func (rpt *Report) clusterTotals(cluster func(*graph.Node) string) map[string]*graph.Node {
+	o := rpt.options
+	_, locations := graph.CreateNodes(rpt.prof, &graph.Options{ObjNames: o.Cluster == "object"})
+	totals := make(map[string]*graph.Node)
+	for _, s := range rpt.prof.Sample {
+		var w, dw int64
+		w = o.SampleValue(s.Value)
+		if o.SampleMeanDivisor != nil {
+			dw = o.SampleMeanDivisor(s.Value)
+		}
+		seen := make(map[string]bool)
+		for i, loc := range s.Location {
+			for j, n := range locations[loc.ID] {
+				name := cluster(n)
+				if name == "" {
+					continue
+				}
+				t := totals[name]
+				if t == nil {
+					t = &graph.Node{Info: graph.NodeInfo{Name: name}}
+					totals[name] = t
+				}
+				if i == 0 && j == 0 {
+					t.Flat += w
+					t.FlatDiv += dw
+				}
+				if !seen[name] {
+					seen[name] = true
+					t.Cum += w
+					t.CumDiv += dw
+				}
+			}
+		}
+	}
+	return totals
+}
+
 // printDOT prints an annotated callgraph in DOT format.
 func printDOT(w io.Writer, rpt *Report) error {
 	g, c := GetDOT(rpt)
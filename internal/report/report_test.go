@@ -16,9 +16,13 @@ package report
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
+	"reflect"
 	"regexp"
 	"runtime"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/lemonlinger/pprof/internal/binutils"
@@ -412,3 +416,606 @@ func TestComputeTotal(t *testing.T) {
 		})
 	}
 }
+
+func TestRawJSON(t *testing.T) {
+	p := testProfile.Copy()
+	rpt := New(p, &Options{
+		OutputFormat: Raw,
+		RawFormat:    "json",
+		SampleValue:  func(v []int64) int64 { return v[1] },
+	})
+	var buf bytes.Buffer
+	if err := Generate(&buf, rpt, nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	got, err := profile.ParseData(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ParseData: %v\n%s", err, buf.String())
+	}
+	if got, want := got.String(), p.String(); got != want {
+		t.Errorf("raw json report parsed back as\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	base := map[string][]string{"pprof::base": {"true"}}
+	p := testProfile.Copy()
+	p.Sample = []*profile.Sample{
+		{Location: []*profile.Location{testL[1], testL[0]}, Value: []int64{-1, -100}, Label: base},
+		{Location: []*profile.Location{testL[2], testL[0]}, Value: []int64{-1, -50}, Label: base},
+		{Location: []*profile.Location{testL[1], testL[0]}, Value: []int64{1, 300}},
+		{Location: []*profile.Location{testL[3], testL[0]}, Value: []int64{1, 40}},
+	}
+	rpt := New(p, &Options{
+		OutputFormat: Diff,
+		SampleValue:  func(v []int64) int64 { return v[1] },
+		SampleType:   "cpu",
+		SampleUnit:   "cycles",
+		OutputUnit:   "cycles",
+	})
+	var buf bytes.Buffer
+	if err := Generate(&buf, rpt, nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		"Base total: 150, target total: 340, delta: +190 (+126.7%)",
+		"Regressions (flat):",
+		"       100        300       +200  +200.0%  foo\n",
+		"         0         40        +40      new  tee\n",
+		"Improvements (flat):",
+		"        50          0        -50     gone  bar\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("diff report does not contain %q:\n%s", want, got)
+		}
+	}
+
+	rpt.prof = testProfile.Copy()
+	if err := Generate(&buf, rpt, nil); err == nil {
+		t.Error("diff report without base profile: got no error")
+	}
+}
+
+func TestLint(t *testing.T) {
+	p := testProfile.Copy()
+	p.Sample = append(p.Sample, &profile.Sample{
+		Location: []*profile.Location{testL[0]},
+		Value:    []int64{-1, -100},
+		Label:    map[string][]string{"pprof::base": {"true"}},
+	})
+	rpt := New(p, &Options{
+		OutputFormat: Lint,
+		SampleValue:  func(v []int64) int64 { return v[1] },
+		SampleType:   "cpu",
+	})
+	var buf bytes.Buffer
+	if err := Generate(&buf, rpt, nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	want := "labels:         1 samples are labeled pprof::base, left over from a -diff_base comparison ("
+	if got := buf.String(); !strings.Contains(got, want) {
+		t.Errorf("vet report does not contain %q:\n%s", want, got)
+	}
+
+	rpt.options.LintFail = true
+	if err := Generate(&buf, rpt, nil); err == nil {
+		t.Error("vet report with problems and LintFail: got no error")
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	p := testProfile.Copy()
+	p.TimeNanos = 1000
+	p.Sample = []*profile.Sample{
+		{
+			Location: []*profile.Location{testL[0]},
+			Value:    []int64{1, 30},
+			NumLabel: map[string][]int64{profile.TimestampLabel: {1000, 1010, 1099}},
+		},
+		{
+			Location: []*profile.Location{testL[1]},
+			Value:    []int64{1, 5},
+			NumLabel: map[string][]int64{profile.TimestampLabel: {1050}},
+		},
+		{
+			Location: []*profile.Location{testL[2]},
+			Value:    []int64{1, 100},
+		},
+	}
+	rpt := New(p, &Options{
+		OutputFormat: Timeline,
+		SampleValue:  func(v []int64) int64 { return v[1] },
+		SampleType:   "cpu",
+		NodeCount:    4,
+	})
+	tl, err := GetTimeline(rpt)
+	if err != nil {
+		t.Fatalf("GetTimeline: %v", err)
+	}
+	var got []int64
+	for _, b := range tl.Buckets {
+		got = append(got, b.Value)
+	}
+	if want := []int64{20, 0, 5, 10}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got bucket values %v, want %v", got, want)
+	}
+	if tl.Total != 35 || tl.Base != 1000 || tl.Buckets[0].Start != 1000 || tl.Buckets[3].End != 1100 {
+		t.Errorf("got total %d, base %d, range [%d, %d), want 35, 1000, [1000, 1100)", tl.Total, tl.Base, tl.Buckets[0].Start, tl.Buckets[3].End)
+	}
+
+	var buf bytes.Buffer
+	if err := Generate(&buf, rpt, nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	want := "        50ns          5  14.29% |" + strings.Repeat("#", 12) + "\n"
+	if got := buf.String(); !strings.Contains(got, want) {
+		t.Errorf("timeline report does not contain %q:\n%s", want, got)
+	}
+
+	p.Sample = p.Sample[2:]
+	if _, err := GetTimeline(New(p, rpt.options)); err == nil {
+		t.Error("GetTimeline of a profile without timestamps: got no error")
+	}
+}
+
+func TestOwners(t *testing.T) {
+	p := testProfile.Copy()
+	p.Sample = []*profile.Sample{
+		{Location: []*profile.Location{testL[0]}, Value: []int64{1, 30}, Label: map[string][]string{OwnerLabel: {"@a"}}},
+		{Location: []*profile.Location{testL[1]}, Value: []int64{1, 50}, Label: map[string][]string{OwnerLabel: {"@a", "@b"}}},
+		{Location: []*profile.Location{testL[2]}, Value: []int64{1, 20}},
+	}
+	rpt := New(p, &Options{
+		OutputFormat: Owners,
+		SampleValue:  func(v []int64) int64 { return v[1] },
+		SampleType:   "cpu",
+		SampleUnit:   "nanoseconds",
+		OutputUnit:   "minimum",
+	})
+	var buf bytes.Buffer
+	if err := Generate(&buf, rpt, nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	var got []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if f := strings.Fields(line); len(f) == 3 && f[0] != "flat" {
+			got = append(got, strings.Join(f, " "))
+		}
+	}
+	want := []string{"80ns 80.00% @a", "50ns 50.00% @b", "20ns 20.00% (unowned)"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got owners %q, want %q:\n%s", got, want, buf.String())
+	}
+	// The percentages add up to 150%, the value shared by @a and @b is
+	// counted twice.
+	if note := "Samples with several owners (50ns, 50.00%) count for each of them"; !strings.Contains(buf.String(), note) {
+		t.Errorf("owners report does not contain %q:\n%s", note, buf.String())
+	}
+}
+
+func TestGetButterfly(t *testing.T) {
+	rpt := New(testProfile.Copy(), &Options{
+		OutputFormat: Tree,
+		SampleValue:  func(v []int64) int64 { return v[1] },
+	})
+	for _, tc := range []struct {
+		name             string
+		center           string
+		callers, callees []string
+	}{
+		{
+			name:    "",
+			center:  "main testdata/source1:2 1 11111",
+			callees: []string{"tee /some/path/testdata/source2:2 11000", "bar testdata/source1:10 100", "foo testdata/source1:4 10"},
+		},
+		{
+			name:    "tee /some/path/testdata/source2:2",
+			center:  "tee /some/path/testdata/source2:2 1000 11000",
+			callers: []string{"main testdata/source1:2 11000"},
+			callees: []string{"tee /some/path/testdata/source2:8 10000"},
+		},
+	} {
+		bf, err := GetButterfly(rpt, tc.name)
+		if err != nil {
+			t.Fatalf("GetButterfly(%q): %v", tc.name, err)
+		}
+		entries := func(es []ButterflyEntry) []string {
+			var s []string
+			for _, e := range es {
+				s = append(s, fmt.Sprintf("%s %d", e.Name, e.Edge))
+			}
+			return s
+		}
+		c := bf.Center
+		if got := fmt.Sprintf("%s %d %d", c.Name, c.Flat, c.Cum); got != tc.center {
+			t.Errorf("GetButterfly(%q): got center %q, want %q", tc.name, got, tc.center)
+		}
+		if got := entries(bf.Callers); !reflect.DeepEqual(got, tc.callers) {
+			t.Errorf("GetButterfly(%q): got callers %q, want %q", tc.name, got, tc.callers)
+		}
+		if got := entries(bf.Callees); !reflect.DeepEqual(got, tc.callees) {
+			t.Errorf("GetButterfly(%q): got callees %q, want %q", tc.name, got, tc.callees)
+		}
+	}
+	if _, err := GetButterfly(rpt, "missing"); err == nil {
+		t.Errorf("GetButterfly(%q): got no error", "missing")
+	}
+}
+
+func TestCallTree(t *testing.T) {
+	rpt := New(testProfile.Copy(), &Options{
+		OutputFormat: Tree,
+		SampleValue:  func(v []int64) int64 { return v[1] },
+	})
+	// Each tree is built once and serves all the nodes.
+	trees := map[bool]*CallTree{
+		false: NewCallTree(rpt, false),
+		true:  NewCallTree(rpt, true),
+	}
+	// dump describes the nodes, with the children of expanded ones
+	// indented after them.
+	var dump func(nodes []*CallTreeNode, indent string) []string
+	dump = func(nodes []*CallTreeNode, indent string) []string {
+		var s []string
+		for _, n := range nodes {
+			line := fmt.Sprintf("%s%s %d %d %d", indent, n.Name, n.Self, n.Total, n.ChildCount)
+			if n.Match {
+				line += " match"
+			}
+			s = append(s, line)
+			s = append(s, dump(n.Children, indent+"  ")...)
+		}
+		return s
+	}
+	for _, tc := range []struct {
+		desc     string
+		inverted bool
+		path     []int
+		match    string
+		want     []string
+	}{
+		{
+			desc: "roots",
+			want: []string{"main testdata/source1:2 1 11111 3"},
+		},
+		{
+			desc: "children",
+			path: []int{0},
+			want: []string{
+				"tee /some/path/testdata/source2:2 1000 11000 1",
+				"bar testdata/source1:10 0 100 1",
+				"foo testdata/source1:4 0 10 1",
+			},
+		},
+		{
+			desc:     "inverted roots",
+			inverted: true,
+			want: []string{
+				"tee /some/path/testdata/source2:8 10100 10100 2",
+				"tee /some/path/testdata/source2:2 1000 1000 1",
+				"bar testdata/source1:10 10 10 1",
+				"main testdata/source1:2 1 1 0",
+			},
+		},
+		{
+			desc:     "inverted children",
+			inverted: true,
+			path:     []int{0, 0},
+			want:     []string{"main testdata/source1:2 0 10000 0"},
+		},
+		{
+			desc:  "search",
+			match: "source2:8",
+			want: []string{
+				"main testdata/source1:2 1 11111 3",
+				"  tee /some/path/testdata/source2:2 1000 11000 1",
+				"    tee /some/path/testdata/source2:8 10000 10000 0 match",
+				"  bar testdata/source1:10 0 100 1",
+				"    tee /some/path/testdata/source2:8 100 100 0 match",
+				"  foo testdata/source1:4 0 10 1",
+			},
+		},
+	} {
+		var match *regexp.Regexp
+		if tc.match != "" {
+			match = regexp.MustCompile(tc.match)
+		}
+		ct, err := trees[tc.inverted].Children(tc.path, match)
+		if err != nil {
+			t.Fatalf("%s: Children: %v", tc.desc, err)
+		}
+		if got := dump(ct.Nodes, ""); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got nodes\n%s\nwant\n%s", tc.desc, strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+		}
+	}
+	if _, err := trees[false].Children([]int{0, 3}, nil); err == nil {
+		t.Errorf("Children(%v): got no error", []int{0, 3})
+	}
+}
+
+func TestPathsAndHotPath(t *testing.T) {
+	for _, tc := range []struct {
+		format   int
+		from, to string
+		want     []string
+	}{
+		{
+			format: Paths,
+			from:   "main",
+			to:     "tee",
+			want: []string{
+				"3 paths from main to tee, 11100 (99.90%) of 11111 total",
+				"     10000 90.00%  main testdata/source1:2\n" +
+					"                   tee /some/path/testdata/source2:2\n" +
+					"                   tee /some/path/testdata/source2:8\n",
+				"       100   0.9%  main testdata/source1:2\n" +
+					"                   bar testdata/source1:10\n" +
+					"                   tee /some/path/testdata/source2:8\n",
+			},
+		},
+		{
+			format: Paths,
+			from:   "bar",
+			to:     "foo",
+			want:   []string{"No paths from bar to foo"},
+		},
+		{
+			format: HotPath,
+			want: []string{
+				"      edge  edge%        cum   cum%  name\n" +
+					"                       11111   100%  main testdata/source1:2\n" +
+					"     11000 99.00%      11000 99.00%  tee /some/path/testdata/source2:2\n" +
+					"     10000 90.00%      10100 90.90%  tee /some/path/testdata/source2:8\n",
+			},
+		},
+	} {
+		rpt := New(testProfile.Copy(), &Options{
+			OutputFormat: tc.format,
+			SampleValue:  func(v []int64) int64 { return v[1] },
+		})
+		if tc.from != "" {
+			rpt.options.PathFrom = regexp.MustCompile(tc.from)
+			rpt.options.PathTo = regexp.MustCompile(tc.to)
+		}
+		var buf bytes.Buffer
+		if err := Generate(&buf, rpt, nil); err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		for _, want := range tc.want {
+			if got := buf.String(); !strings.Contains(got, want) {
+				t.Errorf("report %d from %q to %q does not contain %q:\n%s", tc.format, tc.from, tc.to, want, got)
+			}
+		}
+	}
+}
+
+func TestDiffSignificance(t *testing.T) {
+	p := testProfile.Copy()
+	p.Sample = nil
+	add := func(l *profile.Location, base bool, src string, v int64) {
+		labels := map[string][]string{DiffSourceLabel: {src}}
+		if base {
+			labels["pprof::base"] = []string{"true"}
+			v = -v
+		}
+		p.Sample = append(p.Sample, &profile.Sample{
+			Location: []*profile.Location{l, testL[0]},
+			Value:    []int64{1, v},
+			Label:    labels,
+		})
+	}
+	// foo regresses consistently, bar changes by noise.
+	for i, v := range []int64{100, 102, 98} {
+		src := strconv.Itoa(i)
+		add(testL[1], true, src, v)
+		add(testL[1], false, src, 2*v)
+	}
+	for i, v := range []int64{50, 90, 10} {
+		src := strconv.Itoa(i)
+		add(testL[2], true, src, v)
+		add(testL[2], false, src, 100-v)
+	}
+	rpt := New(p, &Options{
+		OutputFormat: Diff,
+		SampleValue:  func(v []int64) int64 { return v[1] },
+		SampleType:   "cpu",
+		SampleUnit:   "cycles",
+	})
+	var buf bytes.Buffer
+	if err := Generate(&buf, rpt, nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		"Base profiles: 3, target profiles: 3",
+		"       100        200       +100  +100.0%    0.000  [+92, +108]              foo\n",
+		"Improvements (flat):\n  none\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("diff report does not contain %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "bar") {
+		t.Errorf("diff report lists insignificant change of bar:\n%s", got)
+	}
+}
+
+func TestDiffNormalizeDuration(t *testing.T) {
+	p := testProfile.Copy()
+	p.Sample = nil
+	add := func(base bool, v, duration int64) {
+		labels := map[string][]string{}
+		if base {
+			labels["pprof::base"] = []string{"true"}
+			v = -v
+		}
+		p.Sample = append(p.Sample, &profile.Sample{
+			Location: []*profile.Location{testL[1], testL[0]},
+			Value:    []int64{1, v},
+			Label:    labels,
+			NumLabel: map[string][]int64{DiffDurationLabel: {duration}},
+		})
+	}
+	// The target profile runs twice as long as the base at the same rate.
+	add(true, 100, 1e9)
+	add(false, 200, 2e9)
+	for _, tc := range []struct {
+		normalize profile.DiffNormalization
+		want      string
+	}{
+		{profile.DiffNoNormalization, "       100        200       +100  +100.0%  foo\n"},
+		{profile.DiffNormalizeDuration, "Regressions (flat):\n  none\n"},
+	} {
+		rpt := New(p.Copy(), &Options{
+			OutputFormat:  Diff,
+			SampleValue:   func(v []int64) int64 { return v[1] },
+			SampleType:    "cpu",
+			SampleUnit:    "cycles",
+			DiffNormalize: tc.normalize,
+		})
+		var buf bytes.Buffer
+		if err := Generate(&buf, rpt, nil); err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		if got := buf.String(); !strings.Contains(got, tc.want) {
+			t.Errorf("diff report with normalization %d does not contain %q:\n%s", tc.normalize, tc.want, got)
+		}
+	}
+}
+
+func TestDiffSourcesBeforeFiltering(t *testing.T) {
+	p := testProfile.Copy()
+	p.Sample = nil
+	// Samples merged with -base are only told apart by their labels.
+	add := func(base bool, index int, v int64) {
+		if base {
+			v = -v
+		}
+		p.Sample = append(p.Sample, &profile.Sample{
+			Location: []*profile.Location{testL[1], testL[0]},
+			Value:    []int64{1, v},
+			Label:    map[string][]string{DiffSourceLabel: {DiffSourceValue(base, index)}},
+		})
+	}
+	for i, v := range []int64{100, 102, 98} {
+		add(true, i, v)
+		add(false, i, 2*v)
+	}
+	sources := DiffSources(p)
+	if len(sources) != 6 {
+		t.Fatalf("DiffSources: got %d sources, want 6", len(sources))
+	}
+	// Filtering removes all the samples of one of the targets.
+	p.Sample = p.Sample[:len(p.Sample)-1]
+	rpt := New(p.Copy(), &Options{
+		OutputFormat: Diff,
+		SampleValue:  func(v []int64) int64 { return v[1] },
+		SampleType:   "cpu",
+		SampleUnit:   "cycles",
+		DiffSources:  sources,
+	})
+	var buf bytes.Buffer
+	if err := Generate(&buf, rpt, nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if got, want := buf.String(), "Base profiles: 3, target profiles: 3"; !strings.Contains(got, want) {
+		t.Errorf("diff report does not contain %q:\n%s", want, got)
+	}
+}
+
+func TestRemoveDiffSources(t *testing.T) {
+	p := testProfile.Copy()
+	p.Sample = nil
+	add := func(base bool, index int, v, duration int64) {
+		labels := map[string][]string{DiffSourceLabel: {DiffSourceValue(base, index)}}
+		if base {
+			labels["pprof::base"] = []string{"true"}
+			v = -v
+		}
+		p.Sample = append(p.Sample, &profile.Sample{
+			Location: []*profile.Location{testL[1], testL[0]},
+			Value:    []int64{1, v},
+			Label:    labels,
+			NumLabel: map[string][]int64{DiffDurationLabel: {duration}},
+			NumUnit:  map[string][]string{DiffDurationLabel: {"nanoseconds"}},
+		})
+	}
+	for i, v := range []int64{100, 102, 98} {
+		add(true, i, v, int64(i+1)*1e9)
+		add(false, i, 2*v, int64(i+1)*1e9)
+	}
+	got, err := RemoveDiffSources(p)
+	if err != nil {
+		t.Fatalf("RemoveDiffSources: %v", err)
+	}
+	// One sample is left for the bases and one for the targets.
+	if len(got.Sample) != 2 {
+		t.Fatalf("RemoveDiffSources: got %d samples, want 2:\n%s", len(got.Sample), got)
+	}
+	for _, s := range got.Sample {
+		if _, ok := s.Label[DiffSourceLabel]; ok {
+			t.Errorf("sample %v keeps label %s", s.Value, DiffSourceLabel)
+		}
+		if _, ok := s.NumLabel[DiffDurationLabel]; ok {
+			t.Errorf("sample %v keeps label %s", s.Value, DiffDurationLabel)
+		}
+		want := int64(600)
+		if s.Label["pprof::base"] != nil {
+			want = -300
+		}
+		if s.Value[1] != want {
+			t.Errorf("sample value: got %d, want %d", s.Value[1], want)
+		}
+	}
+	// Profiles without the labels are returned unchanged.
+	if q, _ := RemoveDiffSources(got); q != got {
+		t.Errorf("RemoveDiffSources copied a profile without diff labels")
+	}
+}
+
+func TestDominatorsAlignment(t *testing.T) {
+	p := testProfile.Copy()
+	// A tiny flat value gives a percentage in exponent notation, wider
+	// than the others.
+	for _, s := range p.Sample {
+		s.Value[1] *= 100000
+	}
+	p.Sample[0].Value[1] = 13
+	rpt := New(p, &Options{
+		OutputFormat: Dominators,
+		SampleValue:  func(v []int64) int64 { return v[1] },
+	})
+	var buf bytes.Buffer
+	if err := Generate(&buf, rpt, nil); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	got := buf.String()
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	header := -1
+	for i, l := range lines {
+		if strings.HasPrefix(strings.TrimSpace(l), "dominated") {
+			header = i
+			break
+		}
+	}
+	if header < 0 || header == len(lines)-1 {
+		t.Fatalf("no dominators table in report:\n%s", got)
+	}
+	// Every column ends at the same offset as its header.
+	fieldEnds := func(l string) []int {
+		var ends []int
+		for i := 1; i <= len(l); i++ {
+			if (i == len(l) || l[i] == ' ') && l[i-1] != ' ' {
+				ends = append(ends, i)
+			}
+		}
+		return ends
+	}
+	want := fieldEnds(lines[header])[:4]
+	for _, l := range lines[header+1:] {
+		if got := fieldEnds(l); len(got) < 4 || !reflect.DeepEqual(got[:4], want) {
+			t.Errorf("columns of %q end at %v, want %v:\n%s", l, got, want, buf.String())
+		}
+	}
+}
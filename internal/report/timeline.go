@@ -0,0 +1,148 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/lemonlinger/pprof/internal/measurement"
+)
+
+// defaultTimelineBuckets is the number of buckets of a timeline when
+// none is requested.
+const defaultTimelineBuckets = 40
+
+// TimelineData holds the sample values of a profile bucketed by the time
+// of their events.
+type TimelineData struct {
+	// Base is the time offsets are relative to, in nanoseconds since the
+	// Unix epoch: the start time of the profile, or its earliest event.
+	Base    int64            `json:"base,string"`
+	Total   int64            `json:"total"`
+	Buckets []TimelineBucket `json:"buckets"`
+}
+
+// TimelineBucket holds the value of the events in the time window
+// [Start, End), in nanoseconds since the Unix epoch.
+type TimelineBucket struct {
+	Start int64  `json:"start,string"`
+	End   int64  `json:"end,string"`
+	Value int64  `json:"value"`
+	Label string `json:"label"`
+}
+
+// GetTimeline buckets the sample values of the report by the times of
+// their events, as given by the sample timestamps, into at most
+// NodeCount windows of equal duration, or 40 if NodeCount is not set.
+// The value of a sample is spread evenly over its events. It returns an
+// error if no sample has timestamps.
+func GetTimeline(rpt *Report) (*TimelineData, error) {
+	prof, o := rpt.prof, rpt.options
+	buckets := o.NodeCount
+	if buckets <= 0 {
+		buckets = defaultTimelineBuckets
+	}
+
+	var min, max int64
+	found := false
+	for _, s := range prof.Sample {
+		for _, t := range s.Timestamps() {
+			if !found || t < min {
+				min = t
+			}
+			if !found || t > max {
+				max = t
+			}
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no samples with timestamps, a timeline needs a profile with a %q label", "timestamp")
+	}
+
+	step := (max - min + int64(buckets)) / int64(buckets)
+	n := int((max-min)/step) + 1
+	tl := &TimelineData{
+		Base:    prof.TimeNanos,
+		Buckets: make([]TimelineBucket, n),
+	}
+	if tl.Base == 0 {
+		tl.Base = min
+	}
+	for i := range tl.Buckets {
+		b := &tl.Buckets[i]
+		b.Start = min + int64(i)*step
+		b.End = b.Start + step
+	}
+	for _, s := range prof.Sample {
+		ts := s.Timestamps()
+		if len(ts) == 0 {
+			continue
+		}
+		v := o.SampleValue(s.Value)
+		tl.Total += v
+		// Spread the value over the events, the remainder going to the
+		// first ones so that the buckets add up to the total.
+		each, rest := v/int64(len(ts)), v%int64(len(ts))
+		for i, t := range ts {
+			w := each
+			if int64(i) < rest {
+				w++
+			} else if int64(i) < -rest {
+				w--
+			}
+			tl.Buckets[(t-min)/step].Value += w
+		}
+	}
+	for i := range tl.Buckets {
+		tl.Buckets[i].Label = rpt.formatValue(tl.Buckets[i].Value)
+	}
+	return tl, nil
+}
+
+// printTimeline prints the sample values of the profile over time, as
+// computed by GetTimeline, with a bar for each bucket.
+func printTimeline(w io.Writer, rpt *Report) error {
+	const barWidth = 50
+
+	tl, err := GetTimeline(rpt)
+	if err != nil {
+		return err
+	}
+	var maxValue int64
+	for _, b := range tl.Buckets {
+		if v := abs64(b.Value); v > maxValue {
+			maxValue = v
+		}
+	}
+
+	fmt.Fprintln(w, strings.Join(ProfileLabels(rpt), "\n"))
+	step := time.Duration(tl.Buckets[0].End - tl.Buckets[0].Start)
+	fmt.Fprintf(w, "Showing %d buckets of %v from %s\n", len(tl.Buckets), step, time.Unix(0, tl.Base).UTC().Format(time.RFC3339Nano))
+	fmt.Fprintf(w, "%12s %10s %7s\n", "offset", rpt.options.SampleType, "%")
+	for _, b := range tl.Buckets {
+		bar := 0
+		if maxValue > 0 {
+			bar = int(abs64(b.Value) * barWidth / maxValue)
+		}
+		fmt.Fprintf(w, "%12v %10s %7s |%s\n",
+			time.Duration(b.Start-tl.Base), b.Label,
+			measurement.Percentage(b.Value, tl.Total), strings.Repeat("#", bar))
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Frame is a frame of a call stack, as passed to Builder.Add.
+type Frame struct {
+	Function  string // Name of the function.
+	File      string // Source file of the function.
+	StartLine int64  // Line where the function starts, if known.
+	Line      int64  // Line being executed in the function.
+}
+
+// StackLocation is a location of a call stack, as passed to
+// Builder.Add. It holds the frames executing at the location, the
+// innermost inlined frame first, and optionally its address in a
+// mapping added with Builder.AddMapping.
+type StackLocation struct {
+	Frames  []Frame
+	Mapping *Mapping
+	Address uint64
+}
+
+// Frames returns a call stack with a location for each of frames,
+// none of which are inlined.
+func Frames(frames ...Frame) []StackLocation {
+	stack := make([]StackLocation, len(frames))
+	for i, f := range frames {
+		stack[i] = StackLocation{Frames: []Frame{f}}
+	}
+	return stack
+}
+
+// SampleLabels holds the labels of a sample, as passed to Builder.Add.
+// NumUnit holds the units of the values of NumLabel, if known.
+type SampleLabels struct {
+	Label    map[string][]string
+	NumLabel map[string][]int64
+	NumUnit  map[string][]string
+}
+
+// Builder builds a profile from call stacks and their values, taking
+// care of the assignment of IDs and of the deduplication of functions,
+// locations and samples. It can be used to produce profiles from
+// sources other than the Go runtime, such as custom tracers or logs.
+type Builder struct {
+	p *Profile
+
+	mappings  map[*Mapping]bool
+	functions map[functionKey]*Function
+	locations map[string]*Location
+	samples   map[sampleKey]*Sample
+}
+
+// NewBuilder returns a Builder for a profile with the given sample
+// types. Other header fields, such as the period, can be set on the
+// profile returned by Builder.Profile.
+func NewBuilder(sampleTypes []*ValueType) *Builder {
+	p := &Profile{
+		SampleType: make([]*ValueType, len(sampleTypes)),
+	}
+	for i, st := range sampleTypes {
+		p.SampleType[i] = &ValueType{Type: st.Type, Unit: st.Unit}
+	}
+	return &Builder{
+		p:         p,
+		mappings:  make(map[*Mapping]bool),
+		functions: make(map[functionKey]*Function),
+		locations: make(map[string]*Location),
+		samples:   make(map[sampleKey]*Sample),
+	}
+}
+
+// AddMapping adds a copy of m to the profile, with a new ID, and
+// returns it to be used in the locations passed to Add.
+func (b *Builder) AddMapping(m *Mapping) *Mapping {
+	mc := *m
+	mc.ID = uint64(len(b.p.Mapping) + 1)
+	b.mappings[&mc] = true
+	b.p.Mapping = append(b.p.Mapping, &mc)
+	return &mc
+}
+
+// Add adds a sample with the given call stack, leaf location first,
+// values, one for each sample type, and labels, which may be nil.
+// Samples with the same stack and labels are aggregated by adding
+// their values.
+func (b *Builder) Add(stack []StackLocation, values []int64, labels *SampleLabels) error {
+	if len(values) != len(b.p.SampleType) {
+		return fmt.Errorf("sample has %d values vs. %d types", len(values), len(b.p.SampleType))
+	}
+	s := &Sample{
+		Location: make([]*Location, len(stack)),
+	}
+	for i, sl := range stack {
+		l, err := b.location(sl)
+		if err != nil {
+			return err
+		}
+		s.Location[i] = l
+	}
+	if labels != nil {
+		if len(labels.Label) > 0 {
+			s.Label = make(map[string][]string, len(labels.Label))
+			for k, v := range labels.Label {
+				s.Label[k] = append([]string(nil), v...)
+			}
+		}
+		if len(labels.NumLabel) > 0 {
+			s.NumLabel = make(map[string][]int64, len(labels.NumLabel))
+			for k, v := range labels.NumLabel {
+				s.NumLabel[k] = append([]int64(nil), v...)
+			}
+			if len(labels.NumUnit) > 0 {
+				s.NumUnit = make(map[string][]string, len(labels.NumUnit))
+				for k, v := range labels.NumUnit {
+					if len(v) != len(labels.NumLabel[k]) {
+						return fmt.Errorf("label %s has %d units vs. %d values", k, len(v), len(labels.NumLabel[k]))
+					}
+					s.NumUnit[k] = append([]string(nil), v...)
+				}
+			}
+		}
+	}
+
+	k := s.key()
+	if ss, ok := b.samples[k]; ok {
+		for i, v := range values {
+			ss.Value[i] += v
+		}
+		return nil
+	}
+	s.Value = append([]int64(nil), values...)
+	b.samples[k] = s
+	b.p.Sample = append(b.p.Sample, s)
+	return nil
+}
+
+// Profile returns a copy of the profile built so far. The Builder can
+// still be used to add samples afterwards.
+func (b *Builder) Profile() *Profile {
+	return b.p.Copy()
+}
+
+func (b *Builder) location(sl StackLocation) (*Location, error) {
+	var mappingID uint64
+	if m := sl.Mapping; m != nil {
+		if !b.mappings[m] {
+			return nil, fmt.Errorf("mapping %s was not added with AddMapping", m.File)
+		}
+		mappingID = m.ID
+	}
+	var k strings.Builder
+	fmt.Fprintf(&k, "%d %x", mappingID, sl.Address)
+	for _, f := range sl.Frames {
+		fmt.Fprintf(&k, " %q %q %d %d", f.Function, f.File, f.StartLine, f.Line)
+	}
+	if l, ok := b.locations[k.String()]; ok {
+		return l, nil
+	}
+	l := &Location{
+		ID:      uint64(len(b.p.Location) + 1),
+		Mapping: sl.Mapping,
+		Address: sl.Address,
+		Line:    make([]Line, len(sl.Frames)),
+	}
+	for i, f := range sl.Frames {
+		l.Line[i] = Line{
+			Function: b.function(f),
+			Line:     f.Line,
+		}
+	}
+	b.locations[k.String()] = l
+	b.p.Location = append(b.p.Location, l)
+	return l, nil
+}
+func (b *Builder) function(f Frame) *Function {
+	fn := &Function{
+		Name:       f.Function,
+		SystemName: f.Function,
+		Filename:   f.File,
+		StartLine:  f.StartLine,
+	}
+	k := fn.key()
+	if fn, ok := b.functions[k]; ok {
+		return fn
+	}
+	fn.ID = uint64(len(b.p.Function) + 1)
+	b.functions[k] = fn
+	b.p.Function = append(b.p.Function, fn)
+	return fn
+}
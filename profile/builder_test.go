@@ -0,0 +1,111 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	b := NewBuilder([]*ValueType{{Type: "rows", Unit: "count"}, {Type: "time", Unit: "nanoseconds"}})
+
+	query := Frame{Function: "db.Query", File: "db.go", StartLine: 10, Line: 12}
+	handlerA := Frame{Function: "main.handler", File: "main.go", StartLine: 20, Line: 25}
+	handlerB := Frame{Function: "main.handler", File: "main.go", StartLine: 20, Line: 31}
+	for _, s := range []struct {
+		stack  []Frame
+		values []int64
+		labels *SampleLabels
+	}{
+		{[]Frame{query, handlerA}, []int64{1, 100}, nil},
+		{[]Frame{query, handlerA}, []int64{2, 200}, nil},
+		{[]Frame{query, handlerB}, []int64{4, 400}, nil},
+		{[]Frame{query, handlerB}, []int64{8, 800}, &SampleLabels{Label: map[string][]string{"table": {"users"}}}},
+		{[]Frame{query, handlerB}, []int64{16, 1600}, &SampleLabels{
+			NumLabel: map[string][]int64{"bytes": {512}},
+			NumUnit:  map[string][]string{"bytes": {"bytes"}},
+		}},
+	} {
+		if err := b.Add(Frames(s.stack...), s.values, s.labels); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	p := b.Profile()
+	if err := p.CheckValid(); err != nil {
+		t.Fatalf("CheckValid: %v", err)
+	}
+	if got, want := len(p.Function), 2; got != want {
+		t.Errorf("got %d functions, want %d", got, want)
+	}
+	if got, want := len(p.Location), 3; got != want {
+		t.Errorf("got %d locations, want %d", got, want)
+	}
+	want := []string{
+		"[16 1600] db.Query:12;main.handler:31  bytes:[512 bytes]",
+		"[3 300] db.Query:12;main.handler:25  ",
+		"[4 400] db.Query:12;main.handler:31  ",
+		"[8 800] db.Query:12;main.handler:31 table:[users] ",
+	}
+	if got := sampleSummary(p); !reflect.DeepEqual(got, want) {
+		t.Errorf("got samples\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if err := b.Add(Frames(query), []int64{1}, nil); err == nil {
+		t.Error("Add with wrong number of values: got no error")
+	}
+	if err := b.Add([]StackLocation{{Mapping: &Mapping{File: "other"}, Address: 0x10}}, []int64{1, 1}, nil); err == nil {
+		t.Error("Add with a mapping not added to the builder: got no error")
+	}
+}
+
+func TestBuilderInlined(t *testing.T) {
+	b := NewBuilder([]*ValueType{{Type: "samples", Unit: "count"}})
+	m := b.AddMapping(&Mapping{Start: 0x1000, Limit: 0x2000, File: "server", HasFunctions: true})
+
+	// Parse is inlined into handle, at the same address in both samples.
+	inlined := StackLocation{
+		Frames:  []Frame{{Function: "parse", Line: 5}, {Function: "handle", Line: 20}},
+		Mapping: m,
+		Address: 0x1100,
+	}
+	main := StackLocation{Frames: []Frame{{Function: "main", Line: 3}}, Mapping: m, Address: 0x1200}
+	for i := 0; i < 2; i++ {
+		if err := b.Add([]StackLocation{inlined, main}, []int64{1}, nil); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	p := b.Profile()
+	if err := p.CheckValid(); err != nil {
+		t.Fatalf("CheckValid: %v", err)
+	}
+	if got, want := len(p.Mapping), 1; got != want {
+		t.Fatalf("got %d mappings, want %d", got, want)
+	}
+	if got, want := len(p.Location), 2; got != want {
+		t.Fatalf("got %d locations, want %d", got, want)
+	}
+	l := p.Location[0]
+	if l.Mapping != p.Mapping[0] || l.Address != 0x1100 || len(l.Line) != 2 {
+		t.Errorf("got location %v, want the inlined location in the mapping", l)
+	}
+	want := []string{"[2] parse:5;handle:20;main:3  "}
+	if got := sampleSummary(p); !reflect.DeepEqual(got, want) {
+		t.Errorf("got samples %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,313 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// DiffNormalization selects how the base profiles are scaled before
+// they are compared with the target profiles by Diff.
+type DiffNormalization int
+
+const (
+	// DiffNoNormalization compares the values of the profiles as is.
+	DiffNoNormalization DiffNormalization = iota
+	// DiffNormalizeTotal scales each base profile to the mean total
+	// value of the target profiles.
+	DiffNormalizeTotal
+	// DiffNormalizeDuration scales each base profile by the ratio of
+	// the mean duration of the target profiles to its duration,
+	// comparing rates rather than values.
+	DiffNormalizeDuration
+)
+
+// DiffOptions controls the comparison of profiles by Diff.
+type DiffOptions struct {
+	// SampleType is the sample value to compare, as accepted by
+	// SampleIndexByName for the target profiles. The sample type it
+	// selects must also be present in the base profiles.
+	SampleType string
+	// Normalize selects how the base profiles are scaled.
+	Normalize DiffNormalization
+}
+
+// DiffValue is a value compared across base and target profiles.
+type DiffValue struct {
+	// Base and Target are the mean values in the base and target
+	// profiles.
+	Base, Target float64
+	// BaseValues and TargetValues are the values in each of the base
+	// and target profiles.
+	BaseValues, TargetValues []float64
+}
+
+// Delta returns the absolute difference between the target and base
+// values.
+func (v DiffValue) Delta() float64 {
+	return v.Target - v.Base
+}
+
+// Relative returns the difference between the target and base values
+// relative to the base value. It is infinite if the base value is zero
+// and the target value is not.
+func (v DiffValue) Relative() float64 {
+	if v.Base == 0 {
+		if v.Target == 0 {
+			return 0
+		}
+		return math.Inf(int(math.Copysign(1, v.Target)))
+	}
+	return (v.Target - v.Base) / math.Abs(v.Base)
+}
+
+// New reports whether the value is only present in the target profiles.
+func (v DiffValue) New() bool {
+	return v.Base == 0 && v.Target != 0
+}
+
+// Gone reports whether the value is only present in the base profiles.
+func (v DiffValue) Gone() bool {
+	return v.Base != 0 && v.Target == 0
+}
+
+// FunctionDiff compares the values of a function across profiles.
+type FunctionDiff struct {
+	Name string
+	// Flat is the value of the samples where the function is the
+	// leaf frame, and Cum the value of the samples that include it.
+	Flat, Cum DiffValue
+}
+
+// StackDiff compares the values of a call stack across profiles.
+type StackDiff struct {
+	Stack []string // Function names, leaf first.
+	Value DiffValue
+}
+
+// DiffResult is the result of the comparison of profiles by Diff.
+// Functions and stacks are sorted by decreasing absolute delta, of
+// the flat value for functions.
+type DiffResult struct {
+	SampleType *ValueType
+	// BaseScales are the factors applied to the values of each base
+	// profile, as selected by the normalization.
+	BaseScales []float64
+	Total      DiffValue
+
+	Functions []*FunctionDiff
+	Stacks    []*StackDiff
+}
+
+// Diff compares a target profile with a base profile. Functions and
+// call stacks are aligned across the profiles by name, so the profiles
+// can come from different binaries or runs.
+func Diff(base, target *Profile, o DiffOptions) (*DiffResult, error) {
+	return DiffSources([]*Profile{base}, []*Profile{target}, o)
+}
+
+// DiffSources compares a set of target profiles with a set of base
+// profiles, such as profiles of several runs of two versions of a
+// program, like Diff. The values of every function and stack are kept
+// for each profile, so that the significance of their differences can
+// be tested.
+func DiffSources(bases, targets []*Profile, o DiffOptions) (*DiffResult, error) {
+	if len(bases) == 0 || len(targets) == 0 {
+		return nil, fmt.Errorf("missing base or target profiles")
+	}
+	for _, p := range append(append([]*Profile(nil), bases...), targets...) {
+		if len(p.SampleType) == 0 {
+			return nil, fmt.Errorf("missing sample type information")
+		}
+	}
+	ti, err := targets[0].SampleIndexByName(o.SampleType)
+	if err != nil {
+		return nil, fmt.Errorf("target profile: %v", err)
+	}
+	sampleType := targets[0].SampleType[ti]
+	index := func(p *Profile, kind string) (int, error) {
+		i, err := p.SampleIndexByName(sampleType.Type)
+		if err != nil {
+			return 0, fmt.Errorf("%s profile: %v", kind, err)
+		}
+		if u := p.SampleType[i].Unit; u != sampleType.Unit {
+			return 0, fmt.Errorf("incompatible units for %s: %s vs. %s", sampleType.Type, u, sampleType.Unit)
+		}
+		return i, nil
+	}
+	baseIndex := make([]int, len(bases))
+	for i, p := range bases {
+		if baseIndex[i], err = index(p, "base"); err != nil {
+			return nil, err
+		}
+	}
+	targetIndex := make([]int, len(targets))
+	for i, p := range targets {
+		if targetIndex[i], err = index(p, "target"); err != nil {
+			return nil, err
+		}
+	}
+
+	d := &DiffResult{
+		SampleType: &ValueType{Type: sampleType.Type, Unit: sampleType.Unit},
+		BaseScales: make([]float64, len(bases)),
+		Total: DiffValue{
+			BaseValues:   make([]float64, len(bases)),
+			TargetValues: make([]float64, len(targets)),
+		},
+	}
+	var targetDuration float64
+	for i, p := range targets {
+		d.Total.TargetValues[i] = float64(sampleTotal(p, targetIndex[i]))
+		targetDuration += float64(p.DurationNanos) / float64(len(targets))
+	}
+	d.Total.Target = mean(d.Total.TargetValues)
+	for i, p := range bases {
+		total := float64(sampleTotal(p, baseIndex[i]))
+		d.BaseScales[i] = 1
+		switch o.Normalize {
+		case DiffNoNormalization:
+		case DiffNormalizeTotal:
+			if total != 0 {
+				d.BaseScales[i] = d.Total.Target / total
+			}
+		case DiffNormalizeDuration:
+			if p.DurationNanos == 0 || targetDuration == 0 {
+				return nil, fmt.Errorf("cannot normalize by duration: profile has no duration")
+			}
+			d.BaseScales[i] = targetDuration / float64(p.DurationNanos)
+		default:
+			return nil, fmt.Errorf("unknown normalization %d", o.Normalize)
+		}
+		d.Total.BaseValues[i] = total * d.BaseScales[i]
+	}
+	d.Total.Base = mean(d.Total.BaseValues)
+
+	functions := make(map[string]*FunctionDiff)
+	stacks := make(map[string]*StackDiff)
+	newValue := func() DiffValue {
+		return DiffValue{
+			BaseValues:   make([]float64, len(bases)),
+			TargetValues: make([]float64, len(targets)),
+		}
+	}
+	add := func(p *Profile, index int, scale float64, value func(v *DiffValue) *float64) {
+		for _, s := range p.Sample {
+			v := float64(s.Value[index]) * scale
+			if v == 0 {
+				continue
+			}
+			names := stackNames(s)
+			key := strings.Join(names, "\n")
+			sd := stacks[key]
+			if sd == nil {
+				sd = &StackDiff{Stack: names, Value: newValue()}
+				stacks[key] = sd
+			}
+			*value(&sd.Value) += v
+
+			seen := make(map[string]bool, len(names))
+			for i, name := range names {
+				fd := functions[name]
+				if fd == nil {
+					fd = &FunctionDiff{Name: name, Flat: newValue(), Cum: newValue()}
+					functions[name] = fd
+				}
+				if i == 0 {
+					*value(&fd.Flat) += v
+				}
+				if !seen[name] {
+					seen[name] = true
+					*value(&fd.Cum) += v
+				}
+			}
+		}
+	}
+	for i, p := range bases {
+		i := i
+		add(p, baseIndex[i], d.BaseScales[i], func(v *DiffValue) *float64 { return &v.BaseValues[i] })
+	}
+	for i, p := range targets {
+		i := i
+		add(p, targetIndex[i], 1, func(v *DiffValue) *float64 { return &v.TargetValues[i] })
+	}
+
+	for _, fd := range functions {
+		fd.Flat.summarize()
+		fd.Cum.summarize()
+		d.Functions = append(d.Functions, fd)
+	}
+	sort.Slice(d.Functions, func(i, j int) bool {
+		fi, fj := d.Functions[i], d.Functions[j]
+		if di, dj := math.Abs(fi.Flat.Delta()), math.Abs(fj.Flat.Delta()); di != dj {
+			return di > dj
+		}
+		if di, dj := math.Abs(fi.Cum.Delta()), math.Abs(fj.Cum.Delta()); di != dj {
+			return di > dj
+		}
+		return fi.Name < fj.Name
+	})
+	for _, sd := range stacks {
+		sd.Value.summarize()
+		d.Stacks = append(d.Stacks, sd)
+	}
+	sort.Slice(d.Stacks, func(i, j int) bool {
+		si, sj := d.Stacks[i], d.Stacks[j]
+		if di, dj := math.Abs(si.Value.Delta()), math.Abs(sj.Value.Delta()); di != dj {
+			return di > dj
+		}
+		return strings.Join(si.Stack, "\n") < strings.Join(sj.Stack, "\n")
+	})
+	return d, nil
+}
+
+// summarize sets the mean values of v from its per-profile values.
+func (v *DiffValue) summarize() {
+	v.Base, v.Target = mean(v.BaseValues), mean(v.TargetValues)
+}
+
+// sampleTotal returns the sum of the values at index of the samples of
+// p.
+func sampleTotal(p *Profile, index int) int64 {
+	var total int64
+	for _, s := range p.Sample {
+		total += s.Value[index]
+	}
+	return total
+}
+
+// stackNames returns the names of the frames of a sample, leaf first,
+// including inlined frames. Locations without symbol information are
+// named by their address.
+func stackNames(s *Sample) []string {
+	var names []string
+	for _, l := range s.Location {
+		if len(l.Line) == 0 {
+			names = append(names, fmt.Sprintf("%#x", l.Address))
+			continue
+		}
+		for _, ln := range l.Line {
+			if ln.Function == nil {
+				names = append(names, fmt.Sprintf("%#x", l.Address))
+				continue
+			}
+			names = append(names, ln.Function.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,162 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	b := NewBuilder([]*ValueType{{Type: "cpu", Unit: "nanoseconds"}})
+	main := Frame{Function: "main"}
+	foo := Frame{Function: "foo"}
+	bar := Frame{Function: "bar"}
+	b.Add(Frames(foo, main), []int64{100}, nil)
+	b.Add(Frames(bar, main), []int64{100}, nil)
+	base := b.Profile()
+	base.DurationNanos = 10e9
+
+	b = NewBuilder([]*ValueType{{Type: "cpu", Unit: "nanoseconds"}})
+	baz := Frame{Function: "baz"}
+	b.Add(Frames(foo, main), []int64{400}, nil)
+	b.Add(Frames(baz, foo, main), []int64{200}, nil)
+	target := b.Profile()
+	target.DurationNanos = 20e9
+
+	for _, tc := range []struct {
+		name      string
+		normalize DiffNormalization
+		scale     float64
+		// Base and target flat and cum values per function.
+		want map[string][4]float64
+	}{
+		{
+			name:      "none",
+			normalize: DiffNoNormalization,
+			scale:     1,
+			want: map[string][4]float64{
+				"main": {0, 0, 200, 600},
+				"foo":  {100, 400, 100, 600},
+				"bar":  {100, 0, 100, 0},
+				"baz":  {0, 200, 0, 200},
+			},
+		},
+		{
+			name:      "total",
+			normalize: DiffNormalizeTotal,
+			scale:     3,
+			want: map[string][4]float64{
+				"main": {0, 0, 600, 600},
+				"foo":  {300, 400, 300, 600},
+				"bar":  {300, 0, 300, 0},
+				"baz":  {0, 200, 0, 200},
+			},
+		},
+		{
+			name:      "duration",
+			normalize: DiffNormalizeDuration,
+			scale:     2,
+			want: map[string][4]float64{
+				"main": {0, 0, 400, 600},
+				"foo":  {200, 400, 200, 600},
+				"bar":  {200, 0, 200, 0},
+				"baz":  {0, 200, 0, 200},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := Diff(base, target, DiffOptions{Normalize: tc.normalize})
+			if err != nil {
+				t.Fatalf("Diff: %v", err)
+			}
+			if d.BaseScales[0] != tc.scale {
+				t.Errorf("got scale %v, want %v", d.BaseScales[0], tc.scale)
+			}
+			if len(d.Functions) != len(tc.want) {
+				t.Errorf("got %d functions, want %d", len(d.Functions), len(tc.want))
+			}
+			for _, fd := range d.Functions {
+				got := [4]float64{fd.Flat.Base, fd.Flat.Target, fd.Cum.Base, fd.Cum.Target}
+				if want := tc.want[fd.Name]; got != want {
+					t.Errorf("%s: got flat and cum %v, want %v", fd.Name, got, want)
+				}
+			}
+			if len(d.Stacks) != 3 {
+				t.Errorf("got %d stacks, want 3", len(d.Stacks))
+			}
+		})
+	}
+
+	d, err := Diff(base, target, DiffOptions{})
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	if got := d.Functions[0].Name; got != "foo" {
+		t.Errorf("got largest flat delta for %s, want foo", got)
+	}
+	if got := strings.Join(d.Stacks[0].Stack, ";"); got != "foo;main" {
+		t.Errorf("got largest stack delta for %s, want foo;main", got)
+	}
+	for _, fd := range d.Functions {
+		switch fd.Name {
+		case "baz":
+			if !fd.Flat.New() || !math.IsInf(fd.Flat.Relative(), 1) {
+				t.Errorf("baz: got new %v, relative %v; want new, +Inf", fd.Flat.New(), fd.Flat.Relative())
+			}
+		case "bar":
+			if !fd.Flat.Gone() || fd.Flat.Relative() != -1 {
+				t.Errorf("bar: got gone %v, relative %v; want gone, -1", fd.Flat.Gone(), fd.Flat.Relative())
+			}
+		}
+	}
+
+	if _, err := Diff(base, target, DiffOptions{SampleType: "alloc"}); err == nil {
+		t.Error("Diff with unknown sample type: got no error")
+	}
+}
+
+func TestDiffSignificance(t *testing.T) {
+	v := DiffValue{
+		BaseValues:   []float64{1, 2, 3, 4, 5},
+		TargetValues: []float64{6, 7, 8, 9, 10},
+	}
+	// t = 5 with 8 degrees of freedom.
+	p, low, high, err := v.Significance(0.95)
+	if err != nil {
+		t.Fatalf("Significance: %v", err)
+	}
+	for _, c := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"p-value", p, 0.001053},
+		{"low", low, 5 - 2.306004},
+		{"high", high, 5 + 2.306004},
+	} {
+		if math.Abs(c.got-c.want) > 1e-5 {
+			t.Errorf("got %s %v, want %v", c.name, c.got, c.want)
+		}
+	}
+
+	same := DiffValue{BaseValues: []float64{1, 2, 3}, TargetValues: []float64{3, 2, 1}}
+	if p, _, _, err := same.Significance(0.95); err != nil || p != 1 {
+		t.Errorf("equal samples: got p-value %v, error %v; want 1, nil", p, err)
+	}
+	if _, _, _, err := (DiffValue{BaseValues: []float64{1}, TargetValues: []float64{1, 2}}).Significance(0.95); err == nil {
+		t.Error("single base value: got no error")
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"fmt"
+	"math"
+)
+
+// Significance tests whether the difference between the target and
+// base values of v is statistically significant, with a Welch's t-test
+// on the values of each profile. It returns the p-value of the test
+// and the confidence interval of the difference of the means at the
+// given confidence level, such as 0.95. It returns an error if there
+// are fewer than two base or target values.
+func (v DiffValue) Significance(confidence float64) (pValue, low, high float64, err error) {
+	nb, nt := float64(len(v.BaseValues)), float64(len(v.TargetValues))
+	if nb < 2 || nt < 2 {
+		return 0, 0, 0, fmt.Errorf("significance requires at least two base and two target profiles, got %d and %d", len(v.BaseValues), len(v.TargetValues))
+	}
+	if confidence <= 0 || confidence >= 1 {
+		return 0, 0, 0, fmt.Errorf("confidence %v is not in (0, 1)", confidence)
+	}
+	delta := mean(v.TargetValues) - mean(v.BaseValues)
+	vb, vt := variance(v.BaseValues)/nb, variance(v.TargetValues)/nt
+	se := math.Sqrt(vb + vt)
+	if se == 0 {
+		// Without variance any difference is significant.
+		if delta == 0 {
+			return 1, 0, 0, nil
+		}
+		return 0, delta, delta, nil
+	}
+	t := delta / se
+	df := (vb + vt) * (vb + vt) / (vb*vb/(nb-1) + vt*vt/(nt-1))
+	pValue = studentTwoTailed(t, df)
+
+	// Find the critical value of t for the confidence level by
+	// bisection of the two-tailed probability, which decreases with t.
+	alpha := 1 - confidence
+	lo, hi := 0.0, 1.0
+	for studentTwoTailed(hi, df) > alpha {
+		hi *= 2
+	}
+	for i := 0; i < 100; i++ {
+		mid := (lo + hi) / 2
+		if studentTwoTailed(mid, df) > alpha {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return pValue, delta - hi*se, delta + hi*se, nil
+}
+
+// studentTwoTailed returns the probability that the absolute value of
+// a variable with a Student's t-distribution with df degrees of freedom
+// is larger than |t|.
+func studentTwoTailed(t, df float64) float64 {
+	return regIncBeta(df/2, 0.5, df/(df+t*t))
+}
+
+// regIncBeta returns the regularized incomplete beta function
+// I_x(a, b), evaluated with a continued fraction.
+func regIncBeta(a, b, x float64) float64 {
+	switch {
+	case x <= 0:
+		return 0
+	case x >= 1:
+		return 1
+	}
+	la, _ := math.Lgamma(a)
+	lb, _ := math.Lgamma(b)
+	lab, _ := math.Lgamma(a + b)
+	front := math.Exp(lab - la - lb + a*math.Log(x) + b*math.Log(1-x))
+	// The continued fraction converges quickly for x < (a+1)/(a+b+2),
+	// and the symmetry I_x(a, b) = 1 - I_(1-x)(b, a) covers the rest.
+	if x > (a+1)/(a+b+2) {
+		return 1 - front*betaFraction(b, a, 1-x)/b
+	}
+	return front * betaFraction(a, b, x) / a
+}
+
+// betaFraction evaluates the continued fraction of the incomplete beta
+// function with the modified Lentz's method.
+func betaFraction(a, b, x float64) float64 {
+	const (
+		epsilon = 1e-14
+		tiny    = 1e-300
+	)
+	clamp := func(v float64) float64 {
+		if math.Abs(v) < tiny {
+			return tiny
+		}
+		return v
+	}
+	c, d := 1.0, 1/clamp(1-(a+b)*x/(a+1))
+	f := d
+	for m := 1.0; m < 300; m++ {
+		// Even step.
+		num := m * (b - m) * x / ((a + 2*m - 1) * (a + 2*m))
+		d = 1 / clamp(1+num*d)
+		c = clamp(1 + num/c)
+		f *= d * c
+		// Odd step.
+		num = -(a + m) * (a + b + m) * x / ((a + 2*m) * (a + 2*m + 1))
+		d = 1 / clamp(1+num*d)
+		c = clamp(1 + num/c)
+		delta := d * c
+		f *= delta
+		if math.Abs(delta-1) < epsilon {
+			break
+		}
+	}
+	return f
+}
+
+func mean(vs []float64) float64 {
+	if len(vs) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range vs {
+		sum += v
+	}
+	return sum / float64(len(vs))
+}
+
+// variance returns the sample variance of vs.
+func variance(vs []float64) float64 {
+	if len(vs) < 2 {
+		return 0
+	}
+	m := mean(vs)
+	var sum float64
+	for _, v := range vs {
+		sum += (v - m) * (v - m)
+	}
+	return sum / float64(len(vs)-1)
+}
@@ -299,48 +299,7 @@ func (p *Profile) postDecode() error {
 	}
 
 	for _, s := range p.Sample {
-		labels := make(map[string][]string, len(s.labelX))
-		numLabels := make(map[string][]int64, len(s.labelX))
-		numUnits := make(map[string][]string, len(s.labelX))
-		for _, l := range s.labelX {
-			var key, value string
-			key, err = getString(p.stringTable, &l.keyX, err)
-			if l.strX != 0 {
-				value, err = getString(p.stringTable, &l.strX, err)
-				labels[key] = append(labels[key], value)
-			} else if l.numX != 0 {
-				numValues := numLabels[key]
-				units := numUnits[key]
-				if l.unitX != 0 {
-					var unit string
-					unit, err = getString(p.stringTable, &l.unitX, err)
-					units = padStringArray(units, len(numValues))
-					numUnits[key] = append(units, unit)
-				}
-				numLabels[key] = append(numLabels[key], l.numX)
-			}
-		}
-		if len(labels) > 0 {
-			s.Label = labels
-		}
-		if len(numLabels) > 0 {
-			s.NumLabel = numLabels
-			for key, units := range numUnits {
-				if len(units) > 0 {
-					numUnits[key] = padStringArray(units, len(numLabels[key]))
-				}
-			}
-			s.NumUnit = numUnits
-		}
-		s.Location = make([]*Location, len(s.locationIDX))
-		for i, lid := range s.locationIDX {
-			if lid < uint64(len(locationIds)) {
-				s.Location[i] = locationIds[lid]
-			} else {
-				s.Location[i] = locations[lid]
-			}
-		}
-		s.locationIDX = nil
+		err = s.postDecode(p.stringTable, locationIds, locations, err)
 	}
 
 	p.DropFrames, err = getString(p.stringTable, &p.dropFramesX, err)
@@ -367,6 +326,60 @@ func (p *Profile) postDecode() error {
 	return err
 }
 
+// postDecode populates the exported fields of a sample from the
+// unexported fields populated by decode, looking up strings in
+// stringTable and locations by ID in locationIds, or in locations for
+// IDs beyond its length. It does nothing if err is not nil, so that
+// errors can be chained as with getString.
+func (s *Sample) postDecode(stringTable []string, locationIds []*Location, locations map[uint64]*Location, err error) error {
+	if err != nil {
+		return err
+	}
+	labels := make(map[string][]string, len(s.labelX))
+	numLabels := make(map[string][]int64, len(s.labelX))
+	numUnits := make(map[string][]string, len(s.labelX))
+	for _, l := range s.labelX {
+		var key, value string
+		key, err = getString(stringTable, &l.keyX, err)
+		if l.strX != 0 {
+			value, err = getString(stringTable, &l.strX, err)
+			labels[key] = append(labels[key], value)
+		} else if l.numX != 0 {
+			numValues := numLabels[key]
+			units := numUnits[key]
+			if l.unitX != 0 {
+				var unit string
+				unit, err = getString(stringTable, &l.unitX, err)
+				units = padStringArray(units, len(numValues))
+				numUnits[key] = append(units, unit)
+			}
+			numLabels[key] = append(numLabels[key], l.numX)
+		}
+	}
+	if len(labels) > 0 {
+		s.Label = labels
+	}
+	if len(numLabels) > 0 {
+		s.NumLabel = numLabels
+		for key, units := range numUnits {
+			if len(units) > 0 {
+				numUnits[key] = padStringArray(units, len(numLabels[key]))
+			}
+		}
+		s.NumUnit = numUnits
+	}
+	s.Location = make([]*Location, len(s.locationIDX))
+	for i, lid := range s.locationIDX {
+		if lid < uint64(len(locationIds)) {
+			s.Location[i] = locationIds[lid]
+		} else {
+			s.Location[i] = locations[lid]
+		}
+	}
+	s.locationIDX = nil
+	return err
+}
+
 // padStringArray pads arr with enough empty strings to make arr
 // length l when arr's length is less than l.
 func padStringArray(arr []string, l int) []string {
@@ -22,56 +22,81 @@ import "regexp"
 // samples where at least one frame matches focus but none match ignore.
 // Returns true is the corresponding regexp matched at least one sample.
 func (p *Profile) FilterSamplesByName(focus, ignore, hide, show *regexp.Regexp) (fm, im, hm, hnm bool) {
-	focusOrIgnore := make(map[uint64]bool)
-	hidden := make(map[uint64]bool)
+	f, fm, im, hm, hnm := p.nameFilter(focus, ignore, hide, show)
+	s := make([]*Sample, 0, len(p.Sample))
+	for _, sample := range p.Sample {
+		if f.keep(sample) {
+			s = append(s, sample)
+		}
+	}
+	p.Sample = s
+
+	return
+}
+
+// nameFilter holds the locations of a profile selected by the regexps
+// of FilterSamplesByName, to filter its samples.
+type nameFilter struct {
+	focusOrIgnore map[uint64]bool
+	hidden        map[uint64]bool
+}
+
+// nameFilter applies hide and show to the locations of p, and returns
+// a filter of its samples by focus and ignore. It also returns whether
+// each regexp matched any location.
+func (p *Profile) nameFilter(focus, ignore, hide, show *regexp.Regexp) (f *nameFilter, fm, im, hm, hnm bool) {
+	f = &nameFilter{
+		focusOrIgnore: make(map[uint64]bool),
+		hidden:        make(map[uint64]bool),
+	}
 	for _, l := range p.Location {
 		if ignore != nil && l.matchesName(ignore) {
 			im = true
-			focusOrIgnore[l.ID] = false
+			f.focusOrIgnore[l.ID] = false
 		} else if focus == nil || l.matchesName(focus) {
 			fm = true
-			focusOrIgnore[l.ID] = true
+			f.focusOrIgnore[l.ID] = true
 		}
 
 		if hide != nil && l.matchesName(hide) {
 			hm = true
 			l.Line = l.unmatchedLines(hide)
 			if len(l.Line) == 0 {
-				hidden[l.ID] = true
+				f.hidden[l.ID] = true
 			}
 		}
 		if show != nil {
 			l.Line = l.matchedLines(show)
 			if len(l.Line) == 0 {
-				hidden[l.ID] = true
+				f.hidden[l.ID] = true
 			} else {
 				hnm = true
 			}
 		}
 	}
+	return
+}
 
-	s := make([]*Sample, 0, len(p.Sample))
-	for _, sample := range p.Sample {
-		if focusedAndNotIgnored(sample.Location, focusOrIgnore) {
-			if len(hidden) > 0 {
-				var locs []*Location
-				for _, loc := range sample.Location {
-					if !hidden[loc.ID] {
-						locs = append(locs, loc)
-					}
-				}
-				if len(locs) == 0 {
-					// Remove sample with no locations (by not adding it to s).
-					continue
-				}
-				sample.Location = locs
+// keep reports whether a sample is kept by the filter, and removes its
+// hidden locations if so.
+func (f *nameFilter) keep(sample *Sample) bool {
+	if !focusedAndNotIgnored(sample.Location, f.focusOrIgnore) {
+		return false
+	}
+	if len(f.hidden) > 0 {
+		var locs []*Location
+		for _, loc := range sample.Location {
+			if !f.hidden[loc.ID] {
+				locs = append(locs, loc)
 			}
-			s = append(s, sample)
 		}
+		if len(locs) == 0 {
+			// Remove sample with no locations.
+			return false
+		}
+		sample.Location = locs
 	}
-	p.Sample = s
-
-	return
+	return true
 }
 
 // ShowFrom drops all stack frames above the highest matching frame and returns
@@ -268,3 +293,47 @@ func (p *Profile) FilterSamplesByTag(focus, ignore TagMatch) (fm, im bool) {
 	p.Sample = samples
 	return
 }
+
+// FilterSamplesByTime only keeps the samples of a profile with events
+// in the time window [start, end), in nanoseconds since the Unix epoch,
+// as given by their timestamps. Samples without timestamps are dropped.
+// The values of samples with events both in and out of the window are
+// scaled down to the fraction of their events in the window. Returns
+// true if any sample has events in the window.
+func (p *Profile) FilterSamplesByTime(start, end int64) (matched bool) {
+	var samples []*Sample
+	for _, s := range p.Sample {
+		ts := s.Timestamps()
+		var in []int
+		for i, t := range ts {
+			if t >= start && t < end {
+				in = append(in, i)
+			}
+		}
+		if len(in) == 0 {
+			continue
+		}
+		matched = true
+		if len(in) < len(ts) {
+			values, units := s.NumLabel[TimestampLabel], s.NumUnit[TimestampLabel]
+			var keptValues []int64
+			var keptUnits []string
+			for _, i := range in {
+				keptValues = append(keptValues, values[i])
+				if i < len(units) {
+					keptUnits = append(keptUnits, units[i])
+				}
+			}
+			s.NumLabel[TimestampLabel] = keptValues
+			if units != nil {
+				s.NumUnit[TimestampLabel] = keptUnits
+			}
+			for i, v := range s.Value {
+				s.Value[i] = v * int64(len(in)) / int64(len(ts))
+			}
+		}
+		samples = append(samples, s)
+	}
+	p.Sample = samples
+	return
+}
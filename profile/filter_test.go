@@ -597,3 +597,31 @@ func TestTagFilter(t *testing.T) {
 		}
 	}
 }
+
+func TestFilterSamplesByTime(t *testing.T) {
+	p := &Profile{
+		SampleType: []*ValueType{{Type: "samples", Unit: "count"}},
+		Sample: []*Sample{
+			{Value: []int64{4}, NumLabel: map[string][]int64{TimestampLabel: {100, 150, 200, 250}}},
+			{Value: []int64{1}, NumLabel: map[string][]int64{TimestampLabel: {300}}},
+			{Value: []int64{2}, NumLabel: map[string][]int64{TimestampLabel: {1}}, NumUnit: map[string][]string{TimestampLabel: {"us"}}},
+			{Value: []int64{8}},
+		},
+	}
+	if !p.FilterSamplesByTime(150, 2000) {
+		t.Fatalf("FilterSamplesByTime(150, 2000): got no match")
+	}
+	var got []string
+	for _, s := range p.Sample {
+		got = append(got, fmt.Sprint(s.Value[0], s.Timestamps()))
+	}
+	if want := []string{"3 [150 200 250]", "1 [300]", "2 [1000]"}; strings.Join(got, ";") != strings.Join(want, ";") {
+		t.Errorf("got samples %v, want %v", got, want)
+	}
+	if p.FilterSamplesByTime(0, 100) {
+		t.Errorf("FilterSamplesByTime(0, 100): got a match")
+	}
+	if len(p.Sample) != 0 {
+		t.Errorf("got %d samples after filtering out all, want 0", len(p.Sample))
+	}
+}
@@ -0,0 +1,378 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+// The JSON representation of a profile mirrors profile.proto field by
+// field, using the proto field names as JSON keys. The only structural
+// difference is that there is no string table: every string is stored
+// inline where the proto would hold a string table index. IDs of
+// mappings, locations and functions are preserved, and references
+// between messages are made through those IDs, as in the proto.
+//
+// 64-bit values are encoded as JSON numbers. Tools that represent
+// numbers as doubles may lose precision on addresses above 2^53.
+//
+//	{
+//	  "sample_type": [{"type": "samples", "unit": "count"}, ...],
+//	  "sample": [{
+//	    "location_id": [1, 2],
+//	    "value": [10, 1000],
+//	    "label": [{"key": "thread", "str": "main"},
+//	              {"key": "bytes", "num": 64, "num_unit": "bytes"}]
+//	  }, ...],
+//	  "mapping": [{"id": 1, "memory_start": 4194304, "memory_limit": 8388608,
+//	               "file_offset": 0, "filename": "/bin/app", "build_id": "abc",
+//	               "has_functions": true, ...}, ...],
+//	  "location": [{"id": 1, "mapping_id": 1, "address": 4198400,
+//	                "line": [{"function_id": 1, "line": 12}], "is_folded": false}, ...],
+//	  "function": [{"id": 1, "name": "main", "system_name": "main",
+//	                "filename": "main.go", "start_line": 10}, ...],
+//	  "drop_frames": "", "keep_frames": "",
+//	  "time_nanos": 0, "duration_nanos": 0,
+//	  "period_type": {"type": "cpu", "unit": "nanoseconds"}, "period": 10000000,
+//	  "comment": ["..."],
+//	  "default_sample_type": ""
+//	}
+type jsonProfile struct {
+	SampleType        []jsonValueType `json:"sample_type"`
+	Sample            []jsonSample    `json:"sample"`
+	Mapping           []jsonMapping   `json:"mapping"`
+	Location          []jsonLocation  `json:"location"`
+	Function          []jsonFunction  `json:"function"`
+	DropFrames        string          `json:"drop_frames,omitempty"`
+	KeepFrames        string          `json:"keep_frames,omitempty"`
+	TimeNanos         int64           `json:"time_nanos,omitempty"`
+	DurationNanos     int64           `json:"duration_nanos,omitempty"`
+	PeriodType        *jsonValueType  `json:"period_type,omitempty"`
+	Period            int64           `json:"period,omitempty"`
+	Comment           []string        `json:"comment,omitempty"`
+	DefaultSampleType string          `json:"default_sample_type,omitempty"`
+}
+
+type jsonValueType struct {
+	Type string `json:"type"`
+	Unit string `json:"unit"`
+}
+
+type jsonSample struct {
+	LocationID []uint64    `json:"location_id"`
+	Value      []int64     `json:"value"`
+	Label      []jsonLabel `json:"label,omitempty"`
+}
+
+// jsonLabel holds either a string value (Str) or a numeric value (Num
+// with an optional NumUnit), as in profile.proto.
+type jsonLabel struct {
+	Key     string  `json:"key"`
+	Str     *string `json:"str,omitempty"`
+	Num     int64   `json:"num,omitempty"`
+	NumUnit string  `json:"num_unit,omitempty"`
+}
+
+type jsonMapping struct {
+	ID              uint64 `json:"id"`
+	MemoryStart     uint64 `json:"memory_start"`
+	MemoryLimit     uint64 `json:"memory_limit"`
+	FileOffset      uint64 `json:"file_offset"`
+	Filename        string `json:"filename"`
+	BuildID         string `json:"build_id"`
+	HasFunctions    bool   `json:"has_functions"`
+	HasFilenames    bool   `json:"has_filenames"`
+	HasLineNumbers  bool   `json:"has_line_numbers"`
+	HasInlineFrames bool   `json:"has_inline_frames"`
+}
+
+type jsonLocation struct {
+	ID        uint64     `json:"id"`
+	MappingID uint64     `json:"mapping_id,omitempty"`
+	Address   uint64     `json:"address"`
+	Line      []jsonLine `json:"line,omitempty"`
+	IsFolded  bool       `json:"is_folded,omitempty"`
+}
+
+type jsonLine struct {
+	FunctionID uint64 `json:"function_id,omitempty"`
+	Line       int64  `json:"line"`
+}
+
+type jsonFunction struct {
+	ID         uint64 `json:"id"`
+	Name       string `json:"name"`
+	SystemName string `json:"system_name"`
+	Filename   string `json:"filename"`
+	StartLine  int64  `json:"start_line,omitempty"`
+}
+
+// WriteJSON writes the profile as an indented JSON document. The schema
+// mirrors profile.proto with strings stored inline instead of through
+// a string table. The output can be read back with ParseJSON.
+func (p *Profile) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p.toJSON())
+}
+
+// ParseJSON parses a profile in the format written by WriteJSON and
+// checks for its validity.
+func ParseJSON(r io.Reader) (*Profile, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p, err := parseJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing profile: %v", err)
+	}
+	if err := p.CheckValid(); err != nil {
+		return nil, fmt.Errorf("malformed profile: %v", err)
+	}
+	return p, nil
+}
+
+// isJSON reports whether data looks like a JSON-encoded profile.
+func isJSON(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	return len(data) > 0 && data[0] == '{'
+}
+
+func (p *Profile) toJSON() *jsonProfile {
+	jp := &jsonProfile{
+		SampleType:        make([]jsonValueType, len(p.SampleType)),
+		Sample:            make([]jsonSample, len(p.Sample)),
+		Mapping:           make([]jsonMapping, len(p.Mapping)),
+		Location:          make([]jsonLocation, len(p.Location)),
+		Function:          make([]jsonFunction, len(p.Function)),
+		DropFrames:        p.DropFrames,
+		KeepFrames:        p.KeepFrames,
+		TimeNanos:         p.TimeNanos,
+		DurationNanos:     p.DurationNanos,
+		Period:            p.Period,
+		Comment:           p.Comments,
+		DefaultSampleType: p.DefaultSampleType,
+	}
+	for i, st := range p.SampleType {
+		jp.SampleType[i] = jsonValueType{st.Type, st.Unit}
+	}
+	if pt := p.PeriodType; pt != nil && (pt.Type != "" || pt.Unit != "") {
+		jp.PeriodType = &jsonValueType{pt.Type, pt.Unit}
+	}
+	for i, s := range p.Sample {
+		js := jsonSample{
+			LocationID: make([]uint64, len(s.Location)),
+			Value:      s.Value,
+		}
+		for j, l := range s.Location {
+			js.LocationID[j] = l.ID
+		}
+		// Emit labels in key order so the output is stable.
+		var keys []string
+		for k := range s.Label {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			for _, v := range s.Label[k] {
+				v := v
+				js.Label = append(js.Label, jsonLabel{Key: k, Str: &v})
+			}
+		}
+		keys = keys[:0]
+		for k := range s.NumLabel {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			units := s.NumUnit[k]
+			for j, v := range s.NumLabel[k] {
+				l := jsonLabel{Key: k, Num: v}
+				if j < len(units) {
+					l.NumUnit = units[j]
+				}
+				js.Label = append(js.Label, l)
+			}
+		}
+		jp.Sample[i] = js
+	}
+	for i, m := range p.Mapping {
+		jp.Mapping[i] = jsonMapping{
+			ID:              m.ID,
+			MemoryStart:     m.Start,
+			MemoryLimit:     m.Limit,
+			FileOffset:      m.Offset,
+			Filename:        m.File,
+			BuildID:         m.BuildID,
+			HasFunctions:    m.HasFunctions,
+			HasFilenames:    m.HasFilenames,
+			HasLineNumbers:  m.HasLineNumbers,
+			HasInlineFrames: m.HasInlineFrames,
+		}
+	}
+	for i, l := range p.Location {
+		jl := jsonLocation{
+			ID:       l.ID,
+			Address:  l.Address,
+			IsFolded: l.IsFolded,
+		}
+		if l.Mapping != nil {
+			jl.MappingID = l.Mapping.ID
+		}
+		for _, ln := range l.Line {
+			var fid uint64
+			if ln.Function != nil {
+				fid = ln.Function.ID
+			}
+			jl.Line = append(jl.Line, jsonLine{fid, ln.Line})
+		}
+		jp.Location[i] = jl
+	}
+	for i, f := range p.Function {
+		jp.Function[i] = jsonFunction{
+			ID:         f.ID,
+			Name:       f.Name,
+			SystemName: f.SystemName,
+			Filename:   f.Filename,
+			StartLine:  f.StartLine,
+		}
+	}
+	return jp
+}
+
+// parseJSON decodes a JSON profile and resolves the ID references
+// between its messages.
+func parseJSON(data []byte) (*Profile, error) {
+	var jp jsonProfile
+	if err := json.Unmarshal(data, &jp); err != nil {
+		return nil, err
+	}
+
+	p := &Profile{
+		DropFrames:        jp.DropFrames,
+		KeepFrames:        jp.KeepFrames,
+		TimeNanos:         jp.TimeNanos,
+		DurationNanos:     jp.DurationNanos,
+		PeriodType:        &ValueType{},
+		Period:            jp.Period,
+		Comments:          jp.Comment,
+		DefaultSampleType: jp.DefaultSampleType,
+	}
+	for _, st := range jp.SampleType {
+		p.SampleType = append(p.SampleType, &ValueType{Type: st.Type, Unit: st.Unit})
+	}
+	if pt := jp.PeriodType; pt != nil {
+		p.PeriodType = &ValueType{Type: pt.Type, Unit: pt.Unit}
+	}
+
+	mappings := make(map[uint64]*Mapping, len(jp.Mapping))
+	for _, jm := range jp.Mapping {
+		m := &Mapping{
+			ID:              jm.ID,
+			Start:           jm.MemoryStart,
+			Limit:           jm.MemoryLimit,
+			Offset:          jm.FileOffset,
+			File:            jm.Filename,
+			BuildID:         jm.BuildID,
+			HasFunctions:    jm.HasFunctions,
+			HasFilenames:    jm.HasFilenames,
+			HasLineNumbers:  jm.HasLineNumbers,
+			HasInlineFrames: jm.HasInlineFrames,
+		}
+		mappings[m.ID] = m
+		p.Mapping = append(p.Mapping, m)
+	}
+
+	functions := make(map[uint64]*Function, len(jp.Function))
+	for _, jf := range jp.Function {
+		f := &Function{
+			ID:         jf.ID,
+			Name:       jf.Name,
+			SystemName: jf.SystemName,
+			Filename:   jf.Filename,
+			StartLine:  jf.StartLine,
+		}
+		functions[f.ID] = f
+		p.Function = append(p.Function, f)
+	}
+
+	locations := make(map[uint64]*Location, len(jp.Location))
+	for _, jl := range jp.Location {
+		l := &Location{
+			ID:       jl.ID,
+			Address:  jl.Address,
+			IsFolded: jl.IsFolded,
+		}
+		if jl.MappingID != 0 {
+			if l.Mapping = mappings[jl.MappingID]; l.Mapping == nil {
+				return nil, fmt.Errorf("location %d: unknown mapping id %d", jl.ID, jl.MappingID)
+			}
+		}
+		for _, jln := range jl.Line {
+			ln := Line{Line: jln.Line}
+			if jln.FunctionID != 0 {
+				if ln.Function = functions[jln.FunctionID]; ln.Function == nil {
+					return nil, fmt.Errorf("location %d: unknown function id %d", jl.ID, jln.FunctionID)
+				}
+			}
+			l.Line = append(l.Line, ln)
+		}
+		locations[l.ID] = l
+		p.Location = append(p.Location, l)
+	}
+
+	for _, js := range jp.Sample {
+		s := &Sample{
+			Location: make([]*Location, len(js.LocationID)),
+			Value:    js.Value,
+		}
+		for i, id := range js.LocationID {
+			if s.Location[i] = locations[id]; s.Location[i] == nil {
+				return nil, fmt.Errorf("sample references unknown location id %d", id)
+			}
+		}
+		for _, l := range js.Label {
+			if l.Str != nil {
+				if s.Label == nil {
+					s.Label = make(map[string][]string)
+				}
+				s.Label[l.Key] = append(s.Label[l.Key], *l.Str)
+				continue
+			}
+			if s.NumLabel == nil {
+				s.NumLabel = make(map[string][]int64)
+			}
+			if l.NumUnit != "" {
+				if s.NumUnit == nil {
+					s.NumUnit = make(map[string][]string)
+				}
+				units := padStringArray(s.NumUnit[l.Key], len(s.NumLabel[l.Key]))
+				s.NumUnit[l.Key] = append(units, l.NumUnit)
+			}
+			s.NumLabel[l.Key] = append(s.NumLabel[l.Key], l.Num)
+		}
+		for k, units := range s.NumUnit {
+			s.NumUnit[k] = padStringArray(units, len(s.NumLabel[k]))
+		}
+		p.Sample = append(p.Sample, s)
+	}
+	return p, nil
+}
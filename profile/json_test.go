@@ -0,0 +1,107 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/lemonlinger/pprof/internal/proftest"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		prof *Profile
+	}{
+		{"testProfile1", testProfile1},
+		{"testProfile1NoMapping", testProfile1NoMapping},
+		{"testProfile4", testProfile4},
+		{"testProfile5", testProfile5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := tc.prof.Copy()
+			p.Comments = []string{"comment"}
+			p.DefaultSampleType = p.SampleType[0].Type
+
+			var buf bytes.Buffer
+			if err := p.WriteJSON(&buf); err != nil {
+				t.Fatalf("WriteJSON: %v", err)
+			}
+			for _, parse := range []struct {
+				name string
+				fn   func() (*Profile, error)
+			}{
+				{"ParseJSON", func() (*Profile, error) { return ParseJSON(bytes.NewReader(buf.Bytes())) }},
+				{"Parse", func() (*Profile, error) { return Parse(bytes.NewReader(buf.Bytes())) }},
+			} {
+				got, err := parse.fn()
+				if err != nil {
+					t.Fatalf("%s: %v", parse.name, err)
+				}
+				if got, want := got.String(), p.String(); got != want {
+					d, err := proftest.Diff([]byte(want), []byte(got))
+					if err != nil {
+						t.Fatal(err)
+					}
+					t.Errorf("%s: profile changed after JSON round trip:\n%s", parse.name, d)
+				}
+			}
+		})
+	}
+}
+
+func TestJSONPreservesIDs(t *testing.T) {
+	p := testProfile1.Copy()
+	p.Location[0].ID = 4242
+	p.Function[0].ID = 77
+
+	var buf bytes.Buffer
+	if err := p.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	got, err := ParseJSON(&buf)
+	if err != nil {
+		t.Fatalf("ParseJSON: %v", err)
+	}
+	if id := got.Location[0].ID; id != 4242 {
+		t.Errorf("got location id %d, want 4242", id)
+	}
+	if id := got.Location[0].Line[0].Function.ID; id != 77 {
+		t.Errorf("got function id %d, want 77", id)
+	}
+}
+
+func TestParseJSONError(t *testing.T) {
+	for _, tc := range []struct {
+		input, wantErr string
+	}{
+		{`{"sample": [`, "unexpected end of JSON input"},
+		{`{"sample_type": [{"type": "samples"}], "sample": [{"location_id": [1], "value": [1]}]}`, "unknown location id 1"},
+		{`{"location": [{"id": 1, "mapping_id": 2}]}`, "unknown mapping id 2"},
+		{`{"location": [{"id": 1, "line": [{"function_id": 3}]}]}`, "unknown function id 3"},
+		{`{"sample_type": [{"type": "samples"}], "sample": [{"value": [1, 2]}]}`, "sample has 2 values vs. 1 types"},
+	} {
+		_, err := ParseJSON(strings.NewReader(tc.input))
+		if err == nil {
+			t.Errorf("ParseJSON(%s): got no error, want %q", tc.input, tc.wantErr)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("ParseJSON(%s): got error %v, want %q", tc.input, err, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Categories of the issues reported by Lint.
+const (
+	LintHeader        = "header"
+	LintSamples       = "samples"
+	LintStacks        = "stacks"
+	LintSymbolization = "symbolization"
+	LintMappings      = "mappings"
+	LintLabels        = "labels"
+)
+
+// lintMinTruncatedDepth is the minimum depth of stacks considered to
+// be truncated by Lint when several samples share it as their maximum
+// depth, as collectors usually truncate stacks at 32 frames or more.
+const lintMinTruncatedDepth = 32
+
+// LintIssue is a quality problem of a profile found by Lint.
+type LintIssue struct {
+	Category string
+	Message  string
+	// Count is the number of entries of the profile affected, such as
+	// samples, locations or mappings, as described by the message.
+	Count int
+	// Samples are the samples affected, used to weigh the issue.
+	Samples []*Sample
+}
+
+// Lint checks p for problems that can lead to wrong conclusions when
+// analyzing it, such as unsymbolized entries, truncated stacks or mixed
+// binaries, and returns the issues found, ordered by category. It
+// returns no issues for a profile without problems. The profile is
+// expected to be valid, as checked by CheckValid.
+func (p *Profile) Lint() []*LintIssue {
+	var issues []*LintIssue
+	add := func(category string, count int, samples []*Sample, format string, args ...interface{}) {
+		if count > 0 {
+			issues = append(issues, &LintIssue{
+				Category: category,
+				Message:  fmt.Sprintf(format, args...),
+				Count:    count,
+				Samples:  samples,
+			})
+		}
+	}
+
+	// Header.
+	if len(p.SampleType) == 0 {
+		add(LintHeader, 1, nil, "profile has no sample types")
+	}
+	if p.PeriodType != nil && p.PeriodType.Type != "" && p.Period <= 0 {
+		add(LintHeader, 1, nil, "period of %s is %d, values cannot be converted to rates", p.PeriodType.Type, p.Period)
+	}
+
+	// Samples.
+	var zero, negative, base []*Sample
+	maxDepth, atMaxDepth := 0, []*Sample(nil)
+	for _, s := range p.Sample {
+		if isZeroSample(s) {
+			zero = append(zero, s)
+		}
+		if s.DiffBaseSample() {
+			base = append(base, s)
+		} else {
+			for _, v := range s.Value {
+				if v < 0 {
+					negative = append(negative, s)
+					break
+				}
+			}
+		}
+		switch d := len(s.Location); {
+		case d > maxDepth:
+			maxDepth, atMaxDepth = d, []*Sample{s}
+		case d == maxDepth:
+			atMaxDepth = append(atMaxDepth, s)
+		}
+	}
+	add(LintSamples, len(zero), zero, "%d samples have only zero values", len(zero))
+	add(LintSamples, len(negative), negative, "%d samples have negative values", len(negative))
+	add(LintLabels, len(base), base, "%d samples are labeled pprof::base, left over from a -diff_base comparison", len(base))
+	if maxDepth >= lintMinTruncatedDepth && len(atMaxDepth) > 1 {
+		add(LintStacks, len(atMaxDepth), atMaxDepth, "stacks of %d samples have exactly %d frames and may be truncated", len(atMaxDepth), maxDepth)
+	}
+
+	// Symbolization.
+	unsymbolized := make(map[*Location]bool)
+	unsymbolizedMappings := make(map[string]bool)
+	var noLines int
+	for _, l := range p.Location {
+		if m := l.Mapping; m != nil && !m.Unsymbolizable() && (!m.HasFunctions || len(l.Line) == 0) {
+			unsymbolized[l] = true
+			unsymbolizedMappings[m.File] = true
+		}
+		if m := l.Mapping; m != nil && m.HasFunctions && (!m.HasFilenames || !m.HasLineNumbers) {
+			noLines++
+		}
+	}
+	if len(unsymbolized) > 0 {
+		add(LintSymbolization, len(unsymbolized), samplesWith(p, func(l *Location) bool { return unsymbolized[l] }),
+			"%d locations have no function information, in %s", len(unsymbolized), fileList(unsymbolizedMappings))
+	}
+	if noLines > 0 && !p.HasFileLines() {
+		add(LintSymbolization, noLines, nil, "%d symbolized locations have no file and line information", noLines)
+	}
+
+	// Mappings.
+	buildIDs := make(map[string]map[string]bool)
+	for _, m := range p.Mapping {
+		if m.File == "" || m.BuildID == "" {
+			continue
+		}
+		if buildIDs[m.File] == nil {
+			buildIDs[m.File] = make(map[string]bool)
+		}
+		buildIDs[m.File][m.BuildID] = true
+	}
+	var files []string
+	for file, ids := range buildIDs {
+		if len(ids) > 1 {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		file := file
+		add(LintMappings, len(buildIDs[file]), samplesWith(p, func(l *Location) bool { return l.Mapping != nil && l.Mapping.File == file }),
+			"%d different build IDs for %s, samples of different binaries are mixed", len(buildIDs[file]), file)
+	}
+
+	order := map[string]int{
+		LintHeader: 0, LintSamples: 1, LintStacks: 2,
+		LintSymbolization: 3, LintMappings: 4, LintLabels: 5,
+	}
+	sort.SliceStable(issues, func(i, j int) bool {
+		return order[issues[i].Category] < order[issues[j].Category]
+	})
+	return issues
+}
+
+// samplesWith returns the samples of p with a location for which
+// match returns true.
+func samplesWith(p *Profile, match func(*Location) bool) []*Sample {
+	var samples []*Sample
+	for _, s := range p.Sample {
+		for _, l := range s.Location {
+			if match(l) {
+				samples = append(samples, s)
+				break
+			}
+		}
+	}
+	return samples
+}
+
+// fileList returns a short description of the files in files.
+func fileList(files map[string]bool) string {
+	const max = 3
+	var names []string
+	for f := range files {
+		if f == "" {
+			f = "<unknown>"
+		}
+		names = append(names, f)
+	}
+	sort.Strings(names)
+	if len(names) > max {
+		return fmt.Sprintf("%s and %d other mappings", strings.Join(names[:max], ", "), len(names)-max)
+	}
+	return strings.Join(names, ", ")
+}
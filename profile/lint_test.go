@@ -0,0 +1,117 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func lintTestProfile() *Profile {
+	m := &Mapping{ID: 1, File: "/bin/app", BuildID: "v1", HasFunctions: true, HasFilenames: true, HasLineNumbers: true}
+	f := &Function{ID: 1, Name: "main", Filename: "main.go"}
+	l := &Location{ID: 1, Mapping: m, Address: 0x1000, Line: []Line{{Function: f, Line: 1}}}
+	return &Profile{
+		SampleType: []*ValueType{{Type: "samples", Unit: "count"}},
+		PeriodType: &ValueType{Type: "cpu", Unit: "nanoseconds"},
+		Period:     10000000,
+		Sample:     []*Sample{{Location: []*Location{l}, Value: []int64{10}}},
+		Mapping:    []*Mapping{m},
+		Location:   []*Location{l},
+		Function:   []*Function{f},
+	}
+}
+
+func TestLint(t *testing.T) {
+	if issues := lintTestProfile().Lint(); len(issues) != 0 {
+		t.Errorf("Lint of a clean profile: got %d issues, first %q", len(issues), issues[0].Message)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		corrupt func(p *Profile)
+		want    []string // Categories and messages.
+		samples []int    // Number of samples of each issue.
+	}{
+		{
+			name: "zero period and samples",
+			corrupt: func(p *Profile) {
+				p.Period = 0
+				p.Sample = append(p.Sample, &Sample{Location: p.Sample[0].Location, Value: []int64{0}})
+			},
+			want: []string{
+				"header: period of cpu is 0, values cannot be converted to rates",
+				"samples: 1 samples have only zero values",
+			},
+			samples: []int{0, 1},
+		},
+		{
+			name: "diff base leftovers",
+			corrupt: func(p *Profile) {
+				p.Sample = append(p.Sample, &Sample{Location: p.Sample[0].Location, Value: []int64{-5}, Label: map[string][]string{"pprof::base": {"true"}}})
+			},
+			want:    []string{"labels: 1 samples are labeled pprof::base, left over from a -diff_base comparison"},
+			samples: []int{1},
+		},
+		{
+			name: "truncated stacks",
+			corrupt: func(p *Profile) {
+				var stack []*Location
+				for i := 0; i < 64; i++ {
+					stack = append(stack, p.Location[0])
+				}
+				p.Sample = append(p.Sample,
+					&Sample{Location: stack, Value: []int64{1}},
+					&Sample{Location: stack, Value: []int64{2}})
+			},
+			want:    []string{"stacks: stacks of 2 samples have exactly 64 frames and may be truncated"},
+			samples: []int{2},
+		},
+		{
+			name: "unsymbolized and mixed mappings",
+			corrupt: func(p *Profile) {
+				m := &Mapping{ID: 2, File: "/bin/app", BuildID: "v2"}
+				l := &Location{ID: 2, Mapping: m, Address: 0x2000}
+				vdso := &Mapping{ID: 3, File: "[vdso]"}
+				lv := &Location{ID: 3, Mapping: vdso, Address: 0x3000}
+				p.Mapping = append(p.Mapping, m, vdso)
+				p.Location = append(p.Location, l, lv)
+				p.Sample = append(p.Sample, &Sample{Location: []*Location{l, lv}, Value: []int64{3}})
+			},
+			want: []string{
+				"symbolization: 1 locations have no function information, in /bin/app",
+				"mappings: 2 different build IDs for /bin/app, samples of different binaries are mixed",
+			},
+			samples: []int{1, 2},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := lintTestProfile()
+			tc.corrupt(p)
+			var got []string
+			var samples []int
+			for _, issue := range p.Lint() {
+				got = append(got, issue.Category+": "+issue.Message)
+				samples = append(samples, len(issue.Samples))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got issues %q, want %q", got, tc.want)
+			}
+			if !reflect.DeepEqual(samples, tc.samples) {
+				t.Errorf("got %v affected samples, want %v", samples, tc.samples)
+			}
+		})
+	}
+}
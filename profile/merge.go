@@ -15,10 +15,14 @@
 package profile
 
 import (
+	"encoding/binary"
 	"fmt"
+	"io"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 // Compact performs garbage collection on a profile to remove any
@@ -40,39 +44,49 @@ func Merge(srcs []*Profile) (*Profile, error) {
 	if len(srcs) == 0 {
 		return nil, fmt.Errorf("no profiles to merge")
 	}
-	p, err := combineHeaders(srcs)
-	if err != nil {
-		return nil, err
-	}
 
-	pm := &profileMerger{
-		p:         p,
-		samples:   make(map[sampleKey]*Sample, len(srcs[0].Sample)),
-		locations: make(map[locationKey]*Location, len(srcs[0].Location)),
-		functions: make(map[functionKey]*Function, len(srcs[0].Function)),
-		mappings:  make(map[mappingKey]*Mapping, len(srcs[0].Mapping)),
+	// Merge contiguous shards of the profiles in parallel, and then the
+	// results of the shards in order, which produces the same profile
+	// as merging all profiles sequentially.
+	shards := runtime.GOMAXPROCS(0)
+	if max := len(srcs) / mergeShardMinProfiles; shards > max {
+		shards = max
+	}
+	if shards > 1 {
+		partial := make([]*Profile, shards)
+		errs := make([]error, shards)
+		var wg sync.WaitGroup
+		for i := range partial {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				m := NewMerger()
+				for _, src := range srcs[i*len(srcs)/shards : (i+1)*len(srcs)/shards] {
+					if errs[i] = m.add(src, true); errs[i] != nil {
+						return
+					}
+				}
+				partial[i] = m.pm.p
+			}(i)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				return nil, err
+			}
+		}
+		srcs = partial
 	}
 
+	m := NewMerger()
 	for _, src := range srcs {
-		// Clear the profile-specific hash tables
-		pm.locationsByID = make(map[uint64]*Location, len(src.Location))
-		pm.functionsByID = make(map[uint64]*Function, len(src.Function))
-		pm.mappingsByID = make(map[uint64]mapInfo, len(src.Mapping))
-
-		if len(pm.mappings) == 0 && len(src.Mapping) > 0 {
-			// The Mapping list has the property that the first mapping
-			// represents the main binary. Take the first Mapping we see,
-			// otherwise the operations below will add mappings in an
-			// arbitrary order.
-			pm.mapMapping(src.Mapping[0])
-		}
-
-		for _, s := range src.Sample {
-			if !isZeroSample(s) {
-				pm.mapSample(s)
-			}
+		// Samples of shards that add up to zero are kept until the end,
+		// so that samples are ordered as in a sequential merge.
+		if err := m.add(src, shards <= 1); err != nil {
+			return nil, err
 		}
 	}
+	p := m.pm.p
 
 	for _, s := range p.Sample {
 		if isZeroSample(s) {
@@ -85,6 +99,158 @@ func Merge(srcs []*Profile) (*Profile, error) {
 	return p, nil
 }
 
+// mergeShardMinProfiles is the minimum number of profiles merged by
+// each goroutine of Merge.
+const mergeShardMinProfiles = 8
+
+// Merger merges profiles incrementally, so that profiles can be merged
+// as they become available, for example as they are fetched, without
+// keeping all of them in memory. The merged profile is the same as
+// returned by Merge for the profiles added, in the same order.
+//
+// A Merger is safe for concurrent use.
+type Merger struct {
+	mu           sync.Mutex
+	pm           *profileMerger
+	count        int
+	seenComments map[string]bool
+}
+
+// NewMerger returns a Merger with no profiles.
+func NewMerger() *Merger {
+	return &Merger{
+		pm: &profileMerger{
+			p:         &Profile{},
+			samples:   make(map[string]*Sample),
+			locations: make(map[string]*Location),
+			functions: make(map[functionKey]*Function),
+			mappings:  make(map[mappingKey]*Mapping),
+			strings:   make(map[string]uint64),
+		},
+		seenComments: make(map[string]bool),
+	}
+}
+
+// Add merges p into the profile of m. It returns an error if p is not
+// compatible with the profiles already added, as Merge does. The
+// merger does not keep references to p.
+func (m *Merger) Add(p *Profile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.add(p, true)
+}
+
+// AddSamples merges the profile read by sr into the profile of m, as
+// Add does, reading its samples one at a time. Only the distinct
+// samples of the merged profile are kept in memory, so profiles too
+// large to be parsed can be merged. If reading a sample fails, the
+// samples read before it remain merged.
+func (m *Merger) AddSamples(sr *SampleReader) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.begin(sr.Header()); err != nil {
+		return err
+	}
+	for {
+		s, err := sr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !isZeroSample(s) {
+			m.pm.mapSample(s)
+		}
+	}
+}
+
+// Profile returns the merge of the profiles added so far. It returns a
+// new profile independent of m, which can still be used to add more
+// profiles.
+func (m *Merger) Profile() (*Profile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.count == 0 {
+		return nil, fmt.Errorf("no profiles to merge")
+	}
+	c := NewMerger()
+	if err := c.add(m.pm.p, true); err != nil {
+		return nil, err
+	}
+	return c.pm.p, nil
+}
+
+// add merges src into the profile of m. Samples whose values are all
+// zero are only merged if skipZero is false.
+func (m *Merger) add(src *Profile, skipZero bool) error {
+	if err := m.begin(src); err != nil {
+		return err
+	}
+	for _, s := range src.Sample {
+		if !skipZero || !isZeroSample(s) {
+			m.pm.mapSample(s)
+		}
+	}
+	return nil
+}
+
+// begin prepares m to merge the samples of src, once its header is
+// merged.
+func (m *Merger) begin(src *Profile) error {
+	if err := m.addHeader(src); err != nil {
+		return err
+	}
+	pm := m.pm
+
+	// Clear the profile-specific hash tables
+	pm.locationsByID = make(map[uint64]*Location, len(src.Location))
+	pm.functionsByID = make(map[uint64]*Function, len(src.Function))
+	pm.mappingsByID = make(map[uint64]mapInfo, len(src.Mapping))
+
+	if len(pm.mappings) == 0 && len(src.Mapping) > 0 {
+		// The Mapping list has the property that the first mapping
+		// represents the main binary. Take the first Mapping we see,
+		// otherwise the operations below will add mappings in an
+		// arbitrary order.
+		pm.mapMapping(src.Mapping[0])
+	}
+	return nil
+}
+
+// addHeader checks that src can be merged with the profiles already
+// added to m and combines its header fields into the merged profile.
+func (m *Merger) addHeader(src *Profile) error {
+	p := m.pm.p
+	if m.count == 0 {
+		p.SampleType = make([]*ValueType, len(src.SampleType))
+		copy(p.SampleType, src.SampleType)
+		p.DropFrames, p.KeepFrames = src.DropFrames, src.KeepFrames
+		p.PeriodType = src.PeriodType
+	} else if err := p.compatible(src); err != nil {
+		return err
+	}
+	m.count++
+
+	if p.TimeNanos == 0 || src.TimeNanos < p.TimeNanos {
+		p.TimeNanos = src.TimeNanos
+	}
+	p.DurationNanos += src.DurationNanos
+	if p.Period == 0 || p.Period < src.Period {
+		p.Period = src.Period
+	}
+	for _, c := range src.Comments {
+		if !m.seenComments[c] {
+			p.Comments = append(p.Comments, c)
+			m.seenComments[c] = true
+		}
+	}
+	if p.DefaultSampleType == "" {
+		p.DefaultSampleType = src.DefaultSampleType
+	}
+	return nil
+}
+
 // Normalize normalizes the source profile by multiplying each value in profile by the
 // ratio of the sum of the base profile's values of that sample type to the sum of the
 // source profile's value of that sample type.
@@ -137,11 +303,25 @@ type profileMerger struct {
 	functionsByID map[uint64]*Function
 	mappingsByID  map[uint64]mapInfo
 
-	// Memoization tables for profile entities.
-	samples   map[sampleKey]*Sample
-	locations map[locationKey]*Location
+	// Memoization tables for profile entities. Samples and locations
+	// are keyed by the encoding of their fields built by sampleKey and
+	// locationKey.
+	samples   map[string]*Sample
+	locations map[string]*Location
 	functions map[functionKey]*Function
 	mappings  map[mappingKey]*Mapping
+
+	// strings interns the label keys, values and units of samples, so
+	// that they are encoded in sample keys by a number.
+	strings map[string]uint64
+
+	// Buffers reused across samples and locations to avoid allocating
+	// for those already merged.
+	keyBuf    []byte
+	labelBuf  []string
+	locBuf    []*Location
+	lineBuf   []Line
+	varintBuf [binary.MaxVarintLen64]byte
 }
 
 type mapInfo struct {
@@ -150,16 +330,30 @@ type mapInfo struct {
 }
 
 func (pm *profileMerger) mapSample(src *Sample) *Sample {
+	locs := pm.locBuf[:0]
+	for _, l := range src.Location {
+		locs = append(locs, pm.mapLocation(l))
+	}
+	pm.locBuf = locs
+	// Check memoization table. Must be done on the remapped location to
+	// account for the remapped mapping. Add current values to the
+	// existing sample.
+	k := pm.sampleKey(locs, src)
+	if ss, ok := pm.samples[string(k)]; ok {
+		for i, v := range src.Value {
+			ss.Value[i] += v
+		}
+		return ss
+	}
+
 	s := &Sample{
-		Location: make([]*Location, len(src.Location)),
+		Location: make([]*Location, len(locs)),
 		Value:    make([]int64, len(src.Value)),
 		Label:    make(map[string][]string, len(src.Label)),
 		NumLabel: make(map[string][]int64, len(src.NumLabel)),
 		NumUnit:  make(map[string][]string, len(src.NumLabel)),
 	}
-	for i, l := range src.Location {
-		s.Location[i] = pm.mapLocation(l)
-	}
+	copy(s.Location, locs)
 	for k, v := range src.Label {
 		vv := make([]string, len(v))
 		copy(vv, v)
@@ -174,22 +368,79 @@ func (pm *profileMerger) mapSample(src *Sample) *Sample {
 		s.NumLabel[k] = vv
 		s.NumUnit[k] = uu
 	}
-	// Check memoization table. Must be done on the remapped location to
-	// account for the remapped mapping. Add current values to the
-	// existing sample.
-	k := s.key()
-	if ss, ok := pm.samples[k]; ok {
-		for i, v := range src.Value {
-			ss.Value[i] += v
-		}
-		return ss
-	}
 	copy(s.Value, src.Value)
-	pm.samples[k] = s
+	pm.samples[string(k)] = s
 	pm.p.Sample = append(pm.p.Sample, s)
 	return s
 }
 
+// sampleKey returns the key of the sample src with its locations
+// mapped to locs. The key encodes the IDs of the locations and the
+// interned labels of the sample, and is only valid until the next call.
+func (pm *profileMerger) sampleKey(locs []*Location, src *Sample) []byte {
+	b := pm.appendUint(pm.keyBuf[:0], uint64(len(locs)))
+	for _, l := range locs {
+		var id uint64
+		if l != nil {
+			id = l.ID
+		}
+		b = pm.appendUint(b, id)
+	}
+
+	keys := pm.labelBuf[:0]
+	for k := range src.Label {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b = pm.appendUint(b, uint64(len(keys)))
+	for _, k := range keys {
+		v := src.Label[k]
+		b = pm.appendUint(b, pm.intern(k))
+		b = pm.appendUint(b, uint64(len(v)))
+		for _, s := range v {
+			b = pm.appendUint(b, pm.intern(s))
+		}
+	}
+
+	keys = keys[:0]
+	for k := range src.NumLabel {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b = pm.appendUint(b, uint64(len(keys)))
+	for _, k := range keys {
+		v, u := src.NumLabel[k], src.NumUnit[k]
+		b = pm.appendUint(b, pm.intern(k))
+		b = pm.appendUint(b, uint64(len(v)))
+		for _, n := range v {
+			b = pm.appendUint(b, uint64(n))
+		}
+		b = pm.appendUint(b, uint64(len(u)))
+		for _, s := range u {
+			b = pm.appendUint(b, pm.intern(s))
+		}
+	}
+	pm.labelBuf = keys
+	pm.keyBuf = b
+	return b
+}
+
+// intern returns the number identifying s in the keys of pm.
+func (pm *profileMerger) intern(s string) uint64 {
+	if id, ok := pm.strings[s]; ok {
+		return id
+	}
+	id := uint64(len(pm.strings))
+	pm.strings[s] = id
+	return id
+}
+
+// appendUint appends the varint encoding of v to b.
+func (pm *profileMerger) appendUint(b []byte, v uint64) []byte {
+	n := binary.PutUvarint(pm.varintBuf[:], v)
+	return append(b, pm.varintBuf[:n]...)
+}
+
 // key generates sampleKey to be used as a key for maps.
 func (sample *Sample) key() sampleKey {
 	ids := make([]string, len(sample.Location))
@@ -228,60 +479,63 @@ func (pm *profileMerger) mapLocation(src *Location) *Location {
 	}
 
 	if l, ok := pm.locationsByID[src.ID]; ok {
-		pm.locationsByID[src.ID] = l
 		return l
 	}
 
 	mi := pm.mapMapping(src.Mapping)
-	l := &Location{
-		ID:       uint64(len(pm.p.Location) + 1),
-		Mapping:  mi.m,
-		Address:  uint64(int64(src.Address) + mi.offset),
-		Line:     make([]Line, len(src.Line)),
-		IsFolded: src.IsFolded,
-	}
-	for i, ln := range src.Line {
-		l.Line[i] = pm.mapLine(ln)
+	addr := uint64(int64(src.Address) + mi.offset)
+	lines := pm.lineBuf[:0]
+	for _, ln := range src.Line {
+		lines = append(lines, pm.mapLine(ln))
 	}
+	pm.lineBuf = lines
 	// Check memoization table. Must be done on the remapped location to
 	// account for the remapped mapping ID.
-	k := l.key()
-	if ll, ok := pm.locations[k]; ok {
+	k := pm.locationKey(mi.m, addr, lines, src.IsFolded)
+	if ll, ok := pm.locations[string(k)]; ok {
 		pm.locationsByID[src.ID] = ll
 		return ll
 	}
+	l := &Location{
+		ID:       uint64(len(pm.p.Location) + 1),
+		Mapping:  mi.m,
+		Address:  addr,
+		Line:     make([]Line, len(lines)),
+		IsFolded: src.IsFolded,
+	}
+	copy(l.Line, lines)
 	pm.locationsByID[src.ID] = l
-	pm.locations[k] = l
+	pm.locations[string(k)] = l
 	pm.p.Location = append(pm.p.Location, l)
 	return l
 }
 
-// key generates locationKey to be used as a key for maps.
-func (l *Location) key() locationKey {
-	key := locationKey{
-		addr:     l.Address,
-		isFolded: l.IsFolded,
-	}
-	if l.Mapping != nil {
+// locationKey returns the key of a location with the given mapping,
+// address, lines and folding. It is only valid until the next call.
+func (pm *profileMerger) locationKey(m *Mapping, addr uint64, lines []Line, isFolded bool) []byte {
+	var mappingID uint64
+	if m != nil {
 		// Normalizes address to handle address space randomization.
-		key.addr -= l.Mapping.Start
-		key.mappingID = l.Mapping.ID
-	}
-	lines := make([]string, len(l.Line)*2)
-	for i, line := range l.Line {
+		addr -= m.Start
+		mappingID = m.ID
+	}
+	b := pm.appendUint(pm.keyBuf[:0], addr)
+	b = pm.appendUint(b, mappingID)
+	if isFolded {
+		b = append(b, 1)
+	} else {
+		b = append(b, 0)
+	}
+	for _, line := range lines {
+		var id uint64
 		if line.Function != nil {
-			lines[i*2] = strconv.FormatUint(line.Function.ID, 16)
+			id = line.Function.ID
 		}
-		lines[i*2+1] = strconv.FormatInt(line.Line, 16)
+		b = pm.appendUint(b, id)
+		b = pm.appendUint(b, uint64(line.Line))
 	}
-	key.lines = strings.Join(lines, "|")
-	return key
-}
-
-type locationKey struct {
-	addr, mappingID uint64
-	lines           string
-	isFolded        bool
+	pm.keyBuf = b
+	return b
 }
 
 func (pm *profileMerger) mapMapping(src *Mapping) mapInfo {
@@ -402,56 +656,6 @@ type functionKey struct {
 	name, systemName, fileName string
 }
 
-// combineHeaders checks that all profiles can be merged and returns
-// their combined profile.
-func combineHeaders(srcs []*Profile) (*Profile, error) {
-	for _, s := range srcs[1:] {
-		if err := srcs[0].compatible(s); err != nil {
-			return nil, err
-		}
-	}
-
-	var timeNanos, durationNanos, period int64
-	var comments []string
-	seenComments := map[string]bool{}
-	var defaultSampleType string
-	for _, s := range srcs {
-		if timeNanos == 0 || s.TimeNanos < timeNanos {
-			timeNanos = s.TimeNanos
-		}
-		durationNanos += s.DurationNanos
-		if period == 0 || period < s.Period {
-			period = s.Period
-		}
-		for _, c := range s.Comments {
-			if seen := seenComments[c]; !seen {
-				comments = append(comments, c)
-				seenComments[c] = true
-			}
-		}
-		if defaultSampleType == "" {
-			defaultSampleType = s.DefaultSampleType
-		}
-	}
-
-	p := &Profile{
-		SampleType: make([]*ValueType, len(srcs[0].SampleType)),
-
-		DropFrames: srcs[0].DropFrames,
-		KeepFrames: srcs[0].KeepFrames,
-
-		TimeNanos:     timeNanos,
-		DurationNanos: durationNanos,
-		PeriodType:    srcs[0].PeriodType,
-		Period:        period,
-
-		Comments:          comments,
-		DefaultSampleType: defaultSampleType,
-	}
-	copy(p.SampleType, srcs[0].SampleType)
-	return p, nil
-}
-
 // compatible determines if two profiles can be compared/merged.
 // returns nil if the profiles are compatible; otherwise an error with
 // details on the incompatibility.
@@ -472,6 +676,90 @@ func (p *Profile) compatible(pb *Profile) error {
 	return nil
 }
 
+// UnionSampleTypes returns the sample types present in any of the
+// profiles, identified by type and unit, in order of appearance. Along
+// with SetSampleTypes, it can be used to merge profiles with different
+// sample types.
+func UnionSampleTypes(profs []*Profile) []*ValueType {
+	var types []*ValueType
+	seen := make(map[ValueType]bool)
+	for _, p := range profs {
+		for _, st := range p.SampleType {
+			k := ValueType{Type: st.Type, Unit: st.Unit}
+			if !seen[k] {
+				seen[k] = true
+				types = append(types, &ValueType{Type: st.Type, Unit: st.Unit})
+			}
+		}
+	}
+	return types
+}
+
+// IntersectSampleTypes returns the sample types present in all the
+// profiles, identified by type and unit, in the order of the first
+// profile.
+func IntersectSampleTypes(profs []*Profile) []*ValueType {
+	count := make(map[ValueType]int)
+	for _, p := range profs {
+		seen := make(map[ValueType]bool)
+		for _, st := range p.SampleType {
+			k := ValueType{Type: st.Type, Unit: st.Unit}
+			if !seen[k] {
+				seen[k] = true
+				count[k]++
+			}
+		}
+	}
+	var types []*ValueType
+	for _, st := range UnionSampleTypes(profs) {
+		if count[*st] == len(profs) {
+			types = append(types, st)
+		}
+	}
+	return types
+}
+
+// SetSampleTypes changes the sample types of p to types, reordering
+// the values of its samples to match. Sample types are identified by
+// type and unit. Values of sample types of p not in types are dropped,
+// and values of sample types missing from p are set to zero.
+func (p *Profile) SetSampleTypes(types []*ValueType) {
+	index := make(map[ValueType]int, len(p.SampleType))
+	for i, st := range p.SampleType {
+		k := ValueType{Type: st.Type, Unit: st.Unit}
+		if _, ok := index[k]; !ok {
+			index[k] = i
+		}
+	}
+	from := make([]int, len(types))
+	for i, st := range types {
+		if j, ok := index[ValueType{Type: st.Type, Unit: st.Unit}]; ok {
+			from[i] = j
+		} else {
+			from[i] = -1
+		}
+	}
+	for _, s := range p.Sample {
+		values := make([]int64, len(types))
+		for i, j := range from {
+			if j >= 0 && j < len(s.Value) {
+				values[i] = s.Value[j]
+			}
+		}
+		s.Value = values
+	}
+
+	p.SampleType = make([]*ValueType, len(types))
+	var hasDefault bool
+	for i, st := range types {
+		p.SampleType[i] = &ValueType{Type: st.Type, Unit: st.Unit}
+		hasDefault = hasDefault || st.Type == p.DefaultSampleType
+	}
+	if !hasDefault {
+		p.DefaultSampleType = ""
+	}
+}
+
 // equalValueType returns true if the two value types are semantically
 // equal. It ignores the internal fields used during encode/decode.
 func equalValueType(st1, st2 *ValueType) bool {
@@ -15,6 +15,10 @@
 package profile
 
 import (
+	"reflect"
+	"runtime"
+	"strconv"
+	"sync"
 	"testing"
 )
 
@@ -165,3 +169,96 @@ func TestMapMapping(t *testing.T) {
 		})
 	}
 }
+
+// mergeTestProfiles returns profiles with the same stacks, and with
+// different values and labels.
+func mergeTestProfiles(n int) []*Profile {
+	profs := make([]*Profile, n)
+	for i := range profs {
+		p := testProfile1.Copy()
+		p.Scale(float64(i%5 + 1))
+		p.SetLabel("shard", []string{strconv.Itoa(i % 3)})
+		p.Comments = []string{"profile " + strconv.Itoa(i%2)}
+		p.DurationNanos = 10
+		profs[i] = p
+	}
+	return profs
+}
+
+func TestParallelMerge(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	profs := mergeTestProfiles(40)
+	sequential := NewMerger()
+	for _, p := range profs {
+		if err := sequential.add(p, true); err != nil {
+			t.Fatalf("add: %v", err)
+		}
+	}
+	want := sequential.pm.p
+
+	got, err := Merge(profs)
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if got.String() != want.String() {
+		t.Errorf("parallel merge got\n%s\nwant\n%s", got, want)
+	}
+	if got.DurationNanos != 400 {
+		t.Errorf("got duration %d, want 400", got.DurationNanos)
+	}
+	if want := []string{"profile 0", "profile 1"}; !reflect.DeepEqual(got.Comments, want) {
+		t.Errorf("got comments %v, want %v", got.Comments, want)
+	}
+}
+
+func TestMerger(t *testing.T) {
+	m := NewMerger()
+	if _, err := m.Profile(); err == nil {
+		t.Errorf("Profile of an empty merger: got no error")
+	}
+
+	profs := mergeTestProfiles(20)
+	var wg sync.WaitGroup
+	for _, p := range profs {
+		wg.Add(1)
+		go func(p *Profile) {
+			defer wg.Done()
+			if err := m.Add(p); err != nil {
+				t.Errorf("Add: %v", err)
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	got, err := m.Profile()
+	if err != nil {
+		t.Fatalf("Profile: %v", err)
+	}
+	if err := got.CheckValid(); err != nil {
+		t.Fatalf("merged profile is invalid: %v", err)
+	}
+	want, err := Merge(profs)
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if g, w := sampleSummary(got), sampleSummary(want); !reflect.DeepEqual(g, w) {
+		t.Errorf("incremental merge got samples\n%v\nwant\n%v", g, w)
+	}
+
+	// The merger can still be used, and does not change the profiles
+	// it returned.
+	summary := sampleSummary(got)
+	if err := m.Add(profs[0]); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if !reflect.DeepEqual(sampleSummary(got), summary) {
+		t.Errorf("Add changed a profile returned by Profile")
+	}
+
+	incompatible := testProfile1.Copy()
+	incompatible.SampleType = incompatible.SampleType[:1]
+	if err := m.Add(incompatible); err == nil {
+		t.Errorf("Add of an incompatible profile: got no error")
+	}
+}
@@ -0,0 +1,1409 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file converts between profiles and the OpenTelemetry profiles
+// signal (OTLP), as described by the ProfilesData message of
+// opentelemetry/proto/profiles/v1development/profiles.proto.
+//
+// OTLP keeps mappings, locations, functions, stacks, links, attributes
+// and strings in a dictionary shared by all the profiles of a
+// ProfilesData message, and each OTLP profile has a single sample type.
+// A profile.Profile with N sample types is written as N OTLP profiles
+// with identical samples. When reading, OTLP profiles of the same scope
+// that share their time, duration and period are combined back into a
+// single profile.Profile with one sample type per OTLP profile.
+//
+// Sample labels map to attributes: string labels to string attributes
+// and numeric labels to integer attributes carrying the label unit.
+// Resource and profile attributes are added as labels to every sample.
+// A sample link to a trace is represented by the "trace_id" and
+// "span_id" labels, holding hex-encoded IDs. The timestamps of a sample
+// map to the numeric "timestamp" label, in nanoseconds.
+//
+// Location.IsFolded, Profile.Comments, DropFrames, KeepFrames and
+// DefaultSampleType have no OTLP equivalent and are not preserved.
+
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Labels holding the trace and span IDs of the OTLP link of a sample.
+const (
+	otlpTraceIDLabel = "trace_id"
+	otlpSpanIDLabel  = "span_id"
+)
+
+var errOTLPNoProfiles = errors.New("no profiles found")
+
+// Attributes used by OpenTelemetry semantic conventions for the build
+// ID of a mapping. The first one is used when writing.
+var otlpBuildIDAttributes = []string{
+	"process.executable.build_id.gnu",
+	"process.executable.build_id.go",
+	"process.executable.build_id.htlhash",
+}
+
+// ParseOTLP parses an OTLP ProfilesData message, optionally
+// gzip-compressed, and returns the profiles it contains, each checked
+// for validity.
+func ParseOTLP(data []byte) ([]*Profile, error) {
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(bytes.NewBuffer(data))
+		if err == nil {
+			data, err = ioutil.ReadAll(gz)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decompressing profile: %v", err)
+		}
+	}
+	if len(data) == 0 {
+		return nil, errNoData
+	}
+	pd := &otlpProfilesData{}
+	if err := unmarshal(data, pd); err != nil {
+		return nil, fmt.Errorf("parsing OTLP profiles: %v", err)
+	}
+	profs, err := pd.toProfiles()
+	if err != nil {
+		return nil, fmt.Errorf("parsing OTLP profiles: %v", err)
+	}
+	if len(profs) == 0 {
+		return nil, fmt.Errorf("parsing OTLP profiles: %v", errOTLPNoProfiles)
+	}
+	for _, p := range profs {
+		if err := p.CheckValid(); err != nil {
+			return nil, fmt.Errorf("malformed profile: %v", err)
+		}
+	}
+	return profs, nil
+}
+
+// WriteOTLP writes the profiles as an uncompressed OTLP ProfilesData
+// message holding a single resource and scope.
+func WriteOTLP(w io.Writer, profs []*Profile) error {
+	pd := newOTLPWriter().profilesData(profs)
+	_, err := w.Write(marshal(pd))
+	return err
+}
+
+// OTLP messages. Only the fields relevant to pprof are represented;
+// any other field is skipped when decoding.
+
+type otlpProfilesData struct {
+	resourceProfiles []*otlpResourceProfiles // 1
+	dictionary       *otlpDictionary         // 2
+}
+
+type otlpResourceProfiles struct {
+	resource      *otlpResource        // 1
+	scopeProfiles []*otlpScopeProfiles // 2
+}
+
+type otlpResource struct {
+	attributes []*otlpKeyValue // 1
+}
+
+type otlpScopeProfiles struct {
+	profiles []*otlpProfile // 2
+}
+
+type otlpProfile struct {
+	sampleType       []*otlpValueType // 1
+	samples          []*otlpSample    // 2
+	timeUnixNano     uint64           // 3, fixed64
+	durationNano     uint64           // 4
+	periodType       *otlpValueType   // 5
+	period           int64            // 6
+	attributeIndices []int64          // 11
+}
+
+type otlpValueType struct {
+	typeX int64 // 1
+	unitX int64 // 2
+}
+
+type otlpSample struct {
+	stackIndex         int64    // 1
+	values             []int64  // 2
+	attributeIndices   []int64  // 3
+	linkIndex          int64    // 4
+	timestampsUnixNano []uint64 // 5
+}
+
+type otlpDictionary struct {
+	mappings   []*otlpMapping   // 1
+	locations  []*otlpLocation  // 2
+	functions  []*otlpFunction  // 3
+	links      []*otlpLink      // 4
+	strings    []string         // 5
+	attributes []*otlpAttribute // 6
+	stacks     []*otlpStack     // 7
+}
+
+type otlpMapping struct {
+	memoryStart      uint64  // 1
+	memoryLimit      uint64  // 2
+	fileOffset       uint64  // 3
+	filenameX        int64   // 4
+	attributeIndices []int64 // 5
+}
+
+type otlpLocation struct {
+	mappingIndex int64       // 1
+	address      uint64      // 2
+	lines        []*otlpLine // 3
+}
+
+type otlpLine struct {
+	functionIndex int64 // 1
+	line          int64 // 2
+}
+
+type otlpFunction struct {
+	nameX       int64 // 1
+	systemNameX int64 // 2
+	filenameX   int64 // 3
+	startLine   int64 // 4
+}
+
+type otlpStack struct {
+	locationIndices []int64 // 1
+}
+
+type otlpLink struct {
+	traceID []byte // 1
+	spanID  []byte // 2
+}
+
+// otlpAttribute corresponds to KeyValueAndUnit.
+type otlpAttribute struct {
+	keyX  int64         // 1
+	value *otlpAnyValue // 2
+	unitX int64         // 3
+}
+
+type otlpKeyValue struct {
+	key   string        // 1
+	value *otlpAnyValue // 2
+}
+
+// otlpAnyValue holds one of a string, bool, int or double value.
+// Arrays, key-value lists and bytes are not supported.
+type otlpAnyValue struct {
+	kind   int // 0 if unset, else the field number of the value.
+	str    string
+	num    int64
+	double float64
+}
+
+const (
+	otlpStringValue = 1
+	otlpBoolValue   = 2
+	otlpIntValue    = 3
+	otlpDoubleValue = 4
+)
+
+// String returns the value formatted as a label value.
+func (v *otlpAnyValue) String() string {
+	switch v.kind {
+	case otlpBoolValue:
+		return strconv.FormatBool(v.num != 0)
+	case otlpIntValue:
+		return strconv.FormatInt(v.num, 10)
+	case otlpDoubleValue:
+		return strconv.FormatFloat(v.double, 'g', -1, 64)
+	}
+	return v.str
+}
+
+// toProfiles converts the OTLP profiles into profiles, combining the
+// profiles of a scope that share their time, duration and period.
+func (pd *otlpProfilesData) toProfiles() ([]*Profile, error) {
+	d := pd.dictionary
+	if d == nil {
+		d = &otlpDictionary{}
+	}
+	c := &otlpConverter{d: d, tables: make(map[*Profile]*otlpTables)}
+	var profs []*Profile
+	for _, rp := range pd.resourceProfiles {
+		var resourceLabels map[string][]string
+		if rp.resource != nil {
+			for _, kv := range rp.resource.attributes {
+				if kv.value == nil {
+					continue
+				}
+				if resourceLabels == nil {
+					resourceLabels = make(map[string][]string)
+				}
+				resourceLabels[kv.key] = append(resourceLabels[kv.key], kv.value.String())
+			}
+		}
+		for _, sp := range rp.scopeProfiles {
+			ps, err := c.convertScope(sp.profiles, resourceLabels)
+			if err != nil {
+				return nil, err
+			}
+			profs = append(profs, ps...)
+		}
+	}
+	return profs, nil
+}
+
+// otlpConverter converts OTLP profiles to profiles. A converter holds
+// the dictionary shared by all the OTLP profiles.
+type otlpConverter struct {
+	d      *otlpDictionary
+	tables map[*Profile]*otlpTables
+}
+
+// otlpTables holds the entities of a profile, indexed by their
+// dictionary index.
+type otlpTables struct {
+	mappings  map[int64]*Mapping
+	locations map[int64]*Location
+	functions map[int64]*Function
+
+	mappingIndex map[*Mapping]int64
+
+	// samples holds the samples of the profile by their key. The
+	// values of each sample type are written as a separate OTLP
+	// profile, so the samples of later ones fill in the values of the
+	// samples with the same key.
+	samples map[sampleKey][]*Sample
+}
+
+type otlpProfileKey struct {
+	timeNanos, durationNanos, period int64
+	periodType, periodUnit           string
+}
+
+func (c *otlpConverter) convertScope(ops []*otlpProfile, resourceLabels map[string][]string) ([]*Profile, error) {
+	var profs []*Profile
+	byKey := make(map[otlpProfileKey]*Profile)
+	for _, op := range ops {
+		var pt ValueType
+		if op.periodType != nil {
+			var err error
+			if pt, err = c.valueType(op.periodType); err != nil {
+				return nil, err
+			}
+		}
+		k := otlpProfileKey{int64(op.timeUnixNano), int64(op.durationNano), op.period, pt.Type, pt.Unit}
+		p := byKey[k]
+		if p == nil {
+			p = &Profile{
+				TimeNanos:     int64(op.timeUnixNano),
+				DurationNanos: int64(op.durationNano),
+				PeriodType:    &ValueType{Type: pt.Type, Unit: pt.Unit},
+				Period:        op.period,
+			}
+			c.tables[p] = &otlpTables{
+				mappings:  make(map[int64]*Mapping),
+				locations: make(map[int64]*Location),
+				functions: make(map[int64]*Function),
+
+				mappingIndex: make(map[*Mapping]int64),
+				samples:      make(map[sampleKey][]*Sample),
+			}
+			byKey[k] = p
+			profs = append(profs, p)
+		}
+		if err := c.addProfile(p, op, resourceLabels); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range profs {
+		// Samples added before a later sample type was seen have
+		// fewer values than sample types.
+		for _, s := range p.Sample {
+			if n := len(p.SampleType) - len(s.Value); n > 0 {
+				s.Value = append(s.Value, make([]int64, n)...)
+			}
+		}
+		// Keep the mappings in dictionary order, as producers place
+		// the main binary first.
+		t := c.tables[p]
+		sort.Slice(p.Mapping, func(i, j int) bool {
+			return t.mappingIndex[p.Mapping[i]] < t.mappingIndex[p.Mapping[j]]
+		})
+		for i, m := range p.Mapping {
+			m.ID = uint64(i + 1)
+		}
+		for _, l := range p.Location {
+			if m := l.Mapping; m != nil && len(l.Line) > 0 {
+				m.HasFunctions = true
+				m.HasFilenames = true
+			}
+		}
+	}
+	return profs, nil
+}
+
+// addProfile adds the sample types and samples of an OTLP profile to p.
+func (c *otlpConverter) addProfile(p *Profile, op *otlpProfile, resourceLabels map[string][]string) error {
+	first := len(p.SampleType)
+	for _, st := range op.sampleType {
+		vt, err := c.valueType(st)
+		if err != nil {
+			return err
+		}
+		p.SampleType = append(p.SampleType, &ValueType{Type: vt.Type, Unit: vt.Unit})
+	}
+
+	profileLabels := make(map[string][]string)
+	for k, v := range resourceLabels {
+		profileLabels[k] = v
+	}
+	profileNumLabels := make(map[string][]int64)
+	profileNumUnits := make(map[string][]string)
+	for _, ai := range op.attributeIndices {
+		if err := c.addAttribute(ai, profileLabels, profileNumLabels, profileNumUnits); err != nil {
+			return err
+		}
+	}
+
+	t := c.tables[p]
+	// Samples of this OTLP profile with the same key are matched in
+	// order with the samples added before.
+	matched := make(map[sampleKey]int)
+	for _, src := range op.samples {
+		s := &Sample{
+			Value:    make([]int64, len(p.SampleType)),
+			Label:    make(map[string][]string),
+			NumLabel: make(map[string][]int64),
+			NumUnit:  make(map[string][]string),
+		}
+		if len(src.values) > len(op.sampleType) {
+			return fmt.Errorf("sample has %d values vs. %d types", len(src.values), len(op.sampleType))
+		}
+		copy(s.Value[first:], src.values)
+
+		stack, err := c.stack(src.stackIndex)
+		if err != nil {
+			return err
+		}
+		for _, li := range stack.locationIndices {
+			l, err := c.location(p, li)
+			if err != nil {
+				return err
+			}
+			s.Location = append(s.Location, l)
+		}
+
+		for k, v := range profileLabels {
+			s.Label[k] = append([]string(nil), v...)
+		}
+		for k, v := range profileNumLabels {
+			s.NumLabel[k] = append([]int64(nil), v...)
+			s.NumUnit[k] = append([]string(nil), profileNumUnits[k]...)
+		}
+		for _, ai := range src.attributeIndices {
+			if err := c.addAttribute(ai, s.Label, s.NumLabel, s.NumUnit); err != nil {
+				return err
+			}
+		}
+		if len(src.timestampsUnixNano) > 0 {
+			ts := make([]int64, len(src.timestampsUnixNano))
+			units := make([]string, len(ts))
+			for i, t := range src.timestampsUnixNano {
+				ts[i], units[i] = int64(t), "nanoseconds"
+			}
+			s.NumLabel[TimestampLabel], s.NumUnit[TimestampLabel] = ts, units
+		}
+		if li := src.linkIndex; li != 0 {
+			if li < 0 || li >= int64(len(c.d.links)) {
+				return fmt.Errorf("invalid link index %d", li)
+			}
+			if link := c.d.links[li]; len(link.spanID) > 0 {
+				s.Label[otlpTraceIDLabel] = []string{hex.EncodeToString(link.traceID)}
+				s.Label[otlpSpanIDLabel] = []string{hex.EncodeToString(link.spanID)}
+			}
+		}
+		if len(s.Label) == 0 {
+			s.Label = nil
+		}
+		if len(s.NumLabel) == 0 {
+			s.NumLabel, s.NumUnit = nil, nil
+		}
+
+		k := s.key()
+		if i := matched[k]; i < len(t.samples[k]) {
+			prev := t.samples[k][i]
+			if n := len(p.SampleType) - len(prev.Value); n > 0 {
+				prev.Value = append(prev.Value, make([]int64, n)...)
+			}
+			copy(prev.Value[first:], src.values)
+			matched[k]++
+			continue
+		}
+		t.samples[k] = append(t.samples[k], s)
+		matched[k]++
+		p.Sample = append(p.Sample, s)
+	}
+	return nil
+}
+
+// addAttribute adds the attribute at index ai of the dictionary to a
+// set of labels. Integer attributes become numeric labels.
+func (c *otlpConverter) addAttribute(ai int64, labels map[string][]string, numLabels map[string][]int64, numUnits map[string][]string) error {
+	if ai < 0 || ai >= int64(len(c.d.attributes)) {
+		return fmt.Errorf("invalid attribute index %d", ai)
+	}
+	a := c.d.attributes[ai]
+	key, err := c.str(a.keyX)
+	if err != nil {
+		return err
+	}
+	if key == "" || a.value == nil {
+		return nil
+	}
+	if a.value.kind == otlpIntValue {
+		unit, err := c.str(a.unitX)
+		if err != nil {
+			return err
+		}
+		numLabels[key] = append(numLabels[key], a.value.num)
+		numUnits[key] = append(numUnits[key], unit)
+		return nil
+	}
+	labels[key] = append(labels[key], a.value.String())
+	return nil
+}
+
+// The lookup functions below accept index 0 even when the dictionary
+// table is empty, as some producers omit the zero value entries.
+
+func (c *otlpConverter) stack(i int64) (*otlpStack, error) {
+	if i == 0 && len(c.d.stacks) == 0 {
+		return &otlpStack{}, nil
+	}
+	if i < 0 || i >= int64(len(c.d.stacks)) {
+		return nil, fmt.Errorf("invalid stack index %d", i)
+	}
+	return c.d.stacks[i], nil
+}
+
+func (c *otlpConverter) str(i int64) (string, error) {
+	if i == 0 && len(c.d.strings) == 0 {
+		return "", nil
+	}
+	if i < 0 || i >= int64(len(c.d.strings)) {
+		return "", fmt.Errorf("invalid string index %d", i)
+	}
+	return c.d.strings[i], nil
+}
+
+func (c *otlpConverter) valueType(vt *otlpValueType) (ValueType, error) {
+	t, err := c.str(vt.typeX)
+	if err != nil {
+		return ValueType{}, err
+	}
+	u, err := c.str(vt.unitX)
+	if err != nil {
+		return ValueType{}, err
+	}
+	return ValueType{Type: t, Unit: u}, nil
+}
+
+func (c *otlpConverter) location(p *Profile, i int64) (*Location, error) {
+	t := c.tables[p]
+	if l := t.locations[i]; l != nil {
+		return l, nil
+	}
+	if i < 0 || i >= int64(len(c.d.locations)) {
+		return nil, fmt.Errorf("invalid location index %d", i)
+	}
+	ol := c.d.locations[i]
+	l := &Location{
+		ID:      uint64(len(p.Location) + 1),
+		Address: ol.address,
+	}
+	if mi := ol.mappingIndex; mi != 0 {
+		m, err := c.mapping(p, mi)
+		if err != nil {
+			return nil, err
+		}
+		l.Mapping = m
+	}
+	for _, oln := range ol.lines {
+		f, err := c.function(p, oln.functionIndex)
+		if err != nil {
+			return nil, err
+		}
+		l.Line = append(l.Line, Line{Function: f, Line: oln.line})
+	}
+	t.locations[i] = l
+	p.Location = append(p.Location, l)
+	return l, nil
+}
+
+func (c *otlpConverter) mapping(p *Profile, i int64) (*Mapping, error) {
+	t := c.tables[p]
+	if m := t.mappings[i]; m != nil {
+		return m, nil
+	}
+	if i < 0 || i >= int64(len(c.d.mappings)) {
+		return nil, fmt.Errorf("invalid mapping index %d", i)
+	}
+	om := c.d.mappings[i]
+	file, err := c.str(om.filenameX)
+	if err != nil {
+		return nil, err
+	}
+	m := &Mapping{
+		ID:     uint64(len(p.Mapping) + 1),
+		Start:  om.memoryStart,
+		Limit:  om.memoryLimit,
+		Offset: om.fileOffset,
+		File:   file,
+	}
+	labels := make(map[string][]string)
+	for _, ai := range om.attributeIndices {
+		if err := c.addAttribute(ai, labels, map[string][]int64{}, map[string][]string{}); err != nil {
+			return nil, err
+		}
+	}
+	for _, k := range otlpBuildIDAttributes {
+		if v := labels[k]; len(v) > 0 {
+			m.BuildID = v[0]
+			break
+		}
+	}
+	t.mappings[i] = m
+	t.mappingIndex[m] = i
+	p.Mapping = append(p.Mapping, m)
+	return m, nil
+}
+
+func (c *otlpConverter) function(p *Profile, i int64) (*Function, error) {
+	t := c.tables[p]
+	if f := t.functions[i]; f != nil {
+		return f, nil
+	}
+	if i < 0 || i >= int64(len(c.d.functions)) {
+		return nil, fmt.Errorf("invalid function index %d", i)
+	}
+	of := c.d.functions[i]
+	f := &Function{
+		ID:        uint64(len(p.Function) + 1),
+		StartLine: of.startLine,
+	}
+	var err error
+	if f.Name, err = c.str(of.nameX); err != nil {
+		return nil, err
+	}
+	if f.SystemName, err = c.str(of.systemNameX); err != nil {
+		return nil, err
+	}
+	if f.Filename, err = c.str(of.filenameX); err != nil {
+		return nil, err
+	}
+	t.functions[i] = f
+	p.Function = append(p.Function, f)
+	return f, nil
+}
+
+// otlpWriter builds the OTLP dictionary while converting profiles.
+// Index 0 of every dictionary table holds the zero value, as required
+// by OTLP.
+type otlpWriter struct {
+	d *otlpDictionary
+
+	strings    map[string]int64
+	attributes map[otlpAttributeKey]int64
+	stacks     map[string]int64
+	links      map[string]int64
+	mappings   map[*Mapping]int64
+	locations  map[*Location]int64
+	functions  map[*Function]int64
+}
+
+type otlpAttributeKey struct {
+	key, str, unit string
+	num            int64
+	isNum          bool
+}
+
+func newOTLPWriter() *otlpWriter {
+	return &otlpWriter{
+		d: &otlpDictionary{
+			mappings:   []*otlpMapping{{}},
+			locations:  []*otlpLocation{{}},
+			functions:  []*otlpFunction{{}},
+			links:      []*otlpLink{{}},
+			strings:    []string{""},
+			attributes: []*otlpAttribute{{}},
+			stacks:     []*otlpStack{{}},
+		},
+		strings:    map[string]int64{"": 0},
+		attributes: make(map[otlpAttributeKey]int64),
+		stacks:     map[string]int64{"": 0},
+		links:      make(map[string]int64),
+		mappings:   make(map[*Mapping]int64),
+		locations:  make(map[*Location]int64),
+		functions:  make(map[*Function]int64),
+	}
+}
+
+func (w *otlpWriter) profilesData(profs []*Profile) *otlpProfilesData {
+	sp := &otlpScopeProfiles{}
+	for _, p := range profs {
+		// Add the mappings first to preserve their order.
+		for _, m := range p.Mapping {
+			w.mapping(m)
+		}
+		for i := range p.SampleType {
+			sp.profiles = append(sp.profiles, w.profile(p, i))
+		}
+	}
+	return &otlpProfilesData{
+		resourceProfiles: []*otlpResourceProfiles{
+			{scopeProfiles: []*otlpScopeProfiles{sp}},
+		},
+		dictionary: w.d,
+	}
+}
+
+// profile converts the values of sample type i of p into an OTLP profile.
+func (w *otlpWriter) profile(p *Profile, i int) *otlpProfile {
+	op := &otlpProfile{
+		sampleType:   []*otlpValueType{w.valueType(p.SampleType[i])},
+		timeUnixNano: uint64(p.TimeNanos),
+		durationNano: uint64(p.DurationNanos),
+		period:       p.Period,
+	}
+	if pt := p.PeriodType; pt != nil {
+		op.periodType = w.valueType(pt)
+	}
+	for _, s := range p.Sample {
+		sample := &otlpSample{
+			stackIndex: w.stack(s.Location),
+			values:     []int64{s.Value[i]},
+		}
+		keys := make([]string, 0, len(s.Label))
+		for k := range s.Label {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if k == otlpTraceIDLabel || k == otlpSpanIDLabel {
+				continue
+			}
+			for _, v := range s.Label[k] {
+				sample.attributeIndices = append(sample.attributeIndices, w.attribute(otlpAttributeKey{key: k, str: v}))
+			}
+		}
+		keys = keys[:0]
+		for k := range s.NumLabel {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if k == TimestampLabel {
+				for _, t := range s.Timestamps() {
+					sample.timestampsUnixNano = append(sample.timestampsUnixNano, uint64(t))
+				}
+				continue
+			}
+			units := s.NumUnit[k]
+			for j, v := range s.NumLabel[k] {
+				ak := otlpAttributeKey{key: k, num: v, isNum: true}
+				if j < len(units) {
+					ak.unit = units[j]
+				}
+				sample.attributeIndices = append(sample.attributeIndices, w.attribute(ak))
+			}
+		}
+		sample.linkIndex = w.link(s.Label[otlpTraceIDLabel], s.Label[otlpSpanIDLabel])
+		op.samples = append(op.samples, sample)
+	}
+	return op
+}
+
+func (w *otlpWriter) str(s string) int64 {
+	if i, ok := w.strings[s]; ok {
+		return i
+	}
+	i := int64(len(w.d.strings))
+	w.strings[s] = i
+	w.d.strings = append(w.d.strings, s)
+	return i
+}
+
+func (w *otlpWriter) valueType(vt *ValueType) *otlpValueType {
+	return &otlpValueType{typeX: w.str(vt.Type), unitX: w.str(vt.Unit)}
+}
+
+func (w *otlpWriter) attribute(k otlpAttributeKey) int64 {
+	if i, ok := w.attributes[k]; ok {
+		return i
+	}
+	a := &otlpAttribute{keyX: w.str(k.key), unitX: w.str(k.unit)}
+	if k.isNum {
+		a.value = &otlpAnyValue{kind: otlpIntValue, num: k.num}
+	} else {
+		a.value = &otlpAnyValue{kind: otlpStringValue, str: k.str}
+	}
+	i := int64(len(w.d.attributes))
+	w.attributes[k] = i
+	w.d.attributes = append(w.d.attributes, a)
+	return i
+}
+
+func (w *otlpWriter) link(traceIDs, spanIDs []string) int64 {
+	if len(spanIDs) == 0 {
+		return 0
+	}
+	var traceID string
+	if len(traceIDs) > 0 {
+		traceID = traceIDs[0]
+	}
+	k := traceID + "/" + spanIDs[0]
+	if i, ok := w.links[k]; ok {
+		return i
+	}
+	tid, err := hex.DecodeString(traceID)
+	if err != nil {
+		return 0
+	}
+	sid, err := hex.DecodeString(spanIDs[0])
+	if err != nil {
+		return 0
+	}
+	i := int64(len(w.d.links))
+	w.links[k] = i
+	w.d.links = append(w.d.links, &otlpLink{traceID: tid, spanID: sid})
+	return i
+}
+
+func (w *otlpWriter) stack(locs []*Location) int64 {
+	st := &otlpStack{locationIndices: make([]int64, len(locs))}
+	for i, l := range locs {
+		st.locationIndices[i] = w.location(l)
+	}
+	k := fmt.Sprint(st.locationIndices)
+	if i, ok := w.stacks[k]; ok {
+		return i
+	}
+	i := int64(len(w.d.stacks))
+	w.stacks[k] = i
+	w.d.stacks = append(w.d.stacks, st)
+	return i
+}
+
+func (w *otlpWriter) location(l *Location) int64 {
+	if i, ok := w.locations[l]; ok {
+		return i
+	}
+	ol := &otlpLocation{address: l.Address}
+	if l.Mapping != nil {
+		ol.mappingIndex = w.mapping(l.Mapping)
+	}
+	for _, ln := range l.Line {
+		oln := &otlpLine{line: ln.Line}
+		if ln.Function != nil {
+			oln.functionIndex = w.function(ln.Function)
+		}
+		ol.lines = append(ol.lines, oln)
+	}
+	i := int64(len(w.d.locations))
+	w.locations[l] = i
+	w.d.locations = append(w.d.locations, ol)
+	return i
+}
+
+func (w *otlpWriter) mapping(m *Mapping) int64 {
+	if i, ok := w.mappings[m]; ok {
+		return i
+	}
+	om := &otlpMapping{
+		memoryStart: m.Start,
+		memoryLimit: m.Limit,
+		fileOffset:  m.Offset,
+		filenameX:   w.str(m.File),
+	}
+	if m.BuildID != "" {
+		om.attributeIndices = []int64{w.attribute(otlpAttributeKey{key: otlpBuildIDAttributes[0], str: m.BuildID})}
+	}
+	i := int64(len(w.d.mappings))
+	w.mappings[m] = i
+	w.d.mappings = append(w.d.mappings, om)
+	return i
+}
+
+func (w *otlpWriter) function(f *Function) int64 {
+	if i, ok := w.functions[f]; ok {
+		return i
+	}
+	of := &otlpFunction{
+		nameX:       w.str(f.Name),
+		systemNameX: w.str(f.SystemName),
+		filenameX:   w.str(f.Filename),
+		startLine:   f.StartLine,
+	}
+	i := int64(len(w.d.functions))
+	w.functions[f] = i
+	w.d.functions = append(w.d.functions, of)
+	return i
+}
+
+// Protocol buffer encoding and decoding of the OTLP messages.
+
+func encodeFixed64(b *buffer, tag int, x uint64) {
+	encodeVarint(b, uint64(tag)<<3|1)
+	for i := uint(0); i < 8; i++ {
+		b.data = append(b.data, byte(x>>(8*i)))
+	}
+}
+
+func encodeFixed64Opt(b *buffer, tag int, x uint64) {
+	if x == 0 {
+		return
+	}
+	encodeFixed64(b, tag, x)
+}
+
+func encodeFixed64s(b *buffer, tag int, x []uint64) {
+	if len(x) == 0 {
+		return
+	}
+	// Use packed encoding.
+	encodeLength(b, tag, 8*len(x))
+	for _, u := range x {
+		for i := uint(0); i < 8; i++ {
+			b.data = append(b.data, byte(u>>(8*i)))
+		}
+	}
+}
+
+func encodeBytes(b *buffer, tag int, x []byte) {
+	encodeLength(b, tag, len(x))
+	b.data = append(b.data, x...)
+}
+
+func decodeFixed64(b *buffer, x *uint64) error {
+	if err := checkType(b, 1); err != nil {
+		return err
+	}
+	*x = b.u64
+	return nil
+}
+
+func decodeFixed64s(b *buffer, x *[]uint64) error {
+	if b.typ == 2 {
+		// Packed encoding
+		data := b.data
+		if len(data)%8 != 0 {
+			return errors.New("bad packed fixed64")
+		}
+		for ; len(data) > 0; data = data[8:] {
+			*x = append(*x, le64(data[:8]))
+		}
+		return nil
+	}
+	var u uint64
+	if err := decodeFixed64(b, &u); err != nil {
+		return err
+	}
+	*x = append(*x, u)
+	return nil
+}
+
+func decodeBytes(b *buffer, x *[]byte) error {
+	if err := checkType(b, 2); err != nil {
+		return err
+	}
+	*x = append([]byte(nil), b.data...)
+	return nil
+}
+
+func (m *otlpProfilesData) decoder() []decoder { return otlpProfilesDataDecoder }
+
+func (m *otlpProfilesData) encode(b *buffer) {
+	for _, x := range m.resourceProfiles {
+		encodeMessage(b, 1, x)
+	}
+	if m.dictionary != nil {
+		encodeMessage(b, 2, m.dictionary)
+	}
+}
+
+var otlpProfilesDataDecoder = []decoder{
+	nil, // 0
+	// repeated ResourceProfiles resource_profiles = 1
+	func(b *buffer, m message) error {
+		x := new(otlpResourceProfiles)
+		pd := m.(*otlpProfilesData)
+		pd.resourceProfiles = append(pd.resourceProfiles, x)
+		return decodeMessage(b, x)
+	},
+	// ProfilesDictionary dictionary = 2
+	func(b *buffer, m message) error {
+		x := new(otlpDictionary)
+		m.(*otlpProfilesData).dictionary = x
+		return decodeMessage(b, x)
+	},
+}
+
+func (m *otlpResourceProfiles) decoder() []decoder { return otlpResourceProfilesDecoder }
+
+func (m *otlpResourceProfiles) encode(b *buffer) {
+	if m.resource != nil {
+		encodeMessage(b, 1, m.resource)
+	}
+	for _, x := range m.scopeProfiles {
+		encodeMessage(b, 2, x)
+	}
+}
+
+var otlpResourceProfilesDecoder = []decoder{
+	nil, // 0
+	// Resource resource = 1
+	func(b *buffer, m message) error {
+		x := new(otlpResource)
+		m.(*otlpResourceProfiles).resource = x
+		return decodeMessage(b, x)
+	},
+	// repeated ScopeProfiles scope_profiles = 2
+	func(b *buffer, m message) error {
+		x := new(otlpScopeProfiles)
+		rp := m.(*otlpResourceProfiles)
+		rp.scopeProfiles = append(rp.scopeProfiles, x)
+		return decodeMessage(b, x)
+	},
+}
+
+func (m *otlpResource) decoder() []decoder { return otlpResourceDecoder }
+
+func (m *otlpResource) encode(b *buffer) {
+	for _, x := range m.attributes {
+		encodeMessage(b, 1, x)
+	}
+}
+
+var otlpResourceDecoder = []decoder{
+	nil, // 0
+	// repeated KeyValue attributes = 1
+	func(b *buffer, m message) error {
+		x := new(otlpKeyValue)
+		r := m.(*otlpResource)
+		r.attributes = append(r.attributes, x)
+		return decodeMessage(b, x)
+	},
+}
+
+func (m *otlpScopeProfiles) decoder() []decoder { return otlpScopeProfilesDecoder }
+
+func (m *otlpScopeProfiles) encode(b *buffer) {
+	for _, x := range m.profiles {
+		encodeMessage(b, 2, x)
+	}
+}
+
+var otlpScopeProfilesDecoder = []decoder{
+	nil, // 0
+	nil, // InstrumentationScope scope = 1
+	// repeated Profile profiles = 2
+	func(b *buffer, m message) error {
+		x := new(otlpProfile)
+		sp := m.(*otlpScopeProfiles)
+		sp.profiles = append(sp.profiles, x)
+		return decodeMessage(b, x)
+	},
+}
+
+func (m *otlpProfile) decoder() []decoder { return otlpProfileDecoder }
+
+func (m *otlpProfile) encode(b *buffer) {
+	for _, x := range m.sampleType {
+		encodeMessage(b, 1, x)
+	}
+	for _, x := range m.samples {
+		encodeMessage(b, 2, x)
+	}
+	encodeFixed64Opt(b, 3, m.timeUnixNano)
+	encodeUint64Opt(b, 4, m.durationNano)
+	if m.periodType != nil {
+		encodeMessage(b, 5, m.periodType)
+	}
+	encodeInt64Opt(b, 6, m.period)
+	encodeInt64s(b, 11, m.attributeIndices)
+}
+
+var otlpProfileDecoder = []decoder{
+	nil, // 0
+	// ValueType sample_type = 1
+	func(b *buffer, m message) error {
+		x := new(otlpValueType)
+		p := m.(*otlpProfile)
+		p.sampleType = append(p.sampleType, x)
+		return decodeMessage(b, x)
+	},
+	// repeated Sample samples = 2
+	func(b *buffer, m message) error {
+		x := new(otlpSample)
+		p := m.(*otlpProfile)
+		p.samples = append(p.samples, x)
+		return decodeMessage(b, x)
+	},
+	// fixed64 time_unix_nano = 3
+	func(b *buffer, m message) error { return decodeFixed64(b, &m.(*otlpProfile).timeUnixNano) },
+	// uint64 duration_nano = 4
+	func(b *buffer, m message) error { return decodeUint64(b, &m.(*otlpProfile).durationNano) },
+	// ValueType period_type = 5
+	func(b *buffer, m message) error {
+		x := new(otlpValueType)
+		m.(*otlpProfile).periodType = x
+		return decodeMessage(b, x)
+	},
+	// int64 period = 6
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpProfile).period) },
+	nil, // bytes profile_id = 7
+	nil, // uint32 dropped_attributes_count = 8
+	nil, // string original_payload_format = 9
+	nil, // bytes original_payload = 10
+	// repeated int32 attribute_indices = 11
+	func(b *buffer, m message) error { return decodeInt64s(b, &m.(*otlpProfile).attributeIndices) },
+}
+
+func (m *otlpValueType) decoder() []decoder { return otlpValueTypeDecoder }
+
+func (m *otlpValueType) encode(b *buffer) {
+	encodeInt64Opt(b, 1, m.typeX)
+	encodeInt64Opt(b, 2, m.unitX)
+}
+
+var otlpValueTypeDecoder = []decoder{
+	nil, // 0
+	// int32 type_strindex = 1
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpValueType).typeX) },
+	// int32 unit_strindex = 2
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpValueType).unitX) },
+}
+
+func (m *otlpSample) decoder() []decoder { return otlpSampleDecoder }
+
+func (m *otlpSample) encode(b *buffer) {
+	encodeInt64Opt(b, 1, m.stackIndex)
+	encodeInt64s(b, 2, m.values)
+	encodeInt64s(b, 3, m.attributeIndices)
+	encodeInt64Opt(b, 4, m.linkIndex)
+	encodeFixed64s(b, 5, m.timestampsUnixNano)
+}
+
+var otlpSampleDecoder = []decoder{
+	nil, // 0
+	// int32 stack_index = 1
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpSample).stackIndex) },
+	// repeated int64 values = 2
+	func(b *buffer, m message) error { return decodeInt64s(b, &m.(*otlpSample).values) },
+	// repeated int32 attribute_indices = 3
+	func(b *buffer, m message) error { return decodeInt64s(b, &m.(*otlpSample).attributeIndices) },
+	// int32 link_index = 4
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpSample).linkIndex) },
+	// repeated fixed64 timestamps_unix_nano = 5
+	func(b *buffer, m message) error { return decodeFixed64s(b, &m.(*otlpSample).timestampsUnixNano) },
+}
+
+func (m *otlpDictionary) decoder() []decoder { return otlpDictionaryDecoder }
+
+func (m *otlpDictionary) encode(b *buffer) {
+	for _, x := range m.mappings {
+		encodeMessage(b, 1, x)
+	}
+	for _, x := range m.locations {
+		encodeMessage(b, 2, x)
+	}
+	for _, x := range m.functions {
+		encodeMessage(b, 3, x)
+	}
+	for _, x := range m.links {
+		encodeMessage(b, 4, x)
+	}
+	encodeStrings(b, 5, m.strings)
+	for _, x := range m.attributes {
+		encodeMessage(b, 6, x)
+	}
+	for _, x := range m.stacks {
+		encodeMessage(b, 7, x)
+	}
+}
+
+var otlpDictionaryDecoder = []decoder{
+	nil, // 0
+	// repeated Mapping mapping_table = 1
+	func(b *buffer, m message) error {
+		x := new(otlpMapping)
+		d := m.(*otlpDictionary)
+		d.mappings = append(d.mappings, x)
+		return decodeMessage(b, x)
+	},
+	// repeated Location location_table = 2
+	func(b *buffer, m message) error {
+		x := new(otlpLocation)
+		d := m.(*otlpDictionary)
+		d.locations = append(d.locations, x)
+		return decodeMessage(b, x)
+	},
+	// repeated Function function_table = 3
+	func(b *buffer, m message) error {
+		x := new(otlpFunction)
+		d := m.(*otlpDictionary)
+		d.functions = append(d.functions, x)
+		return decodeMessage(b, x)
+	},
+	// repeated Link link_table = 4
+	func(b *buffer, m message) error {
+		x := new(otlpLink)
+		d := m.(*otlpDictionary)
+		d.links = append(d.links, x)
+		return decodeMessage(b, x)
+	},
+	// repeated string string_table = 5
+	func(b *buffer, m message) error { return decodeStrings(b, &m.(*otlpDictionary).strings) },
+	// repeated KeyValueAndUnit attribute_table = 6
+	func(b *buffer, m message) error {
+		x := new(otlpAttribute)
+		d := m.(*otlpDictionary)
+		d.attributes = append(d.attributes, x)
+		return decodeMessage(b, x)
+	},
+	// repeated Stack stack_table = 7
+	func(b *buffer, m message) error {
+		x := new(otlpStack)
+		d := m.(*otlpDictionary)
+		d.stacks = append(d.stacks, x)
+		return decodeMessage(b, x)
+	},
+}
+
+func (m *otlpMapping) decoder() []decoder { return otlpMappingDecoder }
+
+func (m *otlpMapping) encode(b *buffer) {
+	encodeUint64Opt(b, 1, m.memoryStart)
+	encodeUint64Opt(b, 2, m.memoryLimit)
+	encodeUint64Opt(b, 3, m.fileOffset)
+	encodeInt64Opt(b, 4, m.filenameX)
+	encodeInt64s(b, 5, m.attributeIndices)
+}
+
+var otlpMappingDecoder = []decoder{
+	nil, // 0
+	// uint64 memory_start = 1
+	func(b *buffer, m message) error { return decodeUint64(b, &m.(*otlpMapping).memoryStart) },
+	// uint64 memory_limit = 2
+	func(b *buffer, m message) error { return decodeUint64(b, &m.(*otlpMapping).memoryLimit) },
+	// uint64 file_offset = 3
+	func(b *buffer, m message) error { return decodeUint64(b, &m.(*otlpMapping).fileOffset) },
+	// int32 filename_strindex = 4
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpMapping).filenameX) },
+	// repeated int32 attribute_indices = 5
+	func(b *buffer, m message) error { return decodeInt64s(b, &m.(*otlpMapping).attributeIndices) },
+}
+
+func (m *otlpLocation) decoder() []decoder { return otlpLocationDecoder }
+
+func (m *otlpLocation) encode(b *buffer) {
+	encodeInt64Opt(b, 1, m.mappingIndex)
+	encodeUint64Opt(b, 2, m.address)
+	for _, x := range m.lines {
+		encodeMessage(b, 3, x)
+	}
+}
+
+var otlpLocationDecoder = []decoder{
+	nil, // 0
+	// int32 mapping_index = 1
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpLocation).mappingIndex) },
+	// uint64 address = 2
+	func(b *buffer, m message) error { return decodeUint64(b, &m.(*otlpLocation).address) },
+	// repeated Line lines = 3
+	func(b *buffer, m message) error {
+		x := new(otlpLine)
+		l := m.(*otlpLocation)
+		l.lines = append(l.lines, x)
+		return decodeMessage(b, x)
+	},
+}
+
+func (m *otlpLine) decoder() []decoder { return otlpLineDecoder }
+
+func (m *otlpLine) encode(b *buffer) {
+	encodeInt64Opt(b, 1, m.functionIndex)
+	encodeInt64Opt(b, 2, m.line)
+}
+
+var otlpLineDecoder = []decoder{
+	nil, // 0
+	// int32 function_index = 1
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpLine).functionIndex) },
+	// int64 line = 2
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpLine).line) },
+}
+
+func (m *otlpFunction) decoder() []decoder { return otlpFunctionDecoder }
+
+func (m *otlpFunction) encode(b *buffer) {
+	encodeInt64Opt(b, 1, m.nameX)
+	encodeInt64Opt(b, 2, m.systemNameX)
+	encodeInt64Opt(b, 3, m.filenameX)
+	encodeInt64Opt(b, 4, m.startLine)
+}
+
+var otlpFunctionDecoder = []decoder{
+	nil, // 0
+	// int32 name_strindex = 1
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpFunction).nameX) },
+	// int32 system_name_strindex = 2
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpFunction).systemNameX) },
+	// int32 filename_strindex = 3
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpFunction).filenameX) },
+	// int64 start_line = 4
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpFunction).startLine) },
+}
+
+func (m *otlpStack) decoder() []decoder { return otlpStackDecoder }
+
+func (m *otlpStack) encode(b *buffer) {
+	encodeInt64s(b, 1, m.locationIndices)
+}
+
+var otlpStackDecoder = []decoder{
+	nil, // 0
+	// repeated int32 location_indices = 1
+	func(b *buffer, m message) error { return decodeInt64s(b, &m.(*otlpStack).locationIndices) },
+}
+
+func (m *otlpLink) decoder() []decoder { return otlpLinkDecoder }
+
+func (m *otlpLink) encode(b *buffer) {
+	if len(m.traceID) > 0 {
+		encodeBytes(b, 1, m.traceID)
+	}
+	if len(m.spanID) > 0 {
+		encodeBytes(b, 2, m.spanID)
+	}
+}
+
+var otlpLinkDecoder = []decoder{
+	nil, // 0
+	// bytes trace_id = 1
+	func(b *buffer, m message) error { return decodeBytes(b, &m.(*otlpLink).traceID) },
+	// bytes span_id = 2
+	func(b *buffer, m message) error { return decodeBytes(b, &m.(*otlpLink).spanID) },
+}
+
+func (m *otlpAttribute) decoder() []decoder { return otlpAttributeDecoder }
+
+func (m *otlpAttribute) encode(b *buffer) {
+	encodeInt64Opt(b, 1, m.keyX)
+	if m.value != nil {
+		encodeMessage(b, 2, m.value)
+	}
+	encodeInt64Opt(b, 3, m.unitX)
+}
+
+var otlpAttributeDecoder = []decoder{
+	nil, // 0
+	// int32 key_strindex = 1
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpAttribute).keyX) },
+	// AnyValue value = 2
+	func(b *buffer, m message) error {
+		x := new(otlpAnyValue)
+		m.(*otlpAttribute).value = x
+		return decodeMessage(b, x)
+	},
+	// int32 unit_strindex = 3
+	func(b *buffer, m message) error { return decodeInt64(b, &m.(*otlpAttribute).unitX) },
+}
+
+func (m *otlpKeyValue) decoder() []decoder { return otlpKeyValueDecoder }
+
+func (m *otlpKeyValue) encode(b *buffer) {
+	encodeString(b, 1, m.key)
+	if m.value != nil {
+		encodeMessage(b, 2, m.value)
+	}
+}
+
+var otlpKeyValueDecoder = []decoder{
+	nil, // 0
+	// string key = 1
+	func(b *buffer, m message) error { return decodeString(b, &m.(*otlpKeyValue).key) },
+	// AnyValue value = 2
+	func(b *buffer, m message) error {
+		x := new(otlpAnyValue)
+		m.(*otlpKeyValue).value = x
+		return decodeMessage(b, x)
+	},
+}
+
+func (m *otlpAnyValue) decoder() []decoder { return otlpAnyValueDecoder }
+
+func (m *otlpAnyValue) encode(b *buffer) {
+	switch m.kind {
+	case otlpStringValue:
+		encodeString(b, otlpStringValue, m.str)
+	case otlpBoolValue:
+		encodeBool(b, otlpBoolValue, m.num != 0)
+	case otlpIntValue:
+		encodeInt64(b, otlpIntValue, m.num)
+	case otlpDoubleValue:
+		encodeFixed64(b, otlpDoubleValue, math.Float64bits(m.double))
+	}
+}
+
+var otlpAnyValueDecoder = []decoder{
+	nil, // 0
+	// string string_value = 1
+	func(b *buffer, m message) error {
+		v := m.(*otlpAnyValue)
+		v.kind = otlpStringValue
+		return decodeString(b, &v.str)
+	},
+	// bool bool_value = 2
+	func(b *buffer, m message) error {
+		v := m.(*otlpAnyValue)
+		v.kind = otlpBoolValue
+		return decodeInt64(b, &v.num)
+	},
+	// int64 int_value = 3
+	func(b *buffer, m message) error {
+		v := m.(*otlpAnyValue)
+		v.kind = otlpIntValue
+		return decodeInt64(b, &v.num)
+	},
+	// double double_value = 4
+	func(b *buffer, m message) error {
+		v := m.(*otlpAnyValue)
+		v.kind = otlpDoubleValue
+		var u uint64
+		if err := decodeFixed64(b, &u); err != nil {
+			return err
+		}
+		v.double = math.Float64frombits(u)
+		return nil
+	},
+}
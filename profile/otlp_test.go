@@ -0,0 +1,221 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// sampleSummary returns a representation of the samples of p that is
+// independent of IDs and sample order.
+func sampleSummary(p *Profile) []string {
+	var ss []string
+	for _, s := range p.Sample {
+		var frames []string
+		for _, l := range s.Location {
+			for _, ln := range l.Line {
+				frames = append(frames, fmt.Sprintf("%s:%d", ln.Function.Name, ln.Line))
+			}
+		}
+		ss = append(ss, fmt.Sprintf("%v %s %s %s", s.Value, strings.Join(frames, ";"),
+			labelsToString(s.Label), numLabelsToString(s.NumLabel, s.NumUnit)))
+	}
+	sort.Strings(ss)
+	return ss
+}
+
+func valueTypes(vts []*ValueType) []string {
+	var ss []string
+	for _, vt := range vts {
+		ss = append(ss, vt.Type+"/"+vt.Unit)
+	}
+	return ss
+}
+
+// usedMappingFiles returns the files of the mappings of p referenced by
+// its locations, in mapping order.
+func usedMappingFiles(p *Profile) []string {
+	used := make(map[*Mapping]bool)
+	for _, l := range p.Location {
+		used[l.Mapping] = true
+	}
+	var files []string
+	for _, m := range p.Mapping {
+		if used[m] {
+			files = append(files, m.File)
+		}
+	}
+	return files
+}
+
+func TestOTLPRoundTrip(t *testing.T) {
+	withSpan := testProfile1.Copy()
+	withSpan.Sample[0].Label[otlpTraceIDLabel] = []string{"0102030405060708090a0b0c0d0e0f10"}
+	withSpan.Sample[0].Label[otlpSpanIDLabel] = []string{"0102030405060708"}
+
+	for _, tc := range []struct {
+		name string
+		prof *Profile
+	}{
+		{"testProfile1", testProfile1},
+		{"testProfile1NoMapping", testProfile1NoMapping},
+		{"testProfile5", testProfile5},
+		{"span labels", withSpan},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteOTLP(&buf, []*Profile{tc.prof}); err != nil {
+				t.Fatalf("WriteOTLP: %v", err)
+			}
+			profs, err := ParseOTLP(buf.Bytes())
+			if err != nil {
+				t.Fatalf("ParseOTLP: %v", err)
+			}
+			if len(profs) != 1 {
+				t.Fatalf("got %d profiles, want 1", len(profs))
+			}
+			got, want := profs[0], tc.prof
+			if g, w := valueTypes(got.SampleType), valueTypes(want.SampleType); !reflect.DeepEqual(g, w) {
+				t.Errorf("got sample types %v, want %v", g, w)
+			}
+			if got.TimeNanos != want.TimeNanos || got.DurationNanos != want.DurationNanos || got.Period != want.Period {
+				t.Errorf("got time %d, duration %d, period %d; want %d, %d, %d",
+					got.TimeNanos, got.DurationNanos, got.Period, want.TimeNanos, want.DurationNanos, want.Period)
+			}
+			if g, w := sampleSummary(got), sampleSummary(want); !reflect.DeepEqual(g, w) {
+				t.Errorf("got samples\n%s\nwant\n%s", strings.Join(g, "\n"), strings.Join(w, "\n"))
+			}
+			// Only the mappings of the sampled locations are written, while
+			// the test profiles also hold unsampled ones.
+			if g, w := usedMappingFiles(got), usedMappingFiles(want.Compact()); !reflect.DeepEqual(g, w) {
+				t.Errorf("got mappings %v, want %v", g, w)
+			}
+		})
+	}
+}
+
+func TestOTLPAttributes(t *testing.T) {
+	// A ProfilesData message with a resource attribute, a profile
+	// attribute and a sample with a double attribute.
+	d := &otlpDictionary{
+		strings:   []string{"", "samples", "count", "main", "host", "kind", "ratio"},
+		functions: []*otlpFunction{{}, {nameX: 3}},
+		locations: []*otlpLocation{{}, {address: 0x10, lines: []*otlpLine{{functionIndex: 1, line: 7}}}},
+		stacks:    []*otlpStack{{}, {locationIndices: []int64{1}}},
+		links:     []*otlpLink{{}, {traceID: []byte{1, 2}, spanID: []byte{0xab, 0xcd}}},
+		attributes: []*otlpAttribute{
+			{},
+			{keyX: 5, value: &otlpAnyValue{kind: otlpStringValue, str: "test"}},
+			{keyX: 6, value: &otlpAnyValue{kind: otlpDoubleValue, double: 0.5}},
+		},
+	}
+	pd := &otlpProfilesData{
+		resourceProfiles: []*otlpResourceProfiles{{
+			resource: &otlpResource{
+				attributes: []*otlpKeyValue{{key: "host", value: &otlpAnyValue{kind: otlpStringValue, str: "h1"}}},
+			},
+			scopeProfiles: []*otlpScopeProfiles{{
+				profiles: []*otlpProfile{{
+					sampleType:       []*otlpValueType{{typeX: 1, unitX: 2}},
+					attributeIndices: []int64{1},
+					samples: []*otlpSample{{
+						stackIndex:         1,
+						values:             []int64{3},
+						attributeIndices:   []int64{2},
+						linkIndex:          1,
+						timestampsUnixNano: []uint64{100, 200},
+					}},
+				}},
+			}},
+		}},
+		dictionary: d,
+	}
+	profs, err := ParseOTLP(marshal(pd))
+	if err != nil {
+		t.Fatalf("ParseOTLP: %v", err)
+	}
+	if len(profs) != 1 || len(profs[0].Sample) != 1 {
+		t.Fatalf("got %d profiles, want 1 profile with 1 sample", len(profs))
+	}
+	s := profs[0].Sample[0]
+	want := map[string][]string{
+		"host":           {"h1"},
+		"kind":           {"test"},
+		"ratio":          {"0.5"},
+		otlpTraceIDLabel: {"0102"},
+		otlpSpanIDLabel:  {"abcd"},
+	}
+	if !reflect.DeepEqual(s.Label, want) {
+		t.Errorf("got labels %v, want %v", s.Label, want)
+	}
+	if got, want := s.Timestamps(), []int64{100, 200}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got timestamps %v, want %v", got, want)
+	}
+	if got := s.Location[0].Line[0].Function.Name; got != "main" {
+		t.Errorf("got function %q, want main", got)
+	}
+
+	// Timestamps are written back as timestamps, not attributes.
+	var buf bytes.Buffer
+	if err := WriteOTLP(&buf, profs); err != nil {
+		t.Fatalf("WriteOTLP: %v", err)
+	}
+	profs, err = ParseOTLP(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ParseOTLP: %v", err)
+	}
+	if got, want := profs[0].Sample[0].Timestamps(), []int64{100, 200}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got timestamps %v after a round trip, want %v", got, want)
+	}
+}
+
+func TestParseOTLPError(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		pd      *otlpProfilesData
+		wantErr string
+	}{
+		{
+			name:    "no profiles",
+			pd:      &otlpProfilesData{dictionary: &otlpDictionary{strings: []string{""}}},
+			wantErr: "no profiles found",
+		},
+		{
+			name: "bad stack",
+			pd: &otlpProfilesData{
+				resourceProfiles: []*otlpResourceProfiles{{
+					scopeProfiles: []*otlpScopeProfiles{{
+						profiles: []*otlpProfile{{
+							sampleType: []*otlpValueType{{}},
+							samples:    []*otlpSample{{stackIndex: 4, values: []int64{1}}},
+						}},
+					}},
+				}},
+				dictionary: &otlpDictionary{strings: []string{""}},
+			},
+			wantErr: "invalid stack index 4",
+		},
+	} {
+		_, err := ParseOTLP(marshal(tc.pd))
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%s: got error %v, want %q", tc.name, err, tc.wantErr)
+		}
+	}
+}
@@ -140,8 +140,9 @@ type Function struct {
 }
 
 // Parse parses a profile and checks for its validity. The input
-// may be a gzip-compressed encoded protobuf or one of many legacy
-// profile formats which may be unsupported in the future.
+// may be a gzip-compressed encoded protobuf, a profile in the JSON
+// format written by WriteJSON, or one of many legacy profile formats
+// which may be unsupported in the future.
 func Parse(r io.Reader) (*Profile, error) {
 	data, err := ioutil.ReadAll(r)
 	if err != nil {
@@ -153,6 +154,20 @@ func Parse(r io.Reader) (*Profile, error) {
 // ParseData parses a profile from a buffer and checks for its
 // validity.
 func ParseData(data []byte) (*Profile, error) {
+	p, err := ParseDataUnchecked(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.CheckValid(); err != nil {
+		return nil, fmt.Errorf("malformed profile: %v", err)
+	}
+	return p, nil
+}
+
+// ParseDataUnchecked parses a profile from a buffer like ParseData, but
+// does not check that the resulting profile is valid. It is meant for
+// profiles that may be malformed, which can then be fixed by Repair.
+func ParseDataUnchecked(data []byte) (*Profile, error) {
 	var p *Profile
 	var err error
 	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
@@ -165,16 +180,16 @@ func ParseData(data []byte) (*Profile, error) {
 		}
 	}
 	if p, err = ParseUncompressed(data); err != nil && err != errNoData && err != errConcatProfile {
-		p, err = parseLegacy(data)
+		if isJSON(data) {
+			p, err = parseJSON(data)
+		} else {
+			p, err = parseLegacy(data)
+		}
 	}
 
 	if err != nil {
 		return nil, fmt.Errorf("parsing profile: %v", err)
 	}
-
-	if err := p.CheckValid(); err != nil {
-		return nil, fmt.Errorf("malformed profile: %v", err)
-	}
 	return p, nil
 }
 
@@ -710,6 +725,38 @@ func (s *Sample) DiffBaseSample() bool {
 	return s.HasLabel("pprof::base", "true")
 }
 
+// TimestampLabel is the key of the numeric label holding the times at
+// which the events aggregated in a sample occurred, as nanoseconds
+// since the Unix epoch unless the label has another time unit.
+const TimestampLabel = "timestamp"
+
+// Timestamps returns the times of the events of a sample, in
+// nanoseconds since the Unix epoch, as held by its TimestampLabel
+// label. It returns nil if the sample has no timestamps.
+func (s *Sample) Timestamps() []int64 {
+	values := s.NumLabel[TimestampLabel]
+	if len(values) == 0 {
+		return nil
+	}
+	units := s.NumUnit[TimestampLabel]
+	ts := make([]int64, len(values))
+	for i, v := range values {
+		scale := int64(1)
+		if i < len(units) {
+			switch units[i] {
+			case "us", "microseconds":
+				scale = 1e3
+			case "ms", "milliseconds":
+				scale = 1e6
+			case "s", "seconds":
+				scale = 1e9
+			}
+		}
+		ts[i] = v * scale
+	}
+	return ts
+}
+
 // Scale multiplies all sample values in a profile by a constant.
 func (p *Profile) Scale(ratio float64) {
 	if ratio == 1 {
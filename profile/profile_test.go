@@ -709,6 +709,56 @@ func TestMergeAll(t *testing.T) {
 	}
 }
 
+func TestSetSampleTypes(t *testing.T) {
+	p1 := &Profile{
+		SampleType: []*ValueType{
+			{Type: "samples", Unit: "count"},
+			{Type: "cpu", Unit: "nanoseconds"},
+		},
+		DefaultSampleType: "samples",
+		Sample: []*Sample{
+			{Location: []*Location{cpuL[0]}, Value: []int64{1, 100}},
+		},
+	}
+	p2 := &Profile{
+		SampleType: []*ValueType{
+			{Type: "cpu", Unit: "nanoseconds"},
+			{Type: "alloc", Unit: "bytes"},
+		},
+	}
+
+	typeNames := func(types []*ValueType) []string {
+		var names []string
+		for _, st := range types {
+			names = append(names, st.Type+"/"+st.Unit)
+		}
+		return names
+	}
+	if got, want := typeNames(UnionSampleTypes([]*Profile{p1, p2})), []string{"samples/count", "cpu/nanoseconds", "alloc/bytes"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UnionSampleTypes() = %v, want %v", got, want)
+	}
+	intersection := IntersectSampleTypes([]*Profile{p1, p2})
+	if got, want := typeNames(intersection), []string{"cpu/nanoseconds"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("IntersectSampleTypes() = %v, want %v", got, want)
+	}
+
+	p := p1.Copy()
+	p.SetSampleTypes(UnionSampleTypes([]*Profile{p2, p1}))
+	if got, want := p.Sample[0].Value, []int64{100, 0, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("values after union = %v, want %v", got, want)
+	}
+	if p.DefaultSampleType != "samples" {
+		t.Errorf("default sample type after union = %q, want samples", p.DefaultSampleType)
+	}
+	p.SetSampleTypes(intersection)
+	if got, want := p.Sample[0].Value, []int64{100}; !reflect.DeepEqual(got, want) {
+		t.Errorf("values after intersection = %v, want %v", got, want)
+	}
+	if p.DefaultSampleType != "" {
+		t.Errorf("default sample type after intersection = %q, want none", p.DefaultSampleType)
+	}
+}
+
 func TestIsFoldedMerge(t *testing.T) {
 	testProfile1Folded := testProfile1.Copy()
 	testProfile1Folded.Location[0].IsFolded = true
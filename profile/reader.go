@@ -0,0 +1,228 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+)
+
+// SampleReader reads the samples of a profile in the protocol buffer
+// format one at a time, without holding all of them in memory.
+//
+// The header of the profile, which includes everything but the samples,
+// is decoded up front and is available through Header. Samples are then
+// decoded on demand by Next, and refer to the locations of the header.
+// Merger.AddSamples merges the samples read, possibly filtered by
+// FilterSamplesByName, in memory bounded by the size of the result.
+type SampleReader struct {
+	src    io.ReaderAt
+	size   int64
+	header *Profile
+
+	stringTable []string
+	locations   map[uint64]*Location
+	filters     []*nameFilter
+
+	r *bufio.Reader // Positioned after the last sample returned by Next.
+}
+
+// NewSampleReader returns a SampleReader for the profile stored in the
+// first size bytes of r, which may be gzip-compressed. The profile is
+// read twice: once to decode its header and once, incrementally, to
+// decode its samples.
+func NewSampleReader(r io.ReaderAt, size int64) (*SampleReader, error) {
+	if size == 0 {
+		return nil, errNoData
+	}
+	sr := &SampleReader{src: r, size: size}
+
+	br, err := sr.open()
+	if err != nil {
+		return nil, err
+	}
+	p := &Profile{}
+	b := &buffer{}
+	for {
+		ok, err := readField(br, b, func(field int) bool { return field != 2 })
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing profile: %v", err)
+		}
+		if !ok || b.field >= len(profileDecoder) || profileDecoder[b.field] == nil {
+			continue
+		}
+		if err := profileDecoder[b.field](b, p); err != nil {
+			return nil, fmt.Errorf("parsing profile: %v", err)
+		}
+	}
+	if len(p.stringTable) == 0 {
+		return nil, fmt.Errorf("parsing profile: %v", errMalformed)
+	}
+
+	// postDecode releases the string table, which is still needed to
+	// decode the labels of the samples.
+	sr.stringTable = p.stringTable
+	if err := p.postDecode(); err != nil {
+		return nil, fmt.Errorf("parsing profile: %v", err)
+	}
+	sr.locations = make(map[uint64]*Location, len(p.Location))
+	for _, l := range p.Location {
+		sr.locations[l.ID] = l
+	}
+	sr.header = p
+
+	if sr.r, err = sr.open(); err != nil {
+		return nil, err
+	}
+	return sr, nil
+}
+
+// Header returns the profile without its samples. Samples returned by
+// Next refer to the locations of this profile, so Header can be used
+// to accumulate them into a complete profile if desired.
+func (sr *SampleReader) Header() *Profile {
+	return sr.header
+}
+
+// FilterSamplesByName makes Next only return the samples kept by
+// Profile.FilterSamplesByName with the same regexps, and applies hide
+// and show to the locations of the header. Its results report whether
+// each regexp matched any location of the header, rather than any
+// sample.
+func (sr *SampleReader) FilterSamplesByName(focus, ignore, hide, show *regexp.Regexp) (fm, im, hm, hnm bool) {
+	f, fm, im, hm, hnm := sr.header.nameFilter(focus, ignore, hide, show)
+	sr.filters = append(sr.filters, f)
+	return
+}
+
+// Next returns the next sample of the profile. It returns io.EOF when
+// there are no more samples, and an error if the sample is not valid
+// for the header, as CheckValid would.
+func (sr *SampleReader) Next() (*Sample, error) {
+	b := &buffer{}
+next:
+	for {
+		ok, err := readField(sr.r, b, func(field int) bool { return field == 2 })
+		if err != nil {
+			if err != io.EOF {
+				err = fmt.Errorf("parsing profile: %v", err)
+			}
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		s := new(Sample)
+		if err := decodeMessage(b, s); err != nil {
+			return nil, fmt.Errorf("parsing profile: %v", err)
+		}
+		ids := s.locationIDX
+		if err := s.postDecode(sr.stringTable, nil, sr.locations, nil); err != nil {
+			return nil, fmt.Errorf("parsing profile: %v", err)
+		}
+		if len(s.Value) != len(sr.header.SampleType) {
+			return nil, fmt.Errorf("mismatch: sample has %d values vs. %d types", len(s.Value), len(sr.header.SampleType))
+		}
+		for i, l := range s.Location {
+			if l == nil {
+				return nil, fmt.Errorf("sample has unknown location ID %d", ids[i])
+			}
+		}
+		for _, f := range sr.filters {
+			if !f.keep(s) {
+				continue next
+			}
+		}
+		return s, nil
+	}
+}
+
+// open returns a buffered reader positioned at the start of the
+// uncompressed profile data.
+func (sr *SampleReader) open() (*bufio.Reader, error) {
+	var r io.Reader = io.NewSectionReader(sr.src, 0, sr.size)
+	var magic [2]byte
+	if _, err := sr.src.ReadAt(magic[:], 0); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing profile: %v", err)
+		}
+		r = gz
+	}
+	return bufio.NewReader(r), nil
+}
+
+// readField reads the next top-level field of a profile from r into b,
+// as decodeField does for in-memory data. The contents of
+// length-delimited fields are only read if want reports true for their
+// field number; otherwise they are skipped and readField returns false.
+// It returns io.EOF if there are no more fields.
+func readField(r *bufio.Reader, b *buffer, want func(field int) bool) (bool, error) {
+	x, err := binary.ReadUvarint(r)
+	if err != nil {
+		return false, err
+	}
+	b.field = int(x >> 3)
+	b.typ = int(x & 7)
+	b.data = nil
+	b.u64 = 0
+	switch b.typ {
+	case 0:
+		b.u64, err = binary.ReadUvarint(r)
+	case 1:
+		var v [8]byte
+		_, err = io.ReadFull(r, v[:])
+		b.u64 = le64(v[:])
+	case 2:
+		var n uint64
+		if n, err = binary.ReadUvarint(r); err != nil {
+			break
+		}
+		if !want(b.field) {
+			_, err = io.CopyN(ioutil.Discard, r, int64(n))
+			if err == nil {
+				return false, nil
+			}
+			break
+		}
+		var data bytes.Buffer
+		if _, err = io.CopyN(&data, r, int64(n)); err == nil {
+			b.data = data.Bytes()
+		}
+	case 5:
+		var v [4]byte
+		_, err = io.ReadFull(r, v[:])
+		b.u64 = uint64(le32(v[:]))
+	default:
+		return false, errors.New("unknown wire type: " + fmt.Sprint(b.typ))
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return false, err
+	}
+	return want(b.field), nil
+}
@@ -0,0 +1,220 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"testing"
+
+	"github.com/lemonlinger/pprof/internal/proftest"
+)
+
+func TestSampleReader(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		prof     *Profile
+		compress bool
+	}{
+		{"testProfile1", testProfile1, false},
+		{"testProfile1 compressed", testProfile1, true},
+		{"testProfile5", testProfile5, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			var err error
+			if tc.compress {
+				err = tc.prof.Write(&buf)
+			} else {
+				err = tc.prof.WriteUncompressed(&buf)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := Parse(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+
+			sr, err := NewSampleReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatalf("NewSampleReader: %v", err)
+			}
+			got := sr.Header()
+			if len(got.Sample) != 0 {
+				t.Errorf("got %d samples in header, want none", len(got.Sample))
+			}
+			for {
+				s, err := sr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Next: %v", err)
+				}
+				got.Sample = append(got.Sample, s)
+			}
+			if got, want := got.String(), want.String(); got != want {
+				d, err := proftest.Diff([]byte(want), []byte(got))
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Errorf("streamed profile differs from parsed profile:\n%s", d)
+			}
+		})
+	}
+}
+
+func TestSampleReaderFilter(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testProfile1.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	focus, hide := regexp.MustCompile("foo"), regexp.MustCompile("^main$")
+
+	sr, err := NewSampleReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("NewSampleReader: %v", err)
+	}
+	if fm, _, hm, _ := sr.FilterSamplesByName(focus, nil, hide, nil); !fm || !hm {
+		t.Errorf("FilterSamplesByName: got matches %v, %v, want true, true", fm, hm)
+	}
+	got := sr.Header()
+	for {
+		s, err := sr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		got.Sample = append(got.Sample, s)
+	}
+
+	want, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want.FilterSamplesByName(focus, nil, hide, nil)
+	if len(want.Sample) == 0 || len(want.Sample) == len(testProfile1.Sample) {
+		t.Fatalf("filtered profile has %d samples, want some but not all", len(want.Sample))
+	}
+	if got, want := got.String(), want.String(); got != want {
+		d, err := proftest.Diff([]byte(want), []byte(got))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Errorf("streamed samples differ from filtered parsed profile:\n%s", d)
+	}
+}
+
+func TestMergerAddSamples(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testProfile1.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	focus, hide := regexp.MustCompile("foo"), regexp.MustCompile("^main$")
+
+	// The profile is merged twice, filtered both from the samples
+	// streamed and from the parsed profile.
+	m := NewMerger()
+	var profs []*Profile
+	for i := 0; i < 2; i++ {
+		sr, err := NewSampleReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("NewSampleReader: %v", err)
+		}
+		if fm, _, hm, _ := sr.FilterSamplesByName(focus, nil, hide, nil); !fm || !hm {
+			t.Errorf("FilterSamplesByName: got matches %v, %v, want true, true", fm, hm)
+		}
+		if err := m.AddSamples(sr); err != nil {
+			t.Fatalf("AddSamples: %v", err)
+		}
+
+		p, err := Parse(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		p.FilterSamplesByName(focus, nil, hide, nil)
+		profs = append(profs, p)
+	}
+	got, err := m.Profile()
+	if err != nil {
+		t.Fatalf("Profile: %v", err)
+	}
+	want, err := Merge(profs)
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if len(want.Sample) == 0 || len(want.Sample) == len(testProfile1.Sample) {
+		t.Fatalf("filtered profile has %d samples, want some but not all", len(want.Sample))
+	}
+	if got, want := got.String(), want.String(); got != want {
+		d, err := proftest.Diff([]byte(want), []byte(got))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Errorf("merged streamed profile differs from merged parsed profile:\n%s", d)
+	}
+}
+
+func TestSampleReaderError(t *testing.T) {
+	p := testProfile1.Copy()
+	var buf bytes.Buffer
+	if err := p.WriteUncompressed(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// Truncating the profile leaves a partial field at the end.
+	b := buf.Bytes()[:buf.Len()-1]
+	if _, err := NewSampleReader(bytes.NewReader(b), int64(len(b))); err == nil {
+		t.Error("NewSampleReader of truncated profile: got no error")
+	}
+	if _, err := NewSampleReader(bytes.NewReader(nil), 0); err != errNoData {
+		t.Errorf("NewSampleReader of empty input: got error %v, want %v", err, errNoData)
+	}
+
+	for _, tc := range []struct {
+		desc   string
+		modify func(p *Profile)
+	}{
+		{"unknown location", func(p *Profile) {
+			p.Sample[0].Location = append(p.Sample[0].Location, &Location{ID: 999})
+		}},
+		{"extra value", func(p *Profile) {
+			p.Sample[0].Value = append(p.Sample[0].Value, 1)
+		}},
+		{"missing value", func(p *Profile) {
+			p.Sample[0].Value = p.Sample[0].Value[:1]
+		}},
+	} {
+		p := testProfile1.Copy()
+		tc.modify(p)
+		var buf bytes.Buffer
+		if err := p.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		b := buf.Bytes()
+		sr, err := NewSampleReader(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			t.Fatalf("%s: NewSampleReader: %v", tc.desc, err)
+		}
+		if _, err := sr.Next(); err == nil || err == io.EOF {
+			t.Errorf("%s: Next got error %v, want a validation error", tc.desc, err)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RedactAction selects how Redact handles a field of a profile.
+type RedactAction string
+
+const (
+	// RedactKeep leaves the field unchanged.
+	RedactKeep RedactAction = ""
+	// RedactDrop clears the field.
+	RedactDrop RedactAction = "drop"
+	// RedactHash replaces the field with a hash of its value.
+	RedactHash RedactAction = "hash"
+)
+
+// RedactRules describes how Redact removes sensitive information from
+// a profile. Hashes are computed from Salt and the hashed value only,
+// so profiles redacted with the same rules can still be compared.
+//
+// Rules can be read from JSON with ParseRedactRules, for example:
+//
+//	{
+//	  "salt": "secret",
+//	  "functions": [
+//	    {"match": "^example.com/internal/[^.]*", "hash": true},
+//	    {"match": "customer[0-9]+", "replace": "customer"}
+//	  ],
+//	  "strip_path_prefixes": ["/home/builder/src/"],
+//	  "drop_labels": ["request_id"],
+//	  "hash_labels": ["customer_id"],
+//	  "mapping_files": "hash",
+//	  "build_ids": "drop"
+//	}
+type RedactRules struct {
+	// Salt is mixed into every hash, so that hashes of well-known
+	// values cannot be reversed without it.
+	Salt string `json:"salt"`
+	// Functions are applied in order to the names and system names of
+	// all functions.
+	Functions []RedactFunctionRule `json:"functions"`
+	// StripPathPrefixes are removed from the start of the file names
+	// of functions. Only the first matching prefix is removed.
+	StripPathPrefixes []string `json:"strip_path_prefixes"`
+	// DropLabels are the keys of the string and numeric labels to
+	// remove from samples.
+	DropLabels []string `json:"drop_labels"`
+	// HashLabels are the keys of the string labels whose values are
+	// replaced by their hashes.
+	HashLabels []string `json:"hash_labels"`
+	// MappingFiles and BuildIDs select how the file names and build
+	// IDs of mappings are handled.
+	MappingFiles RedactAction `json:"mapping_files"`
+	BuildIDs     RedactAction `json:"build_ids"`
+}
+
+// RedactFunctionRule rewrites the parts of function names that match
+// a regular expression, either with a replacement, which can refer to
+// submatches as in regexp.Regexp.ReplaceAllString, or with a hash of
+// the matched text.
+type RedactFunctionRule struct {
+	Match   string `json:"match"`
+	Replace string `json:"replace"`
+	Hash    bool   `json:"hash"`
+}
+
+// ParseRedactRules parses redaction rules in JSON and checks that they
+// are valid.
+func ParseRedactRules(data []byte) (*RedactRules, error) {
+	var r RedactRules
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("parsing redaction rules: %v", err)
+	}
+	if _, err := r.compile(); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// compile returns the regular expressions of the function rules of r,
+// and checks its actions.
+func (r *RedactRules) compile() ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, len(r.Functions))
+	for i, f := range r.Functions {
+		re, err := regexp.Compile(f.Match)
+		if err != nil {
+			return nil, fmt.Errorf("parsing function rule %q: %v", f.Match, err)
+		}
+		if f.Hash && f.Replace != "" {
+			return nil, fmt.Errorf("function rule %q both replaces and hashes", f.Match)
+		}
+		res[i] = re
+	}
+	for _, a := range []RedactAction{r.MappingFiles, r.BuildIDs} {
+		switch a {
+		case RedactKeep, RedactDrop, RedactHash:
+		default:
+			return nil, fmt.Errorf("unknown redaction action %q, must be drop or hash", a)
+		}
+	}
+	return res, nil
+}
+
+// Redact removes sensitive information from p as described by r, so
+// that it can be shared. Redaction is deterministic: the same input
+// always redacts to the same output. It returns an error if the rules
+// are invalid, in which case p is left unchanged.
+func (p *Profile) Redact(r *RedactRules) error {
+	res, err := r.compile()
+	if err != nil {
+		return err
+	}
+	hash := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		h := sha256.Sum256([]byte(r.Salt + "\x00" + s))
+		return "h" + hex.EncodeToString(h[:6])
+	}
+	redactName := func(name string) string {
+		for i, f := range r.Functions {
+			if f.Hash {
+				name = res[i].ReplaceAllStringFunc(name, hash)
+			} else {
+				name = res[i].ReplaceAllString(name, f.Replace)
+			}
+		}
+		return name
+	}
+	action := func(a RedactAction, s string) string {
+		switch a {
+		case RedactDrop:
+			return ""
+		case RedactHash:
+			return hash(s)
+		}
+		return s
+	}
+
+	for _, f := range p.Function {
+		f.Name = redactName(f.Name)
+		f.SystemName = redactName(f.SystemName)
+		for _, prefix := range r.StripPathPrefixes {
+			if strings.HasPrefix(f.Filename, prefix) {
+				f.Filename = strings.TrimPrefix(f.Filename, prefix)
+				break
+			}
+		}
+	}
+	for _, m := range p.Mapping {
+		m.File = action(r.MappingFiles, m.File)
+		m.BuildID = action(r.BuildIDs, m.BuildID)
+	}
+	for _, s := range p.Sample {
+		for _, key := range r.DropLabels {
+			delete(s.Label, key)
+			delete(s.NumLabel, key)
+			delete(s.NumUnit, key)
+		}
+		for _, key := range r.HashLabels {
+			values, ok := s.Label[key]
+			if !ok {
+				continue
+			}
+			// Label values may be shared between samples.
+			hashed := make([]string, len(values))
+			for i, v := range values {
+				hashed[i] = hash(v)
+			}
+			s.Label[key] = hashed
+		}
+	}
+	return nil
+}
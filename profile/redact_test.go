@@ -0,0 +1,138 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func redactTestProfile() *Profile {
+	m := &Mapping{ID: 1, File: "/opt/secret/server", BuildID: "abc123"}
+	f1 := &Function{ID: 1, Name: "corp.com/internal/billing.Charge", SystemName: "corp.com/internal/billing.Charge", Filename: "/home/builder/src/billing/charge.go"}
+	f2 := &Function{ID: 2, Name: "main.handleCustomer42", SystemName: "main.handleCustomer42", Filename: "/usr/lib/main.go"}
+	l1 := &Location{ID: 1, Mapping: m, Address: 0x1000, Line: []Line{{Function: f1, Line: 10}}}
+	l2 := &Location{ID: 2, Mapping: m, Address: 0x2000, Line: []Line{{Function: f2, Line: 20}}}
+	customer := []string{"acme"}
+	return &Profile{
+		SampleType: []*ValueType{{Type: "samples", Unit: "count"}},
+		Sample: []*Sample{
+			{
+				Location: []*Location{l1, l2},
+				Value:    []int64{10},
+				Label:    map[string][]string{"customer_id": customer, "request_id": {"r1"}},
+				NumLabel: map[string][]int64{"request_id": {1}},
+			},
+			{
+				Location: []*Location{l2},
+				Value:    []int64{5},
+				Label:    map[string][]string{"customer_id": customer},
+			},
+		},
+		Mapping:  []*Mapping{m},
+		Location: []*Location{l1, l2},
+		Function: []*Function{f1, f2},
+	}
+}
+
+func TestRedact(t *testing.T) {
+	rules, err := ParseRedactRules([]byte(`{
+		"salt": "s",
+		"functions": [
+			{"match": "^corp.com/internal/[^.]*", "hash": true},
+			{"match": "Customer[0-9]+", "replace": "Customer"}
+		],
+		"strip_path_prefixes": ["/home/builder/src/"],
+		"drop_labels": ["request_id"],
+		"hash_labels": ["customer_id"],
+		"mapping_files": "hash",
+		"build_ids": "drop"
+	}`))
+	if err != nil {
+		t.Fatalf("ParseRedactRules: %v", err)
+	}
+
+	p := redactTestProfile()
+	if err := p.Redact(rules); err != nil {
+		t.Fatalf("Redact: %v", err)
+	}
+	if err := p.CheckValid(); err != nil {
+		t.Fatalf("redacted profile is invalid: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	encoded, err := ParseData(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ParseData: %v", err)
+	}
+	text := encoded.String()
+	for _, secret := range []string{"internal/billing", "Customer42", "/home/builder", "/opt/secret", "abc123", "acme", "request_id", "r1"} {
+		if strings.Contains(text, secret) {
+			t.Errorf("redacted profile contains %q:\n%s", secret, text)
+		}
+	}
+
+	f1, f2 := p.Function[0], p.Function[1]
+	if !strings.HasPrefix(f1.Name, "h") || !strings.HasSuffix(f1.Name, ".Charge") || f1.SystemName != f1.Name {
+		t.Errorf("got function name %q, system name %q, want hashed package and Charge", f1.Name, f1.SystemName)
+	}
+	if f1.Filename != "billing/charge.go" {
+		t.Errorf("got file name %q, want billing/charge.go", f1.Filename)
+	}
+	if f2.Name != "main.handleCustomer" || f2.Filename != "/usr/lib/main.go" {
+		t.Errorf("got function %q in %q, want main.handleCustomer in /usr/lib/main.go", f2.Name, f2.Filename)
+	}
+	if m := p.Mapping[0]; m.BuildID != "" || m.File == "" {
+		t.Errorf("got mapping file %q, build ID %q, want a hashed file and no build ID", m.File, m.BuildID)
+	}
+	c0, c1 := p.Sample[0].Label["customer_id"], p.Sample[1].Label["customer_id"]
+	if len(c0) != 1 || len(c1) != 1 || c0[0] != c1[0] {
+		t.Errorf("got customer labels %v and %v, want the same single hash", c0, c1)
+	}
+
+	// Redaction is deterministic, so redacted profiles can be compared.
+	q := redactTestProfile()
+	if err := q.Redact(rules); err != nil {
+		t.Fatalf("Redact: %v", err)
+	}
+	if got, want := q.String(), p.String(); got != want {
+		t.Errorf("redacting twice gave different profiles:\n%s\nvs.\n%s", got, want)
+	}
+	rules.Salt = "other"
+	q = redactTestProfile()
+	if err := q.Redact(rules); err != nil {
+		t.Fatalf("Redact: %v", err)
+	}
+	if q.Function[0].Name == f1.Name {
+		t.Errorf("hash of %q does not depend on the salt", f1.Name)
+	}
+}
+
+func TestRedactRulesError(t *testing.T) {
+	for _, rules := range []string{
+		`{"functions": [{"match": "("}]}`,
+		`{"functions": [{"match": "a", "replace": "b", "hash": true}]}`,
+		`{"mapping_files": "scramble"}`,
+		`{"drop_labels": "request_id"}`,
+	} {
+		if _, err := ParseRedactRules([]byte(rules)); err == nil {
+			t.Errorf("ParseRedactRules(%s): got no error", rules)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import "fmt"
+
+// Repair fixes the defects of p that would make CheckValid fail, so
+// that profiles that are slightly malformed, such as those produced by
+// third-party tools, can still be analyzed. It returns a description
+// of each fix applied, which is empty if p was already valid.
+//
+// Nil samples and entries are dropped, sample values are padded with
+// zeros or truncated to the number of sample types, and mappings,
+// functions and locations that are referenced but missing from their
+// tables are added to them. Entries with a zero or duplicate ID are
+// given new, unused IDs.
+func (p *Profile) Repair() []string {
+	var fixes []string
+	fix := func(n int, format string, args ...interface{}) {
+		if n > 0 {
+			fixes = append(fixes, fmt.Sprintf(format, append([]interface{}{n}, args...)...))
+		}
+	}
+
+	// Samples.
+	var dropped int
+	samples := p.Sample[:0]
+	for _, s := range p.Sample {
+		if s == nil {
+			dropped++
+			continue
+		}
+		samples = append(samples, s)
+	}
+	p.Sample = samples
+	fix(dropped, "dropped %d nil samples")
+
+	if len(p.SampleType) == 0 && len(p.Sample) != 0 {
+		var n int
+		for _, s := range p.Sample {
+			if len(s.Value) > n {
+				n = len(s.Value)
+			}
+		}
+		for i := 0; i < n; i++ {
+			p.SampleType = append(p.SampleType, &ValueType{Type: fmt.Sprintf("value%d", i), Unit: "count"})
+		}
+		fix(n, "added %d missing sample types")
+	}
+	var padded, truncated, nilLocations int
+	for _, s := range p.Sample {
+		switch n := len(p.SampleType); {
+		case len(s.Value) < n:
+			s.Value = append(s.Value, make([]int64, n-len(s.Value))...)
+			padded++
+		case len(s.Value) > n:
+			s.Value = s.Value[:n]
+			truncated++
+		}
+		locs := s.Location[:0]
+		for _, l := range s.Location {
+			if l == nil {
+				nilLocations++
+				continue
+			}
+			locs = append(locs, l)
+		}
+		s.Location = locs
+	}
+	fix(padded, "padded the values of %d samples to %d sample types", len(p.SampleType))
+	fix(truncated, "truncated the values of %d samples to %d sample types", len(p.SampleType))
+	fix(nilLocations, "dropped %d nil locations from samples")
+
+	// Locations, followed by the functions and mappings they refer to,
+	// which may include those of locations added to the table.
+	var nilEntries, added int
+	seenLocations := make(map[*Location]bool, len(p.Location))
+	locations := p.Location[:0]
+	for _, l := range p.Location {
+		if l == nil {
+			nilEntries++
+			continue
+		}
+		if !seenLocations[l] {
+			seenLocations[l] = true
+			locations = append(locations, l)
+		}
+	}
+	for _, s := range p.Sample {
+		for _, l := range s.Location {
+			if !seenLocations[l] {
+				seenLocations[l] = true
+				locations = append(locations, l)
+				added++
+			}
+		}
+	}
+	p.Location = locations
+	fix(nilEntries, "dropped %d nil locations")
+	fix(added, "added %d locations missing from the location table")
+
+	nilEntries, added = 0, 0
+	seenFunctions := make(map[*Function]bool, len(p.Function))
+	functions := p.Function[:0]
+	for _, f := range p.Function {
+		if f == nil {
+			nilEntries++
+			continue
+		}
+		if !seenFunctions[f] {
+			seenFunctions[f] = true
+			functions = append(functions, f)
+		}
+	}
+	for _, l := range p.Location {
+		for _, ln := range l.Line {
+			if f := ln.Function; f != nil && !seenFunctions[f] {
+				seenFunctions[f] = true
+				functions = append(functions, f)
+				added++
+			}
+		}
+	}
+	p.Function = functions
+	fix(nilEntries, "dropped %d nil functions")
+	fix(added, "added %d functions missing from the function table")
+
+	nilEntries, added = 0, 0
+	seenMappings := make(map[*Mapping]bool, len(p.Mapping))
+	mappings := p.Mapping[:0]
+	for _, m := range p.Mapping {
+		if m == nil {
+			nilEntries++
+			continue
+		}
+		if !seenMappings[m] {
+			seenMappings[m] = true
+			mappings = append(mappings, m)
+		}
+	}
+	for _, l := range p.Location {
+		if m := l.Mapping; m != nil && !seenMappings[m] {
+			seenMappings[m] = true
+			mappings = append(mappings, m)
+			added++
+		}
+	}
+	p.Mapping = mappings
+	fix(nilEntries, "dropped %d nil mappings")
+	fix(added, "added %d mappings missing from the mapping table")
+
+	// IDs.
+	fix(renumber(len(p.Mapping), func(i int) *uint64 { return &p.Mapping[i].ID }),
+		"assigned new IDs to %d mappings with zero or duplicate IDs")
+	fix(renumber(len(p.Function), func(i int) *uint64 { return &p.Function[i].ID }),
+		"assigned new IDs to %d functions with zero or duplicate IDs")
+	fix(renumber(len(p.Location), func(i int) *uint64 { return &p.Location[i].ID }),
+		"assigned new IDs to %d locations with zero or duplicate IDs")
+
+	return fixes
+}
+
+// renumber assigns unused IDs to the entries of a table of n entries
+// that have a zero ID or the same ID as an earlier entry, where id
+// returns a pointer to the ID of the i-th entry. Entries are referenced
+// by pointer, so this does not affect references to them. It returns
+// the number of entries that were given a new ID.
+func renumber(n int, id func(i int) *uint64) int {
+	var max uint64
+	for i := 0; i < n; i++ {
+		if v := *id(i); v > max {
+			max = v
+		}
+	}
+	var count int
+	seen := make(map[uint64]bool, n)
+	for i := 0; i < n; i++ {
+		v := id(i)
+		if *v == 0 || seen[*v] {
+			max++
+			*v = max
+			count++
+		}
+		seen[*v] = true
+	}
+	return count
+}
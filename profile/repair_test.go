@@ -0,0 +1,100 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRepair(t *testing.T) {
+	if fixes := testProfile1.Copy().Repair(); len(fixes) != 0 {
+		t.Errorf("Repair of valid profile: got fixes %v, want none", fixes)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		corrupt func(p *Profile)
+		want    []string
+	}{
+		{
+			name: "nil sample",
+			corrupt: func(p *Profile) {
+				p.Sample = append(p.Sample, nil)
+			},
+			want: []string{"dropped 1 nil samples"},
+		},
+		{
+			name: "value lengths",
+			corrupt: func(p *Profile) {
+				p.Sample[0].Value = nil
+				p.Sample[1].Value = append(p.Sample[1].Value, 4, 5)
+			},
+			want: []string{
+				"padded the values of 1 samples to 2 sample types",
+				"truncated the values of 1 samples to 2 sample types",
+			},
+		},
+		{
+			name: "missing sample types",
+			corrupt: func(p *Profile) {
+				p.SampleType = nil
+			},
+			want: []string{"added 2 missing sample types"},
+		},
+		{
+			name: "dangling references",
+			corrupt: func(p *Profile) {
+				p.Sample[0].Location = append(p.Sample[0].Location, nil)
+				p.Location = p.Location[1:]
+				p.Mapping = nil
+			},
+			want: []string{
+				"dropped 1 nil locations from samples",
+				"added 1 locations missing from the location table",
+				"added 2 mappings missing from the mapping table",
+			},
+		},
+		{
+			name: "bad IDs",
+			corrupt: func(p *Profile) {
+				p.Mapping[0].ID = 0
+				p.Function[1].ID = p.Function[0].ID
+				p.Location[2].ID = p.Location[0].ID
+			},
+			want: []string{
+				"assigned new IDs to 1 mappings with zero or duplicate IDs",
+				"assigned new IDs to 1 functions with zero or duplicate IDs",
+				"assigned new IDs to 1 locations with zero or duplicate IDs",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := testProfile1.Copy()
+			tc.corrupt(p)
+			if err := p.CheckValid(); err == nil {
+				t.Fatal("corrupted profile is valid")
+			}
+			fixes := p.Repair()
+			if !reflect.DeepEqual(fixes, tc.want) {
+				t.Errorf("got fixes\n%s\nwant\n%s", strings.Join(fixes, "\n"), strings.Join(tc.want, "\n"))
+			}
+			if err := p.CheckValid(); err != nil {
+				t.Errorf("repaired profile is not valid: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// trimMaxDepth is the stack depth limit of the first attempt of
+	// TrimToSize, which is halved on each further attempt.
+	trimMaxDepth = 256
+	// trimMinFraction is the fraction of the total value dropped by the
+	// first attempt of TrimToSize, which is doubled on each further
+	// attempt.
+	trimMinFraction = 0.001
+)
+
+// TrimToSize returns a copy of p whose encoded size, as written by
+// Write, is at most maxBytes. The copy is compacted, and if that is not
+// enough, it is made approximate with increasingly aggressive attempts
+// which drop the label values and the samples with the lowest values,
+// up to a fraction of the total value, and truncate deep stacks to
+// their leaf frames. The value used is that of the default sample
+// type. A comment describing what was dropped is added to the trimmed
+// profile. It returns an error if the profile cannot fit in maxBytes.
+func (p *Profile) TrimToSize(maxBytes int) (*Profile, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("invalid maximum size %d", maxBytes)
+	}
+	q := p.Compact()
+	size, err := encodedSize(q)
+	if err != nil {
+		return nil, err
+	}
+	if size <= maxBytes {
+		return q, nil
+	}
+	index, err := p.SampleIndexByName("")
+	if err != nil || index < 0 {
+		return nil, fmt.Errorf("cannot trim profile without sample types")
+	}
+	sampleType := p.SampleType[index].Type
+
+	depth, fraction := trimMaxDepth, trimMinFraction
+	for {
+		q = p.Copy()
+		dropped := q.trim(index, depth, fraction)
+		q = q.Compact()
+		q.Comments = append(q.Comments, fmt.Sprintf("Trimmed to fit in %d bytes: dropped %.2f%% of %s, truncated stacks to %d frames and dropped labels below %.2f%% of %s",
+			maxBytes, 100*dropped, sampleType, depth, 100*fraction, sampleType))
+		if size, err = encodedSize(q); err != nil {
+			return nil, err
+		}
+		if size <= maxBytes {
+			return q, nil
+		}
+		if fraction >= 1 && depth == 1 {
+			return nil, fmt.Errorf("cannot trim profile to %d bytes, still %d bytes without samples", maxBytes, size)
+		}
+		if depth > 1 {
+			depth /= 2
+		}
+		if fraction *= 2; fraction > 1 {
+			fraction = 1
+		}
+	}
+}
+
+// trim drops the label values of p whose samples add up to less than
+// fraction of the total value at index, truncates the stacks to their
+// depth leaf frames, and drops the samples with the lowest values, up
+// to fraction of the total value. It returns the fraction of the total
+// value that was dropped.
+func (p *Profile) trim(index, depth int, fraction float64) float64 {
+	abs := func(v int64) int64 {
+		if v < 0 {
+			return -v
+		}
+		return v
+	}
+	var total int64
+	for _, s := range p.Sample {
+		total += abs(s.Value[index])
+	}
+	if total == 0 {
+		return 0
+	}
+	limit := int64(fraction * float64(total))
+
+	// Rare labels.
+	weights := make(map[string]int64)
+	labelKey := func(key, value string) string { return key + "\x00" + value }
+	for _, s := range p.Sample {
+		v := abs(s.Value[index])
+		for key, values := range s.Label {
+			for _, value := range values {
+				weights[labelKey(key, value)] += v
+			}
+		}
+		for key, values := range s.NumLabel {
+			for _, value := range values {
+				weights[labelKey(key, strconv.FormatInt(value, 10))] += v
+			}
+		}
+	}
+	for _, s := range p.Sample {
+		for key, values := range s.Label {
+			var kept []string
+			for _, value := range values {
+				if weights[labelKey(key, value)] >= limit {
+					kept = append(kept, value)
+				}
+			}
+			if len(kept) == 0 {
+				delete(s.Label, key)
+				continue
+			}
+			s.Label[key] = kept
+		}
+		for key, values := range s.NumLabel {
+			var kept []int64
+			var keptUnits []string
+			units := s.NumUnit[key]
+			for i, value := range values {
+				if weights[labelKey(key, strconv.FormatInt(value, 10))] >= limit {
+					kept = append(kept, value)
+					if i < len(units) {
+						keptUnits = append(keptUnits, units[i])
+					}
+				}
+			}
+			if len(kept) == 0 {
+				delete(s.NumLabel, key)
+				delete(s.NumUnit, key)
+				continue
+			}
+			s.NumLabel[key] = kept
+			if units != nil {
+				s.NumUnit[key] = keptUnits
+			}
+		}
+	}
+
+	// Deep stacks.
+	for _, s := range p.Sample {
+		if len(s.Location) > depth {
+			s.Location = s.Location[:depth]
+		}
+	}
+
+	// Lowest-weight samples, after merging the samples made identical
+	// by the previous steps. Ties are broken by stack so that trimming
+	// is deterministic.
+	merged := p.Compact()
+	p.Sample = merged.Sample
+	p.Location, p.Function, p.Mapping = merged.Location, merged.Function, merged.Mapping
+	order := make([]int, len(p.Sample))
+	keys := make([]string, len(p.Sample))
+	for i, s := range p.Sample {
+		order[i] = i
+		keys[i] = strings.Join(stackNames(s), "\n")
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		vi, vj := abs(p.Sample[order[i]].Value[index]), abs(p.Sample[order[j]].Value[index])
+		if vi != vj {
+			return vi < vj
+		}
+		return keys[order[i]] < keys[order[j]]
+	})
+	drop := make([]bool, len(p.Sample))
+	var dropped int64
+	for _, i := range order {
+		v := abs(p.Sample[i].Value[index])
+		if fraction < 1 && dropped+v > limit {
+			break
+		}
+		dropped += v
+		drop[i] = true
+	}
+	samples := p.Sample[:0]
+	for i, s := range p.Sample {
+		if !drop[i] {
+			samples = append(samples, s)
+		}
+	}
+	p.Sample = samples
+	return float64(dropped) / float64(total)
+}
+
+// encodedSize returns the size of p as written by Write.
+func encodedSize(p *Profile) (int, error) {
+	var w countingWriter
+	if err := p.Write(&w); err != nil {
+		return 0, err
+	}
+	return int(w), nil
+}
+
+type countingWriter int
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	*w += countingWriter(len(b))
+	return len(b), nil
+}
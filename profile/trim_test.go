@@ -0,0 +1,103 @@
+// Copyright 2018 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// trimTestProfile returns a profile with a few heavy samples and many
+// light samples with deep stacks and unique labels.
+func trimTestProfile(t *testing.T) *Profile {
+	b := NewBuilder([]*ValueType{{Type: "samples", Unit: "count"}})
+	for i := 0; i < 500; i++ {
+		var stack []Frame
+		for d := 0; d < 10; d++ {
+			stack = append(stack, Frame{Function: fmt.Sprintf("pkg%d.func%d", i, d), File: fmt.Sprintf("/src/pkg%d/file.go", i)})
+		}
+		value := int64(1)
+		if i < 5 {
+			value = 100000
+		}
+		labels := &SampleLabels{Label: map[string][]string{"request": {fmt.Sprint("req", i)}}}
+		if err := b.Add(Frames(stack...), []int64{value}, labels); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	return b.Profile()
+}
+
+func TestTrimToSize(t *testing.T) {
+	p := trimTestProfile(t)
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	full := buf.Len()
+
+	// A budget larger than the profile keeps it intact.
+	q, err := p.TrimToSize(full)
+	if err != nil {
+		t.Fatalf("TrimToSize(%d): %v", full, err)
+	}
+	if len(q.Sample) != len(p.Sample) || len(q.Comments) != 0 {
+		t.Errorf("TrimToSize(%d) trimmed a profile that fits: got %d samples, comments %v", full, len(q.Sample), q.Comments)
+	}
+
+	max := full / 4
+	q, err = p.TrimToSize(max)
+	if err != nil {
+		t.Fatalf("TrimToSize(%d): %v", max, err)
+	}
+	buf.Reset()
+	if err := q.Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if buf.Len() > max {
+		t.Errorf("TrimToSize(%d) returned a profile of %d bytes", max, buf.Len())
+	}
+	if err := q.CheckValid(); err != nil {
+		t.Fatalf("trimmed profile is invalid: %v", err)
+	}
+	if len(q.Comments) != 1 || !strings.HasPrefix(q.Comments[0], fmt.Sprintf("Trimmed to fit in %d bytes: dropped ", max)) {
+		t.Errorf("got comments %v, want a trimming note", q.Comments)
+	}
+	var total int64
+	for _, s := range q.Sample {
+		total += s.Value[0]
+	}
+	if want := int64(5 * 100000); total < want {
+		t.Errorf("trimmed profile has a total of %d, want at least the %d of the heavy samples", total, want)
+	}
+
+	// Trimming is deterministic.
+	r, err := p.TrimToSize(max)
+	if err != nil {
+		t.Fatalf("TrimToSize(%d): %v", max, err)
+	}
+	if r.String() != q.String() {
+		t.Errorf("trimming twice gave different profiles")
+	}
+
+	if _, err := p.TrimToSize(10); err == nil {
+		t.Errorf("TrimToSize(10): got no error")
+	}
+	if _, err := p.TrimToSize(0); err == nil {
+		t.Errorf("TrimToSize(0): got no error")
+	}
+}